@@ -1,7 +1,12 @@
 package api
 
 import (
+	"time"
+
 	"github.com/jinzhu/gorm"
+	"github.com/lokhman/example-users-microservice/common"
+	"github.com/lokhman/example-users-microservice/i18n"
+	"github.com/lokhman/example-users-microservice/model"
 	"github.com/nsqio/go-nsq"
 )
 
@@ -12,4 +17,45 @@ type API struct {
 
 	// NSQ producer instance
 	NSQ *nsq.Producer
+
+	// NSQ daemon address for consumers
+	NSQAddr string
+
+	// token of administrator (see `api.isAdmin`)
+	AdminToken string
+
+	// message catalogs for localized errors
+	Catalog *i18n.Catalog
+
+	// date when unversioned routes are removed
+	UnversionedSunset time.Time
+
+	// date until which v1 routes accept deprecated integer user IDs (see `api.legacyUserIDFromParam`)
+	IntegerIDSunset time.Time
+
+	// storage of avatars and the maximum size of uploaded image in bytes
+	Blobs         common.BlobStore
+	AvatarMaxSize int64
+
+	// sender of verification codes to phones
+	SMS common.SMSSender
+
+	// sender of invitations, key of invitation tokens, how long they are valid
+	// and URL of the page where invitation is accepted (see `api.invitationLink`)
+	Mailer           common.Mailer
+	InvitationSecret []byte
+	InvitationTTL    time.Duration
+	InvitationURL    string
+
+	// how long archives of data exports are available for download
+	DataExportTTL time.Duration
+
+	// key of tombstones of emails of erased users (see `api.EraseUser`)
+	ErasureSecret []byte
+
+	// how long responses to requests with `Idempotency-Key` header are kept for replay
+	IdempotencyTTL time.Duration
+
+	// organization the API is scoped to, not limited if nil (see `api.scoped`)
+	org *model.Organization
 }
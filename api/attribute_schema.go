@@ -0,0 +1,96 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lokhman/example-users-microservice/common"
+)
+
+// Maximum size of raw JSON request body in bytes.
+const maxJSONBodySize = 1 << 20
+
+var errInvalidJSON = errors.New("request body is not valid JSON")
+
+// Reads raw JSON document from request body, reports problem if it fails.
+func readJSON(c *gin.Context) ([]byte, bool) {
+	if ct := c.ContentType(); ct != "" && ct != mimeJSON {
+		abortWithProblem(c, unsupportedMediaTypeProblem)
+		return nil, false
+	}
+
+	data, err := ioutil.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxJSONBodySize))
+	if err == nil && !json.Valid(data) {
+		err = errInvalidJSON
+	}
+	if err != nil {
+		abortWithProblem(c, common.NewProblem(http.StatusBadRequest, common.ErrCodeInvalidRequest, err.Error()))
+		return nil, false
+	}
+	return data, true
+}
+
+// @Summary List schemas of custom user attributes
+// @Accept  json
+// @Produce json,application/x-msgpack,xml
+// @Success 200 {array} model.AttributeSchema
+// @Router  /v1/attribute-schemas [get]
+func (api *API) AttributeSchemaIndexHandler(c *gin.Context) {
+	schemas, err := api.ListAttributeSchemas()
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	respond(c, http.StatusOK, schemas)
+}
+
+// @Summary View schema of custom user attributes by namespace
+// @Accept  json
+// @Produce json,application/x-msgpack,xml
+// @Param   namespace path string true "Attributes namespace"
+// @Success 200 {object} model.AttributeSchema
+// @Failure 404 {object} common.Problem
+// @Router  /v1/attribute-schemas/{namespace} [get]
+func (api *API) AttributeSchemaViewHandler(c *gin.Context) {
+	schema, err := api.FindAttributeSchema(c.Param("namespace"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	respond(c, http.StatusOK, schema)
+}
+
+// @Summary Register or replace schema of custom user attributes by namespace (administrators only)
+// @Description Request body is JSON Schema (draft 4, 6 or 7) that values of the namespace are validated against on write.
+// @Accept  json
+// @Produce json,application/x-msgpack,xml
+// @Param   namespace path string true "Attributes namespace"
+// @Param   schema body object true "JSON Schema"
+// @Success 200 {object} model.AttributeSchema
+// @Failure 400 {object} common.Problem
+// @Failure 403 {object} common.Problem
+// @Failure 422 {object} common.Problem
+// @Router  /v1/attribute-schemas/{namespace} [put]
+func (api *API) AttributeSchemaSaveHandler(c *gin.Context) {
+	if !api.requireAdmin(c) {
+		return
+	}
+
+	data, ok := readJSON(c)
+	if !ok {
+		return
+	}
+
+	schema, err := api.SaveAttributeSchema(c.Param("namespace"), data)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	respond(c, http.StatusOK, schema)
+}
@@ -0,0 +1,207 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	"github.com/lokhman/example-users-microservice/common"
+	"github.com/lokhman/example-users-microservice/model"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// Prefix of query parameters that filter users by custom attributes, e.g. `?attr.billing.tier=gold`.
+const attributeQueryPrefix = "attr."
+
+// Reports that namespace of custom attributes is not registered.
+func attributeSchemaNotFoundProblem(namespace string) common.Problem {
+	return common.NewProblem(http.StatusNotFound, common.ErrCodeAttributeSchemaNotFound,
+		fmt.Sprintf(`Attributes namespace "%s" is not registered`, namespace)).WithParam("namespace", namespace)
+}
+
+// Reports that namespace name of custom attributes is invalid.
+func invalidAttributeNamespaceProblem(namespace string) common.Problem {
+	return common.NewProblem(http.StatusUnprocessableEntity, common.ErrCodeInvalidAttributeNamespace,
+		fmt.Sprintf(`Attributes namespace "%s" is invalid`, namespace)).WithParam("namespace", namespace)
+}
+
+// Reports that JSON Schema cannot be compiled.
+func invalidAttributeSchemaProblem(reason string) common.Problem {
+	return common.NewProblem(http.StatusUnprocessableEntity, common.ErrCodeInvalidAttributeSchema,
+		fmt.Sprintf("Attributes schema is invalid: %s", reason)).WithParam("reason", reason)
+}
+
+// Converts JSON Schema validation result to the problem with one error per violation.
+func attributeValidationProblem(namespace string, result *gojsonschema.Result) common.Problem {
+	p := common.NewProblem(http.StatusUnprocessableEntity, common.ErrCodeValidationFailed, "Request contains invalid fields")
+	for _, re := range result.Errors() {
+		field := "attributes." + namespace
+		if re.Field() != gojsonschema.STRING_CONTEXT_ROOT {
+			field += "." + re.Field()
+		}
+		if property, ok := re.Details()["property"].(string); ok && re.Type() == "required" {
+			field += "." + property
+		}
+		p.Errors = append(p.Errors, common.FieldError{Field: field, Rule: "schema", Param: re.Type(), Message: re.Description()})
+	}
+	return p
+}
+
+// Lists registered schemas of custom attributes.
+func (api *API) ListAttributeSchemas() ([]model.AttributeSchema, error) {
+	schemas := make([]model.AttributeSchema, 0)
+	if err := api.DB.Order("namespace").Find(&schemas).Error; err != nil {
+		return nil, err
+	}
+	return schemas, nil
+}
+
+// Finds schema of custom attributes by namespace.
+func (api *API) FindAttributeSchema(namespace string) (*model.AttributeSchema, error) {
+	var schema model.AttributeSchema
+	if !model.IsAttributeNamespace(namespace) {
+		return nil, attributeSchemaNotFoundProblem(namespace)
+	}
+	if err := api.DB.Where("namespace = ?", namespace).First(&schema).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, attributeSchemaNotFoundProblem(namespace)
+		}
+		return nil, err
+	}
+	return &schema, nil
+}
+
+// Registers or replaces JSON Schema of custom attributes in the namespace.
+// Values already stored are not revalidated, they have to match the new schema on the next write.
+func (api *API) SaveAttributeSchema(namespace string, schema []byte) (*model.AttributeSchema, error) {
+	if !model.IsAttributeNamespace(namespace) {
+		return nil, invalidAttributeNamespaceProblem(namespace)
+	}
+	if _, err := gojsonschema.NewSchema(gojsonschema.NewBytesLoader(schema)); err != nil {
+		return nil, invalidAttributeSchemaProblem(err.Error())
+	}
+
+	// schema is normalized by PostgreSQL (JSONB), so it is read back
+	err := api.DB.Exec(`
+		INSERT INTO attribute_schemas (namespace, schema, created_at, updated_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (namespace) DO UPDATE SET schema = EXCLUDED.schema, updated_at = EXCLUDED.updated_at
+	`, namespace, string(schema), gorm.NowFunc(), gorm.NowFunc()).Error
+	if err != nil {
+		return nil, err
+	}
+
+	// some meaningful logs to default logger
+	log.Printf("[attributes] schema of namespace %s was saved", namespace)
+
+	return api.FindAttributeSchema(namespace)
+}
+
+// Validates value of custom attributes against the schema of the namespace.
+func (api *API) validateAttributes(namespace string, value interface{}) error {
+	schema, err := api.FindAttributeSchema(namespace)
+	if err != nil {
+		return err
+	}
+
+	compiled, err := gojsonschema.NewSchema(gojsonschema.NewBytesLoader(schema.Schema))
+	if err != nil {
+		return err
+	}
+	result, err := compiled.Validate(gojsonschema.NewGoLoader(value))
+	if err != nil {
+		return err
+	}
+	if !result.Valid() {
+		return attributeValidationProblem(namespace, result)
+	}
+	return nil
+}
+
+// Sets (or deletes if value is nil) custom attributes of the user in the namespace,
+// records it in the audit log and publishes it to the queue.
+func (api *API) SetUserAttributes(actor Actor, id int, namespace string, value interface{}) (*model.User, error) {
+	user, err := api.FindUser(id)
+	if err != nil {
+		return nil, err
+	}
+
+	// attributes may be deleted even if the namespace is not registered anymore
+	if value != nil {
+		if err = api.validateAttributes(namespace, value); err != nil {
+			return nil, err
+		}
+	}
+
+	// user is locked, so concurrent writes to different namespaces don't overwrite each other
+	err = api.transaction(func(tx *gorm.DB) error {
+		if err := tx.Set("gorm:query_option", "FOR UPDATE").First(user, id).Error; err != nil {
+			if gorm.IsRecordNotFoundError(err) {
+				return userNotFoundProblem
+			}
+			return err
+		}
+		before := *user
+
+		user.Attributes = make(model.UserAttributes, len(before.Attributes)+1)
+		for k, v := range before.Attributes {
+			user.Attributes[k] = v
+		}
+		if value != nil {
+			user.Attributes[namespace] = value
+		} else {
+			delete(user.Attributes, namespace)
+		}
+		if err := tx.Save(user).Error; err != nil {
+			return err
+		}
+		return audit(tx, actor, model.UserAuditUpdate, &before, user)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// try to publish message to the queue under "user.update" topic (see `api.CreateUser` for more details)
+	if err = api.publishUser(TopicUserUpdate, user); err != nil {
+		return nil, err
+	}
+
+	// some meaningful logs to default logger
+	log.Printf("[users] attributes %s of user with ID %d were updated", namespace, user.ID)
+
+	return user, nil
+}
+
+// Adds condition of query parameter (e.g. `attr.billing.tier=gold`) to the filter of custom attributes.
+// Values are parsed as JSON if possible (e.g. `true` or `42`), otherwise they are strings.
+func addAttributeFilter(filter map[string]interface{}, param, value string) error {
+	path := strings.Split(strings.TrimPrefix(param, attributeQueryPrefix), ".")
+	if !model.IsAttributeNamespace(path[0]) {
+		return invalidQueryProblem(param)
+	}
+	for _, key := range path[1:] {
+		if key == "" {
+			return invalidQueryProblem(param)
+		}
+	}
+
+	var v interface{}
+	if err := json.Unmarshal([]byte(value), &v); err != nil {
+		v = value
+	}
+
+	// builds nested object of the path, which is matched by containment
+	node := filter
+	for _, key := range path[:len(path)-1] {
+		next, ok := node[key].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			node[key] = next
+		}
+		node = next
+	}
+	node[path[len(path)-1]] = v
+	return nil
+}
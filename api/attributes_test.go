@@ -0,0 +1,50 @@
+// +build !integration
+
+package api
+
+import (
+	"testing"
+
+	"github.com/lokhman/example-users-microservice/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+func TestAddAttributeFilter(t *testing.T) {
+	filter := make(map[string]interface{})
+	assert.NoError(t, addAttributeFilter(filter, "attr.billing.tier", "gold"))
+	assert.NoError(t, addAttributeFilter(filter, "attr.billing.seats", "5"))
+	assert.NoError(t, addAttributeFilter(filter, "attr.marketing.opt_in", "true"))
+	assert.NoError(t, addAttributeFilter(filter, "attr.marketing.code", `"42"`))
+	assert.Equal(t, map[string]interface{}{
+		"billing":   map[string]interface{}{"tier": "gold", "seats": float64(5)},
+		"marketing": map[string]interface{}{"opt_in": true, "code": "42"},
+	}, filter)
+
+	assert.True(t, common.IsProblem(addAttributeFilter(filter, "attr.Billing.tier", "gold"), common.ErrCodeInvalidQuery))
+	assert.True(t, common.IsProblem(addAttributeFilter(filter, "attr.billing..tier", "gold"), common.ErrCodeInvalidQuery))
+}
+
+func TestAttributeValidationProblem(t *testing.T) {
+	schema, err := gojsonschema.NewSchema(gojsonschema.NewStringLoader(`{
+		"type": "object",
+		"properties": {"tier": {"enum": ["free", "gold"]}},
+		"required": ["tier", "seats"]
+	}`))
+	assert.NoError(t, err)
+
+	result, err := schema.Validate(gojsonschema.NewGoLoader(map[string]interface{}{"tier": "platinum"}))
+	assert.NoError(t, err)
+	assert.False(t, result.Valid())
+
+	p := attributeValidationProblem("billing", result)
+	assert.Equal(t, common.ErrCodeValidationFailed, p.Code)
+	assert.Len(t, p.Errors, 2)
+
+	fields := map[string]string{}
+	for _, fe := range p.Errors {
+		assert.Equal(t, "schema", fe.Rule)
+		fields[fe.Field] = fe.Param
+	}
+	assert.Equal(t, map[string]string{"attributes.billing.seats": "required", "attributes.billing.tier": "enum"}, fields)
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"context"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/lokhman/example-users-microservice/common"
+	"github.com/lokhman/example-users-microservice/model"
+)
+
+const (
+	requestIDHeader = "X-Request-ID"
+	requestIDKey    = "requestID"
+
+	actorKey contextKey = "actor"
+)
+
+// Request IDs coming from clients (e.g. a load balancer) are accepted if they look sane.
+var requestIDRegexp = regexp.MustCompile(`^[\w.:-]{1,128}$`)
+
+// Who makes the change and in scope of which request, recorded in the audit log.
+type Actor struct {
+	Name      string
+	IP        string
+	RequestID string
+}
+
+// Actor of background jobs.
+var systemActor = Actor{Name: "system"}
+
+// Keeps `X-Request-ID` header of the request or generates a new one, and returns it in the response.
+func (api *API) RequestIDMiddleware(c *gin.Context) {
+	requestID := c.GetHeader(requestIDHeader)
+	if !requestIDRegexp.MatchString(requestID) {
+		requestID = common.NewUUIDv7()
+	}
+	c.Set(requestIDKey, requestID)
+	c.Header(requestIDHeader, requestID)
+	c.Next()
+}
+
+// Identifies who makes the request.
+// This is a stub until the service gets proper authentication: administrators are identified by `ADMIN_TOKEN`,
+// other callers are anonymous, but their addresses are recorded.
+func (api *API) actor(c *gin.Context) Actor {
+	name := "anonymous"
+	if api.isAdmin(c) {
+		name = "admin"
+	}
+	return Actor{Name: name, IP: c.ClientIP(), RequestID: c.GetString(requestIDKey)}
+}
+
+// Returns actor of the request context, see `api.actor`.
+func actorFromContext(ctx context.Context) Actor {
+	if actor, ok := ctx.Value(actorKey).(Actor); ok {
+		return actor
+	}
+	return Actor{Name: "anonymous"}
+}
+
+// Appends user changes to the audit log, either state may be nil (e.g. before creation).
+func audit(db *gorm.DB, actor Actor, action string, before, after *model.User) error {
+	changes, err := model.DiffUsers(before, after)
+	if err != nil {
+		return err
+	}
+
+	entry := model.UserAudit{
+		Action:    action,
+		Actor:     actor.Name,
+		ActorIP:   actor.IP,
+		RequestID: actor.RequestID,
+		Changes:   changes,
+	}
+	if after != nil {
+		entry.UserID = after.ID
+	} else {
+		entry.UserID = before.ID
+	}
+	return db.Create(&entry).Error
+}
+
+// Runs function in transaction, which is committed if function succeeds.
+func (api *API) transaction(fn func(tx *gorm.DB) error) error {
+	tx := api.DB.Begin()
+	if err := tx.Error; err != nil {
+		return err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+			panic(r)
+		}
+	}()
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
@@ -0,0 +1,34 @@
+package api
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lokhman/example-users-microservice/common"
+)
+
+var forbiddenProblem = common.NewProblem(http.StatusForbidden, common.ErrCodeForbidden, "Administrator privileges are required")
+
+// Checks if request is made by administrator, i.e. has `Authorization: Bearer <ADMIN_TOKEN>` header.
+// This is a stub until the service gets proper authentication, admin access is disabled if token is not set.
+func (api *API) isAdmin(c *gin.Context) bool {
+	return api.isAdminAuthorization(c.GetHeader("Authorization"))
+}
+
+// Checks if value of authorization header (or gRPC metadata) is the administrator token.
+func (api *API) isAdminAuthorization(auth string) bool {
+	if api.AdminToken == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(auth), []byte("Bearer "+api.AdminToken)) == 1
+}
+
+// Reports problem if request is not made by administrator.
+func (api *API) requireAdmin(c *gin.Context) bool {
+	if !api.isAdmin(c) {
+		abortWithProblem(c, forbiddenProblem)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,158 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+
+	"github.com/jinzhu/gorm"
+	"github.com/lokhman/example-users-microservice/common"
+	"github.com/lokhman/example-users-microservice/model"
+	_ "golang.org/x/image/webp"
+)
+
+// Sizes of square avatar thumbnails in pixels.
+var avatarSizes = []int{32, 64, 128, 256}
+
+// Image formats (as registered in `image` package) accepted as avatars.
+var avatarFormats = map[string]bool{"jpeg": true, "png": true, "webp": true}
+
+// Images with more pixels are rejected before decoding, as a small file may decode to a huge bitmap.
+const avatarMaxPixels = 25 * 1000 * 1000
+
+var invalidImageProblem = common.NewProblem(http.StatusUnprocessableEntity, common.ErrCodeInvalidImage,
+	"Avatar must be JPEG, PNG or WebP image")
+
+// Reports that avatar exceeds size limit in bytes.
+func avatarTooLargeProblem(limit int64) common.Problem {
+	return common.NewProblem(http.StatusRequestEntityTooLarge, common.ErrCodeAvatarTooLarge,
+		fmt.Sprintf("Avatar must be at most %d bytes", limit)).WithParam("limit", strconv.FormatInt(limit, 10))
+}
+
+// Decodes avatar by its content (declared type is not trusted) and turns it upright.
+func decodeAvatar(data []byte) (image.Image, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || !avatarFormats[format] || cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > avatarMaxPixels {
+		return nil, invalidImageProblem
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, invalidImageProblem
+	}
+	if format == "jpeg" {
+		img = common.OrientImage(img, common.JPEGOrientation(data))
+	}
+	return img, nil
+}
+
+// Returns file extension, content type and encoder of avatar blobs.
+// Opaque images are stored as JPEG, the ones with transparency as PNG.
+func avatarEncoder(img image.Image) (string, string, func(io.Writer, image.Image) error) {
+	if common.IsOpaqueImage(img) {
+		return ".jpg", "image/jpeg", func(w io.Writer, img image.Image) error {
+			return jpeg.Encode(w, img, &jpeg.Options{Quality: 90})
+		}
+	}
+	return ".png", "image/png", png.Encode
+}
+
+// Deletes avatar blobs, failure is only logged as it doesn't affect the user.
+func (api *API) deleteAvatar(key string) {
+	if key == "" {
+		return
+	}
+	if err := api.Blobs.DeletePrefix(key); err != nil {
+		log.Printf("[users] avatar %s cannot be deleted: %s", key, err)
+	}
+}
+
+// Replaces avatar of the user with the image (JPEG, PNG or WebP), records it in the audit log and publishes it to the queue.
+// Image is re-encoded, so metadata (e.g. EXIF with camera location) is never stored.
+func (api *API) SetUserAvatar(actor Actor, id int, data []byte) (*model.User, error) {
+	if int64(len(data)) > api.AvatarMaxSize {
+		return nil, avatarTooLargeProblem(api.AvatarMaxSize)
+	}
+
+	user, err := api.FindUser(id)
+	if err != nil {
+		return nil, err
+	}
+
+	img, err := decodeAvatar(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// every avatar gets a new key, so URLs may be cached forever
+	key := path.Join("avatars", user.PublicID, common.NewUUIDv7())
+	ext, contentType, encode := avatarEncoder(img)
+	put := func(name string, img image.Image) (string, error) {
+		var buf bytes.Buffer
+		if err := encode(&buf, img); err != nil {
+			return "", err
+		}
+		blobKey := path.Join(key, name+ext)
+		if err := api.Blobs.Put(blobKey, buf.Bytes(), contentType); err != nil {
+			return "", err
+		}
+		return api.Blobs.URL(blobKey), nil
+	}
+
+	url, err := put("original", img)
+	if err != nil {
+		api.deleteAvatar(key)
+		return nil, err
+	}
+	thumbnails := make(model.AvatarThumbnails, len(avatarSizes))
+	for i, size := range avatarSizes {
+		thumbnails[i] = model.AvatarThumbnail{Size: size}
+		if thumbnails[i].URL, err = put(strconv.Itoa(size), common.SquareThumbnail(img, size)); err != nil {
+			api.deleteAvatar(key)
+			return nil, err
+		}
+	}
+
+	// user is locked, so the replaced avatar is known for sure when uploads race
+	var replaced string
+	err = api.transaction(func(tx *gorm.DB) error {
+		if err := tx.Set("gorm:query_option", "FOR UPDATE").First(user, id).Error; err != nil {
+			if gorm.IsRecordNotFoundError(err) {
+				return userNotFoundProblem
+			}
+			return err
+		}
+		before := *user
+		replaced = before.AvatarKey
+
+		user.AvatarKey = key
+		user.AvatarURL = url
+		user.AvatarThumbnails = thumbnails
+		if err := tx.Save(user).Error; err != nil {
+			return err
+		}
+		return audit(tx, actor, model.UserAuditUpdate, &before, user)
+	})
+	if err != nil {
+		api.deleteAvatar(key)
+		return nil, err
+	}
+	api.deleteAvatar(replaced)
+
+	// try to publish message to the queue under "user.update" topic (see `api.CreateUser` for more details)
+	if err = api.publishUser(TopicUserUpdate, user); err != nil {
+		return nil, err
+	}
+
+	// some meaningful logs to default logger
+	log.Printf("[users] avatar of user with ID %d was updated", user.ID)
+
+	return user, nil
+}
@@ -0,0 +1,313 @@
+package api
+
+import (
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/lokhman/example-users-microservice/common"
+	"github.com/lokhman/example-users-microservice/model"
+)
+
+// NSQ topic of consent changes.
+const TopicConsentChanged = "consent.changed"
+
+// Reports that version of the policy is already published.
+func policyVersionExistsProblem(version string) common.Problem {
+	return common.NewProblem(http.StatusConflict, common.ErrCodePolicyVersionExists,
+		fmt.Sprintf(`Version "%s" is already published`, version)).WithParam("version", version)
+}
+
+// Reports that user has to accept policies in force before logging in, every policy is listed as field error.
+func consentRequiredProblem(policies []model.PolicyVersion) common.Problem {
+	types := make([]string, len(policies))
+	for i, policy := range policies {
+		types[i] = policy.Type
+	}
+	p := common.NewProblem(http.StatusForbidden, common.ErrCodeConsentRequired,
+		fmt.Sprintf("Policies must be accepted: %s", strings.Join(types, ", "))).WithParam("types", strings.Join(types, ", "))
+	for _, policy := range policies {
+		p.Errors = append(p.Errors, common.FieldError{
+			Field:   policy.Type,
+			Rule:    "accepted",
+			Param:   policy.Version,
+			Message: fmt.Sprintf("%s failed on the 'accepted' rule", policy.Type),
+		})
+	}
+	return p
+}
+
+// Policy version input structure.
+type PolicyInput struct {
+	Type    string `json:"type" form:"type" binding:"required" example:"terms"`
+	Version string `json:"version" form:"version" binding:"required,max=32" example:"2019-01"`
+	URL     string `json:"url" form:"url" binding:"omitempty,url,max=512" example:"https://example.com/terms/2019-01"`
+}
+
+// Consent input structure, policies are accepted in the version in force and cannot be withdrawn.
+type ConsentInput struct {
+	Type    string `json:"type" form:"type" binding:"required" example:"marketing_email"`
+	Version string `json:"version" form:"version" binding:"max=32" example:"2019-01"`
+	Granted *bool  `json:"granted" form:"granted" example:"true"`
+}
+
+// Consent of the user of every type, policies in force that are not accepted are required.
+type UserConsent struct {
+	XMLName  xml.Name   `json:"-" xml:"consent"`
+	Type     string     `json:"type" xml:"type" example:"terms"`
+	Version  string     `json:"version,omitempty" xml:"version,omitempty" example:"2019-01"`
+	Granted  bool       `json:"granted" xml:"granted" example:"true"`
+	IP       string     `json:"ip,omitempty" xml:"ip,omitempty" example:"127.0.0.1"`
+	GivenAt  *time.Time `json:"given_at" xml:"given_at,omitempty" example:"2019-01-01T00:00:00Z"`
+	InForce  string     `json:"in_force,omitempty" xml:"in_force,omitempty" example:"2019-01"`
+	Required bool       `json:"required" xml:"required" example:"false"`
+}
+
+// Message of "consent.changed" topic.
+type ConsentChangedMessage struct {
+	PublicID  string    `json:"public_id"`
+	Type      string    `json:"type"`
+	Version   string    `json:"version,omitempty"`
+	Granted   bool      `json:"granted"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// organizations as in `UserMessage`
+	OrgID  string   `json:"org_id,omitempty"`
+	OrgIDs []string `json:"org_ids"`
+}
+
+// Lists published versions of policies from the newest.
+func (api *API) ListPolicies() ([]model.PolicyVersion, error) {
+	policies := make([]model.PolicyVersion, 0)
+	if err := api.DB.Order("published_at DESC, id DESC").Find(&policies).Error; err != nil {
+		return nil, err
+	}
+	return policies, nil
+}
+
+// Publishes new version of the policy, which is in force right away, so users have to accept it on their next login.
+func (api *API) PublishPolicy(in PolicyInput) (*model.PolicyVersion, error) {
+	if err := validate(&in); err != nil {
+		return nil, err
+	}
+	if !model.IsPolicyType(in.Type) {
+		return nil, common.FieldProblem("type", "oneof", model.ConsentTerms+" "+model.ConsentPrivacy)
+	}
+
+	policy := model.PolicyVersion{Type: in.Type, Version: in.Version, URL: in.URL, PublishedAt: gorm.NowFunc()}
+	if err := api.DB.Create(&policy).Error; err != nil {
+		if common.IsUniqueConstraintError(err, model.PolicyVersionUniqueConstraintName) {
+			return nil, policyVersionExistsProblem(in.Version)
+		}
+		return nil, err
+	}
+
+	// some meaningful logs to default logger
+	log.Printf("[users] version %s of %s policy was published", policy.Version, policy.Type)
+
+	return &policy, nil
+}
+
+// Finds versions of policies in force by type.
+func policiesInForce(db *gorm.DB) (map[string]model.PolicyVersion, error) {
+	var policies []model.PolicyVersion
+	if err := db.Raw("SELECT DISTINCT ON (type) * FROM policy_versions ORDER BY type, published_at DESC, id DESC").Scan(&policies).Error; err != nil {
+		return nil, err
+	}
+	byType := make(map[string]model.PolicyVersion, len(policies))
+	for _, policy := range policies {
+		byType[policy.Type] = policy
+	}
+	return byType, nil
+}
+
+// Finds consents of the user in effect (the latest records) by type.
+func consentsInEffect(db *gorm.DB, id int) (map[string]model.Consent, error) {
+	var consents []model.Consent
+	if err := db.Raw("SELECT DISTINCT ON (type) * FROM consents WHERE user_id = ? ORDER BY type, id DESC", id).Scan(&consents).Error; err != nil {
+		return nil, err
+	}
+	byType := make(map[string]model.Consent, len(consents))
+	for _, consent := range consents {
+		byType[consent.Type] = consent
+	}
+	return byType, nil
+}
+
+// Lists policies in force the user by ID has not accepted.
+func (api *API) requiredPolicies(id int) ([]model.PolicyVersion, error) {
+	policies, err := policiesInForce(api.DB)
+	if err != nil {
+		return nil, err
+	}
+	consents, err := consentsInEffect(api.DB, id)
+	if err != nil {
+		return nil, err
+	}
+
+	required := make([]model.PolicyVersion, 0)
+	for _, t := range model.ConsentTypes {
+		policy, ok := policies[t]
+		if !ok {
+			continue
+		}
+		if consent := consents[t]; !consent.Granted || consent.Version != policy.Version {
+			required = append(required, policy)
+		}
+	}
+	return required, nil
+}
+
+// Lists consents of the user by ID of every type.
+func (api *API) ListUserConsents(id int) ([]UserConsent, error) {
+	user, err := api.FindUser(id)
+	if err != nil {
+		return nil, err
+	}
+
+	policies, err := policiesInForce(api.DB)
+	if err != nil {
+		return nil, err
+	}
+	consents, err := consentsInEffect(api.DB, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]UserConsent, len(model.ConsentTypes))
+	for i, t := range model.ConsentTypes {
+		out[i] = UserConsent{Type: t}
+		if consent, ok := consents[t]; ok {
+			givenAt := consent.CreatedAt
+			out[i].Version = consent.Version
+			out[i].Granted = consent.Granted
+			out[i].IP = consent.IP
+			out[i].GivenAt = &givenAt
+		}
+		if policy, ok := policies[t]; ok {
+			out[i].InForce = policy.Version
+			out[i].Required = !out[i].Granted || out[i].Version != policy.Version
+		}
+	}
+	return out, nil
+}
+
+// Records consent of the user by ID and publishes it to the queue, consent that doesn't change is not recorded.
+// Policies are accepted in the version in force, marketing consents may be granted or withdrawn.
+func (api *API) GiveUserConsent(actor Actor, id int, in ConsentInput) ([]UserConsent, error) {
+	if err := validate(&in); err != nil {
+		return nil, err
+	}
+	if !model.IsConsentType(in.Type) {
+		return nil, common.FieldProblem("type", "oneof", strings.Join(model.ConsentTypes, " "))
+	}
+	granted := in.Granted == nil || *in.Granted
+
+	user, err := api.FindUser(id)
+	if err != nil {
+		return nil, err
+	}
+
+	version := ""
+	if model.IsPolicyType(in.Type) {
+		if !granted {
+			return nil, common.FieldProblem("granted", "oneof", "true")
+		}
+		policies, err := policiesInForce(api.DB)
+		if err != nil {
+			return nil, err
+		}
+		policy, ok := policies[in.Type]
+		if !ok || in.Version != policy.Version {
+			return nil, common.FieldProblem("version", "in_force", policy.Version)
+		}
+		version = policy.Version
+	}
+
+	if err = api.recordConsent(actor, user, in.Type, version, granted); err != nil {
+		return nil, err
+	}
+	return api.ListUserConsents(user.ID)
+}
+
+// Records consent of the user and publishes it to the queue, unless it is the consent in effect.
+// User row is locked, so concurrent requests don't record the same consent twice.
+func (api *API) recordConsent(actor Actor, user *model.User, consentType, version string, granted bool) error {
+	consent := model.Consent{UserID: user.ID, Type: consentType, Version: version, Granted: granted, IP: actor.IP}
+	changed := false
+	err := api.transaction(func(tx *gorm.DB) error {
+		if err := tx.Set("gorm:query_option", "FOR UPDATE").First(&model.User{}, user.ID).Error; err != nil {
+			return err
+		}
+		consents, err := consentsInEffect(tx, user.ID)
+		if err != nil {
+			return err
+		}
+		if current, ok := consents[consentType]; ok && current.Version == version && current.Granted == granted {
+			return nil
+		}
+		changed = true
+		return tx.Create(&consent).Error
+	})
+	if err != nil || !changed {
+		return err
+	}
+
+	// try to publish message to the queue under "consent.changed" topic (see `api.CreateUser` for more details)
+	orgIDs, err := userOrgIDs(api.DB, user.ID)
+	if err != nil {
+		return err
+	}
+	tagged := api.userMessage(user, orgIDs[user.ID])
+	message := ConsentChangedMessage{
+		PublicID:  user.PublicID,
+		Type:      consent.Type,
+		Version:   consent.Version,
+		Granted:   consent.Granted,
+		CreatedAt: consent.CreatedAt,
+		OrgID:     tagged.OrgID,
+		OrgIDs:    tagged.OrgIDs,
+	}
+	if err = common.NSQPublish(api.NSQ, TopicConsentChanged, message); err != nil {
+		return err
+	}
+
+	// some meaningful logs to default logger
+	log.Printf("[users] %s consent of user with ID %d was changed", consent.Type, user.ID)
+
+	return nil
+}
+
+// Accepts policies in force the user hasn't accepted yet by types given with login,
+// reports problem (and accepts nothing) if any of them is not given.
+func (api *API) acceptPolicies(actor Actor, user *model.User, types []string) error {
+	required, err := api.requiredPolicies(user.ID)
+	if err != nil || len(required) == 0 {
+		return err
+	}
+
+	accepted := make(map[string]bool, len(types))
+	for _, t := range types {
+		accepted[t] = true
+	}
+	remaining := make([]model.PolicyVersion, 0)
+	for _, policy := range required {
+		if !accepted[policy.Type] {
+			remaining = append(remaining, policy)
+		}
+	}
+	if len(remaining) > 0 {
+		return consentRequiredProblem(remaining)
+	}
+
+	for _, policy := range required {
+		if err = api.recordConsent(actor, user, policy.Type, policy.Version, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
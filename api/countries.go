@@ -0,0 +1,72 @@
+package api
+
+import (
+	"encoding/xml"
+	"sort"
+
+	"github.com/lokhman/example-users-microservice/common"
+	"github.com/lokhman/example-users-microservice/model"
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+	"golang.org/x/text/language/display"
+)
+
+// ISO 3166-1 country with localized name and number of users.
+type Country struct {
+	XMLName xml.Name `json:"-" xml:"country"`
+	Code    string   `json:"code" xml:"code" example:"GB"`
+	Alpha3  string   `json:"alpha3" xml:"alpha3" example:"GBR"`
+	Numeric string   `json:"numeric" xml:"numeric" example:"826"`
+	Name    string   `json:"name" xml:"name" example:"United Kingdom"`
+	Users   int      `json:"users" xml:"users" example:"42"`
+}
+
+// Counts users (that are not deleted) by country.
+func (api *API) countUsersByCountry() (map[string]int, error) {
+	rows, err := api.scopeUsers(api.DB.Model(&model.User{})).Select("country, count(*)").Group("country").Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var country string
+		var n int
+		if err = rows.Scan(&country, &n); err != nil {
+			return nil, err
+		}
+		counts[country] = n
+	}
+	return counts, rows.Err()
+}
+
+// Lists all ISO 3166-1 countries with names in the language (English names of the standard if language is not
+// supported) ordered by name, so the list may be used as is for a country selector.
+func (api *API) ListCountries(lang string) ([]Country, error) {
+	counts, err := api.countUsersByCountry()
+	if err != nil {
+		return nil, err
+	}
+
+	tag := language.Make(lang)
+	namer := display.Regions(tag)
+
+	out := make([]Country, 0, len(common.Countries()))
+	for _, c := range common.Countries() {
+		name := c.Name
+		if region, err := language.ParseRegion(c.Alpha2); err == nil && namer != nil {
+			if localized := namer.Name(region); localized != "" {
+				name = localized
+			}
+		}
+		out = append(out, Country{Code: c.Alpha2, Alpha3: c.Alpha3, Numeric: c.Numeric, Name: name, Users: counts[c.Alpha2]})
+	}
+
+	// names are ordered by the rules of the language (e.g. "Österreich" goes before "Peru" in German)
+	collator := collate.New(tag)
+	sort.SliceStable(out, func(i, j int) bool {
+		return collator.CompareString(out[i].Name, out[j].Name) < 0
+	})
+	return out, nil
+}
@@ -0,0 +1,30 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// @Summary List ISO 3166-1 countries with number of users
+// @Description Country names are translated according to `Accept-Language` header.
+// @Accept  json
+// @Produce json,application/x-msgpack,xml
+// @Param   Accept-Language header string false "Language of country names"
+// @Success 200 {array} api.Country
+// @Router  /v1/countries [get]
+func (api *API) CountryIndexHandler(c *gin.Context) {
+	api = api.scoped(c)
+
+	// names are in the same language as messages of the catalog
+	lang := api.Catalog.Match(c.GetHeader("Accept-Language"))
+
+	countries, err := api.ListCountries(lang)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.Header("Content-Language", lang)
+	respond(c, http.StatusOK, countries)
+}
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lokhman/example-users-microservice/model"
+)
+
+// @Summary View status of data export by ID (administrators only)
+// @Description Archive of completed export is downloaded by `download_url` until `expires_at`.
+// @Accept  json
+// @Produce json,application/x-msgpack,xml
+// @Param   id path string true "Data export public ID" format(uuid)
+// @Success 200 {object} api.DataExport
+// @Failure 403 {object} common.Problem
+// @Failure 404 {object} common.Problem
+// @Router  /v1/exports/{id} [get]
+func (api *API) DataExportViewHandler(c *gin.Context) {
+	api = api.scoped(c)
+
+	if !api.requireAdmin(c) {
+		return
+	}
+
+	export, err := api.FindDataExport(c.Param("id"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	// archive is downloaded from the route next to this one of the same version
+	if export.Status == model.DataExportStatusCompleted {
+		export.DownloadURL = c.Request.URL.Path + "/download"
+	}
+
+	respond(c, http.StatusOK, export)
+}
+
+// @Summary Download archive of completed data export by ID (administrators only)
+// @Produce application/zip
+// @Param   id path string true "Data export public ID" format(uuid)
+// @Success 200 {file} file
+// @Failure 403 {object} common.Problem
+// @Failure 404 {object} common.Problem
+// @Failure 409 {object} common.Problem
+// @Router  /v1/exports/{id}/download [get]
+func (api *API) DataExportDownloadHandler(c *gin.Context) {
+	api = api.scoped(c)
+
+	if !api.requireAdmin(c) {
+		return
+	}
+
+	data, err := api.ReadDataExport(c.Param("id"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.Header("Content-Disposition", `attachment; filename="export-`+c.Param("id")+`.zip"`)
+	c.Header("Cache-Control", "no-store")
+	c.Data(http.StatusOK, "application/zip", data)
+}
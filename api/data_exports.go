@@ -0,0 +1,358 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/lokhman/example-users-microservice/common"
+	"github.com/lokhman/example-users-microservice/model"
+)
+
+var (
+	dataExportNotFoundProblem = common.NewProblem(http.StatusNotFound, common.ErrCodeDataExportNotFound,
+		"Data export cannot be found")
+	dataExportNotAvailableProblem = common.NewProblem(http.StatusConflict, common.ErrCodeDataExportNotAvailable,
+		"Data export is not completed or has expired")
+)
+
+// Data export representation.
+type DataExport struct {
+	XMLName     xml.Name   `gorm:"-" json:"-" xml:"export"`
+	ID          string     `json:"id" xml:"id" example:"01890a5d-ac96-774b-bcce-b302099a8057"`
+	UserID      string     `json:"user_id" xml:"user_id" example:"01890a5d-ac96-774b-bcce-b302099a8057"`
+	Status      string     `json:"status" xml:"status" example:"completed"`
+	RequestedBy string     `json:"requested_by" xml:"requested_by" example:"admin"`
+	DownloadURL string     `json:"download_url,omitempty" xml:"download_url,omitempty" example:"/v1/exports/01890a5e-0c1f-7d3a-9a4e-4f1b2c3d4e5f/download"`
+	Size        int64      `json:"size,omitempty" xml:"size,omitempty" example:"4096"`
+	Error       string     `json:"error,omitempty" xml:"error,omitempty" example:""`
+	CreatedAt   time.Time  `json:"created_at" xml:"created_at" example:"2019-01-01T00:00:00Z"`
+	CompletedAt *time.Time `json:"completed_at" xml:"completed_at,omitempty" example:"2019-01-01T00:00:00Z"`
+	ExpiresAt   *time.Time `json:"expires_at" xml:"expires_at,omitempty" example:"2019-01-08T00:00:00Z"`
+}
+
+// Manifest of data export archive, stored as "manifest.json" next to the files it describes.
+type DataExportManifest struct {
+	ExportID    string           `json:"export_id"`
+	UserID      string           `json:"user_id"`
+	GeneratedAt time.Time        `json:"generated_at"`
+	Files       []DataExportFile `json:"files"`
+}
+
+// File of data export archive with number of records and SHA-256 checksum of the content.
+type DataExportFile struct {
+	Name    string `json:"name"`
+	Records int    `json:"records"`
+	SHA256  string `json:"sha256"`
+}
+
+// Membership of the user in data export.
+type dataExportMembership struct {
+	OrganizationID string    `json:"organization_id"`
+	Name           string    `json:"name"`
+	Role           string    `json:"role"`
+	JoinedAt       time.Time `json:"joined_at"`
+}
+
+// Consent of the user in data export, all records are exported as the history.
+type dataExportConsent struct {
+	Type    string    `json:"type"`
+	Version string    `json:"version,omitempty"`
+	Granted bool      `json:"granted"`
+	IP      string    `json:"ip,omitempty"`
+	GivenAt time.Time `json:"given_at"`
+}
+
+// columns of data export representation (see `api.dataExports`)
+const dataExportColumns = "data_exports.public_id AS id, users.public_id AS user_id, data_exports.status, data_exports.requested_by, " +
+	"data_exports.size, data_exports.error, data_exports.created_at, data_exports.completed_at, data_exports.expires_at"
+
+// Query of data export representations, scoped API sees only exports of members of its organization.
+func (api *API) dataExports() *gorm.DB {
+	return api.scopeUsers(api.DB.Table("data_exports").Select(dataExportColumns).
+		Joins("JOIN users ON users.id = data_exports.user_id"))
+}
+
+// Finds representation of the data export by internal ID.
+func (api *API) dataExport(id int) (*DataExport, error) {
+	var out DataExport
+	if err := api.dataExports().Where("data_exports.id = ?", id).Scan(&out).Error; err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Finds data export by public ID.
+func (api *API) FindDataExport(publicID string) (*DataExport, error) {
+	if !common.IsUUID(publicID) {
+		return nil, dataExportNotFoundProblem
+	}
+
+	var out DataExport
+	if err := api.dataExports().Where("data_exports.public_id = ?", publicID).Scan(&out).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, dataExportNotFoundProblem
+		}
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Reads archive of the completed data export by public ID, scoped API reads only exports of members of its organization.
+func (api *API) ReadDataExport(publicID string) ([]byte, error) {
+	if !common.IsUUID(publicID) {
+		return nil, dataExportNotFoundProblem
+	}
+
+	var export model.DataExport
+	err := api.scopeUsers(api.DB.Select("data_exports.*").Joins("JOIN users ON users.id = data_exports.user_id")).
+		Where("data_exports.public_id = ?", publicID).First(&export).Error
+	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, dataExportNotFoundProblem
+		}
+		return nil, err
+	}
+	if export.Status != model.DataExportStatusCompleted || export.BlobKey == "" {
+		return nil, dataExportNotAvailableProblem
+	}
+	return api.Blobs.Get(export.BlobKey)
+}
+
+// Requests export of everything stored about the user by ID, the archive is assembled in background
+// (see `api.ProcessDataExports`). Pending export of the user is returned instead of a new one.
+func (api *API) RequestDataExport(actor Actor, id int) (*DataExport, error) {
+	user, err := api.FindUser(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var export model.DataExport
+	err = api.DB.Where("user_id = ? AND status = ?", user.ID, model.DataExportStatusPending).First(&export).Error
+	if gorm.IsRecordNotFoundError(err) {
+		export = model.DataExport{PublicID: common.NewUUIDv7(), UserID: user.ID, RequestedBy: actor.Name}
+		err = api.DB.Create(&export).Error
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// some meaningful logs to default logger
+	log.Printf("[users] data export with ID %d of user with ID %d was requested", export.ID, user.ID)
+
+	return api.dataExport(export.ID)
+}
+
+// Assembles up to `limit` pending data exports, returns number of processed exports.
+// Every export is assembled in its own transaction with the row locked, so other nodes skip it,
+// and it stays pending (to be retried) if the node fails in the middle.
+func (api *API) ProcessDataExports(limit int) (int, error) {
+	for n := 0; n < limit; n++ {
+		ok, err := api.processDataExport()
+		if err != nil || !ok {
+			return n, err
+		}
+	}
+	return limit, nil
+}
+
+// Assembles the oldest pending data export, returns false if there is none.
+func (api *API) processDataExport() (bool, error) {
+	var export model.DataExport
+	var key string
+	err := api.transaction(func(tx *gorm.DB) error {
+		err := tx.Set("gorm:query_option", "FOR UPDATE SKIP LOCKED").
+			Where("status = ?", model.DataExportStatusPending).Order("id").First(&export).Error
+		if err != nil {
+			return err
+		}
+
+		now := gorm.NowFunc()
+		user, data, err := api.assembleDataExport(&export, now)
+		if err != nil {
+			// failure is recorded, so the export is not retried forever
+			log.Printf("[users] data export with ID %d failed: %s", export.ID, err)
+			return tx.Model(&export).Updates(map[string]interface{}{
+				"status": model.DataExportStatusFailed,
+				"error":  err.Error(),
+			}).Error
+		}
+
+		// archives are private (see `api.IsPrivateBlob`) and downloaded by administrators only
+		key = path.Join(dataExportPrefix(user.PublicID), common.NewUUIDv7()+".zip")
+		if err = api.Blobs.Put(key, data, "application/zip"); err != nil {
+			key = ""
+			return err
+		}
+		return tx.Model(&export).Updates(map[string]interface{}{
+			"status":       model.DataExportStatusCompleted,
+			"blob_key":     key,
+			"size":         len(data),
+			"completed_at": now,
+			"expires_at":   now.Add(api.DataExportTTL),
+		}).Error
+	})
+	if gorm.IsRecordNotFoundError(err) {
+		return false, nil
+	}
+	if err != nil {
+		api.deleteDataExport(key)
+		return false, err
+	}
+
+	// some meaningful logs to default logger
+	log.Printf("[users] data export with ID %d was processed", export.ID)
+
+	return true, nil
+}
+
+// Assembles ZIP archive of the data export with JSON files and the manifest.
+// Service keeps no sessions (logging in is stateless), so there are none to export.
+func (api *API) assembleDataExport(export *model.DataExport, now time.Time) (*model.User, []byte, error) {
+	var user model.User
+	if err := api.DB.First(&user, export.UserID).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, nil, userNotFoundProblem
+		}
+		return nil, nil, err
+	}
+
+	var stored model.UserPreferences
+	if err := api.DB.Where("user_id = ?", user.ID).First(&stored).Error; err != nil && !gorm.IsRecordNotFoundError(err) {
+		return nil, nil, err
+	}
+	preferences := stored.Resolve()
+
+	entries := make([]model.UserAudit, 0)
+	if err := api.DB.Where("user_id = ?", user.ID).Order("id").Find(&entries).Error; err != nil {
+		return nil, nil, err
+	}
+
+	memberships := make([]dataExportMembership, 0)
+	err := api.DB.Table("memberships").
+		Select("organizations.public_id AS organization_id, organizations.name, memberships.role, memberships.created_at AS joined_at").
+		Joins("JOIN organizations ON organizations.id = memberships.organization_id").
+		Where("memberships.user_id = ?", user.ID).Order("memberships.organization_id").Scan(&memberships).Error
+	if err != nil {
+		return nil, nil, err
+	}
+
+	groups, err := api.ListUserGroups(user.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	invitations := make([]Invitation, 0)
+	if err = api.invitations().Where("invitations.user_id = ?", user.ID).Order("invitations.id").Scan(&invitations).Error; err != nil {
+		return nil, nil, err
+	}
+
+	consents := make([]dataExportConsent, 0)
+	err = api.DB.Table("consents").Select("type, version, granted, ip, created_at AS given_at").
+		Where("user_id = ?", user.ID).Order("id").Scan(&consents).Error
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	manifest := DataExportManifest{ExportID: export.PublicID, UserID: user.PublicID, GeneratedAt: now}
+	add := func(name string, records int, v interface{}) error {
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: now})
+		if err != nil {
+			return err
+		}
+		if _, err = w.Write(data); err != nil {
+			return err
+		}
+		sum := sha256.Sum256(data)
+		manifest.Files = append(manifest.Files, DataExportFile{Name: name, Records: records, SHA256: hex.EncodeToString(sum[:])})
+		return nil
+	}
+
+	files := []struct {
+		name    string
+		records int
+		data    interface{}
+	}{
+		{"profile.json", 1, user},
+		{"preferences.json", 1, preferences},
+		{"audit.json", len(entries), entries},
+		{"organizations.json", len(memberships), memberships},
+		{"groups.json", len(groups), groups},
+		{"invitations.json", len(invitations), invitations},
+		{"consents.json", len(consents), consents},
+	}
+	for _, f := range files {
+		if err = add(f.name, f.records, f.data); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err = add("manifest.json", len(manifest.Files), manifest); err != nil {
+		return nil, nil, err
+	}
+	if err = zw.Close(); err != nil {
+		return nil, nil, err
+	}
+	return &user, buf.Bytes(), nil
+}
+
+// Deletes archives of completed data exports that expired before the time, returns number of expired exports.
+func (api *API) ExpireDataExports(before time.Time, limit int) (int, error) {
+	var exports []model.DataExport
+	err := api.DB.Where("status = ? AND expires_at < ?", model.DataExportStatusCompleted, before).
+		Order("id").Limit(limit).Find(&exports).Error
+	if err != nil {
+		return 0, err
+	}
+
+	for i := range exports {
+		api.deleteDataExport(exports[i].BlobKey)
+		err = api.DB.Model(&exports[i]).Updates(map[string]interface{}{
+			"status":   model.DataExportStatusExpired,
+			"blob_key": "",
+		}).Error
+		if err != nil {
+			return i, err
+		}
+		log.Printf("[users] data export with ID %d expired", exports[i].ID)
+	}
+	return len(exports), nil
+}
+
+// Key prefix of data export archives.
+const dataExportsPrefix = "exports"
+
+// Returns key prefix of data export archives of the user by public ID.
+func dataExportPrefix(userID string) string {
+	return path.Join(dataExportsPrefix, userID)
+}
+
+// Checks if the blob must not be served publicly, i.e. it is archive of data export.
+func (api *API) IsPrivateBlob(key string) bool {
+	key = path.Clean("/" + key)
+	return key == "/"+dataExportsPrefix || strings.HasPrefix(key, "/"+dataExportsPrefix+"/")
+}
+
+// Deletes archive of data export (or all archives of the user by prefix), failure is only logged.
+func (api *API) deleteDataExport(key string) {
+	if key == "" {
+		return
+	}
+	if err := api.Blobs.DeletePrefix(key); err != nil {
+		log.Printf("[users] data export %s cannot be deleted: %s", key, err)
+	}
+}
@@ -0,0 +1,19 @@
+// +build !integration
+
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsPrivateBlob(t *testing.T) {
+	api := &API{}
+	assert.True(t, api.IsPrivateBlob("/exports/01890a5d-ac96-774b-bcce-b302099a8057/export.zip"))
+	assert.True(t, api.IsPrivateBlob("exports"))
+	assert.True(t, api.IsPrivateBlob("/avatars/../exports/export.zip"))
+	assert.True(t, api.IsPrivateBlob("//exports/./export.zip"))
+	assert.False(t, api.IsPrivateBlob("/avatars/01890a5d-ac96-774b-bcce-b302099a8057/64.png"))
+	assert.False(t, api.IsPrivateBlob("/exports-public/file"))
+}
@@ -0,0 +1,21 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Marks responses of deprecated routes with `Deprecation` and `Sunset` (RFC 8594) headers,
+// and links the same resource of the successor version mounted under the prefix.
+func (api *API) DeprecationMiddleware(successorPrefix string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Deprecation", "true")
+		if !api.UnversionedSunset.IsZero() {
+			c.Header("Sunset", api.UnversionedSunset.UTC().Format(http.TimeFormat))
+		}
+		c.Header("Link", fmt.Sprintf(`<%s%s>; rel="successor-version"`, successorPrefix, c.Request.URL.RequestURI()))
+		c.Next()
+	}
+}
@@ -0,0 +1,30 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// @Summary List users whose emails are the same after normalization (administrators only)
+// @Description Emails are compared in lower case with internationalized domains in ASCII (and Gmail rules if enabled).
+// @Accept  json
+// @Produce json,application/x-msgpack,xml
+// @Success 200 {array} api.EmailDuplicate
+// @Failure 403 {object} common.Problem
+// @Router  /v1/email-duplicates [get]
+func (api *API) EmailDuplicateIndexHandler(c *gin.Context) {
+	api = api.scoped(c)
+
+	if !api.requireAdmin(c) {
+		return
+	}
+
+	duplicates, err := api.FindEmailDuplicates()
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	respond(c, http.StatusOK, duplicates)
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"encoding/xml"
+	"sort"
+	"time"
+
+	"github.com/lokhman/example-users-microservice/common"
+	"github.com/lokhman/example-users-microservice/model"
+)
+
+// Group of users (that are not deleted) whose emails are the same after normalization.
+type EmailDuplicate struct {
+	XMLName         xml.Name             `json:"-" xml:"duplicate"`
+	NormalizedEmail string               `json:"normalized_email" xml:"normalized_email" example:"alex.lokhman@gmail.com"`
+	Users           []EmailDuplicateUser `json:"users" xml:"users>user"`
+}
+
+// User of the duplicate group with details that help to choose the account to keep.
+type EmailDuplicateUser struct {
+	PublicID    string     `json:"public_id" xml:"public_id" example:"01890a5d-ac96-774b-bcce-b302099a8057"`
+	Email       string     `json:"email" xml:"email" example:"Alex.Lokhman@gmail.com"`
+	Status      string     `json:"status" xml:"status" example:"active"`
+	CreatedAt   time.Time  `json:"created_at" xml:"created_at" example:"2019-01-01T00:00:00Z"`
+	LastLoginAt *time.Time `json:"last_login_at" xml:"last_login_at,omitempty" example:"2019-01-01T00:00:00Z"`
+}
+
+// Normalizes email of the input, reports problem of the field if it fails.
+func normalizeEmail(email string) (string, error) {
+	normalized, err := common.NormalizeEmail(email)
+	if err != nil {
+		return "", common.FieldProblem("email", "email", "")
+	}
+	return normalized, nil
+}
+
+// Finds groups of users (that are not deleted) whose emails collide after normalization with the current rules.
+// Users registered before emails were normalized (or before `common.EmailProviderRules` was enabled) may have
+// such duplicates, they are not merged automatically and have to be resolved (e.g. deleted) by administrators.
+func (api *API) FindEmailDuplicates() ([]EmailDuplicate, error) {
+	rows, err := api.scopeUsers(api.DB.Model(&model.User{})).Select("public_id, email, status, created_at, last_login_at").Order("id").Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	// normalization can't be done by the database, so all emails are scanned
+	groups := make(map[string][]EmailDuplicateUser)
+	for rows.Next() {
+		var u EmailDuplicateUser
+		if err = rows.Scan(&u.PublicID, &u.Email, &u.Status, &u.CreatedAt, &u.LastLoginAt); err != nil {
+			return nil, err
+		}
+		email, err := common.NormalizeEmail(u.Email)
+		if err != nil {
+			email = u.Email
+		}
+		groups[email] = append(groups[email], u)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	out := make([]EmailDuplicate, 0)
+	for email, users := range groups {
+		if len(users) > 1 {
+			out = append(out, EmailDuplicate{NormalizedEmail: email, Users: users})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].NormalizedEmail < out[j].NormalizedEmail
+	})
+	return out, nil
+}
@@ -0,0 +1,480 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/graph-gophers/graphql-go"
+	"github.com/lokhman/example-users-microservice/common"
+	"github.com/lokhman/example-users-microservice/model"
+)
+
+const graphqlSchema = `
+schema {
+	query: Query
+	mutation: Mutation
+}
+
+scalar Time
+
+type Query {
+	user(id: ID!): User
+	users(filter: UserFilter, first: Int = 20, after: String): UserConnection!
+}
+
+type Mutation {
+	createUser(input: UserInput!): User!
+	updateUser(id: ID!, input: UserInput!): User!
+	deleteUser(id: ID!): Boolean!
+}
+
+input UserFilter {
+	country: String
+	status: UserStatus
+	createdAfter: Time
+	createdBefore: Time
+}
+
+input UserInput {
+	email: String!
+	password: String!
+	firstName: String!
+	lastName: String!
+	nickname: String!
+	country: String!
+	phone: String
+}
+
+type User {
+	id: ID!
+	email: String!
+	firstName: String!
+	lastName: String!
+	nickname: String!
+	country: String!
+	phone: String
+	phoneVerified: Boolean!
+	status: UserStatus!
+	statusReason: String
+	statusExpiresAt: Time
+	# the smallest square thumbnail at least of the size (in pixels) or the original image if size is not given
+	avatarUrl(size: Int): String
+	createdAt: Time!
+	updatedAt: Time!
+	lastLoginAt: Time
+}
+
+enum UserStatus {
+	PENDING
+	ACTIVE
+	SUSPENDED
+	BANNED
+}
+
+type UserConnection {
+	edges: [UserEdge!]!
+	pageInfo: PageInfo!
+}
+
+type UserEdge {
+	cursor: String!
+	node: User!
+}
+
+type PageInfo {
+	hasNextPage: Boolean!
+	hasPreviousPage: Boolean!
+	startCursor: String
+	endCursor: String
+}
+`
+
+// Maximum number of users per page.
+const graphqlMaxFirst = 100
+
+// API scoped to the organization of the request (see `api.scoped`).
+const scopedAPIKey contextKey = "api"
+
+var invalidCursorProblem = common.NewProblem(http.StatusBadRequest, common.ErrCodeInvalidCursor, "Invalid cursor")
+
+// GraphQL request body.
+type graphqlRequest struct {
+	Query         string                 `json:"query" binding:"required"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// Creates handler of GraphQL queries and mutations.
+// Schema is parsed once, so the handler must be created only on startup.
+//
+// @Summary Execute GraphQL query or mutation
+// @Accept  json
+// @Produce json
+// @Param   query body api.graphqlRequest true "GraphQL request"
+// @Success 200 {object} graphql.Response
+// @Failure 400 {object} common.Problem
+// @Router  /graphql [post]
+func (api *API) GraphQLHandler() gin.HandlerFunc {
+	schema := graphql.MustParseSchema(graphqlSchema, &graphqlResolver{api: api})
+
+	return func(c *gin.Context) {
+		var req graphqlRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			abortWithProblem(c, common.BindingProblem(err, &req))
+			return
+		}
+
+		scoped := api.scoped(c)
+		ctx := context.WithValue(c.Request.Context(), userLoaderKey, newUserLoader(scoped))
+		ctx = context.WithValue(ctx, actorKey, api.actor(c))
+		ctx = context.WithValue(ctx, scopedAPIKey, scoped)
+		c.JSON(http.StatusOK, schema.Exec(ctx, req.Query, req.OperationName, req.Variables))
+	}
+}
+
+// GraphQL error with problem details in extensions.
+type graphqlError struct {
+	common.Problem
+}
+
+func (e graphqlError) Extensions() map[string]interface{} {
+	ext := map[string]interface{}{"code": e.Code}
+	if len(e.Errors) > 0 {
+		ext["errors"] = e.Errors
+	}
+	return ext
+}
+
+// Wraps problem into GraphQL error, unexpected errors are logged and reported as internal problem,
+// so messages of database errors don't reach clients.
+func graphqlErr(err error) error {
+	switch e := err.(type) {
+	case graphqlError:
+		return e
+	case common.Problem:
+		return graphqlError{e}
+	}
+	log.Printf("[graphql] unexpected error: %s", err)
+	return graphqlError{internalProblem}
+}
+
+// Resolves GraphQL ID (user public ID) to internal user ID.
+func (r *graphqlResolver) resolveUserID(ctx context.Context, id graphql.ID) (int, error) {
+	v, err := r.scoped(ctx).ResolveUserID(string(id))
+	if err != nil {
+		return 0, graphqlErr(err)
+	}
+	return v, nil
+}
+
+// Opaque cursor of the user in the list.
+func encodeUserCursor(publicID string) string {
+	return base64.URLEncoding.EncodeToString([]byte("user:" + publicID))
+}
+
+// Decodes user public ID from the cursor.
+func decodeUserCursor(cursor string) (string, error) {
+	data, err := base64.URLEncoding.DecodeString(cursor)
+	if err != nil || !strings.HasPrefix(string(data), "user:") {
+		return "", graphqlErr(invalidCursorProblem)
+	}
+	return strings.TrimPrefix(string(data), "user:"), nil
+}
+
+// Root resolver, queries and mutations go through the same business logic as RESTful handlers.
+type graphqlResolver struct {
+	api *API
+}
+
+// Returns the API scoped to the organization of the request.
+func (r *graphqlResolver) scoped(ctx context.Context) *API {
+	if api, ok := ctx.Value(scopedAPIKey).(*API); ok {
+		return api
+	}
+	return r.api
+}
+
+func (r *graphqlResolver) User(ctx context.Context, args struct{ ID graphql.ID }) (*userResolver, error) {
+	user, err := userLoaderFromContext(ctx).Load(string(args.ID))
+	if err != nil {
+		if common.IsProblem(err, common.ErrCodeUserNotFound) {
+			return nil, nil
+		}
+		return nil, graphqlErr(err)
+	}
+	return &userResolver{user}, nil
+}
+
+func (r *graphqlResolver) Users(ctx context.Context, args struct {
+	Filter *struct {
+		Country       *string
+		Status        *string
+		CreatedAfter  *graphql.Time
+		CreatedBefore *graphql.Time
+	}
+	First int32
+	After *string
+}) (*userConnectionResolver, error) {
+	var filter UserFilter
+	if args.Filter != nil {
+		if args.Filter.Country != nil {
+			filter.Country = *args.Filter.Country
+		}
+		if args.Filter.Status != nil {
+			filter.Status = strings.ToLower(*args.Filter.Status)
+		}
+		if args.Filter.CreatedAfter != nil {
+			filter.CreatedAfter = args.Filter.CreatedAfter.Time
+		}
+		if args.Filter.CreatedBefore != nil {
+			filter.CreatedBefore = args.Filter.CreatedBefore.Time
+		}
+	}
+	if args.After != nil {
+		publicID, err := decodeUserCursor(*args.After)
+		if err != nil {
+			return nil, err
+		}
+		if filter.AfterID, err = r.scoped(ctx).ResolveUserID(publicID); err != nil {
+			return nil, graphqlErr(invalidCursorProblem)
+		}
+	}
+
+	// as in Relay, zero returns no edges (but tells if there are any), and the page size is limited
+	if args.First < 0 {
+		return nil, graphqlErr(common.FieldProblem("first", "min", "0"))
+	}
+	first := int(args.First)
+	if first > graphqlMaxFirst {
+		first = graphqlMaxFirst
+	}
+
+	// one extra user tells if there is the next page
+	filter.Limit = first + 1
+	users, err := r.scoped(ctx).ListUsers(filter)
+	if err != nil {
+		return nil, graphqlErr(err)
+	}
+
+	conn := &userConnectionResolver{hasPreviousPage: filter.AfterID > 0}
+	if len(users) > first {
+		users, conn.hasNextPage = users[:first], true
+	}
+
+	loader := userLoaderFromContext(ctx)
+	for i := range users {
+		loader.Prime(&users[i])
+		conn.edges = append(conn.edges, &userEdgeResolver{&users[i]})
+	}
+	return conn, nil
+}
+
+// GraphQL user input.
+type graphqlUserInput struct {
+	Email     string
+	Password  string
+	FirstName string
+	LastName  string
+	Nickname  string
+	Country   string
+	Phone     *string
+}
+
+func (in graphqlUserInput) toUserInput() UserInput {
+	out := UserInput{
+		Email:     in.Email,
+		Password:  in.Password,
+		FirstName: in.FirstName,
+		LastName:  in.LastName,
+		Nickname:  in.Nickname,
+		Country:   in.Country,
+	}
+	if in.Phone != nil {
+		out.Phone = *in.Phone
+	}
+	return out
+}
+
+func (r *graphqlResolver) CreateUser(ctx context.Context, args struct{ Input graphqlUserInput }) (*userResolver, error) {
+	user, err := r.scoped(ctx).CreateUser(actorFromContext(ctx), args.Input.toUserInput())
+	if err != nil {
+		return nil, graphqlErr(err)
+	}
+	return &userResolver{user}, nil
+}
+
+func (r *graphqlResolver) UpdateUser(ctx context.Context, args struct {
+	ID    graphql.ID
+	Input graphqlUserInput
+}) (*userResolver, error) {
+	id, err := r.resolveUserID(ctx, args.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := r.scoped(ctx).UpdateUser(actorFromContext(ctx), id, args.Input.toUserInput())
+	if err != nil {
+		return nil, graphqlErr(err)
+	}
+	return &userResolver{user}, nil
+}
+
+func (r *graphqlResolver) DeleteUser(ctx context.Context, args struct{ ID graphql.ID }) (bool, error) {
+	// deletion is idempotent (see `api.DeleteUser`)
+	id, err := r.scoped(ctx).ResolveUserID(string(args.ID))
+	if err != nil {
+		if common.IsProblem(err, common.ErrCodeUserNotFound) {
+			return true, nil
+		}
+		return false, graphqlErr(err)
+	}
+
+	if err = r.scoped(ctx).DeleteUser(actorFromContext(ctx), id); err != nil {
+		return false, graphqlErr(err)
+	}
+	return true, nil
+}
+
+type userResolver struct {
+	user *model.User
+}
+
+func (r *userResolver) ID() graphql.ID {
+	return graphql.ID(r.user.PublicID)
+}
+
+func (r *userResolver) Email() string {
+	return r.user.Email
+}
+
+func (r *userResolver) FirstName() string {
+	return r.user.FirstName
+}
+
+func (r *userResolver) LastName() string {
+	return r.user.LastName
+}
+
+func (r *userResolver) Nickname() string {
+	return r.user.Nickname
+}
+
+func (r *userResolver) Country() string {
+	return r.user.Country
+}
+
+func (r *userResolver) Phone() *string {
+	if r.user.Phone == "" {
+		return nil
+	}
+	return &r.user.Phone
+}
+
+func (r *userResolver) PhoneVerified() bool {
+	return r.user.PhoneVerifiedAt != nil
+}
+
+func (r *userResolver) Status() string {
+	return strings.ToUpper(r.user.Status)
+}
+
+func (r *userResolver) StatusReason() *string {
+	if r.user.StatusReason == "" {
+		return nil
+	}
+	return &r.user.StatusReason
+}
+
+func (r *userResolver) StatusExpiresAt() *graphql.Time {
+	if r.user.StatusExpiresAt == nil {
+		return nil
+	}
+	return &graphql.Time{Time: *r.user.StatusExpiresAt}
+}
+
+func (r *userResolver) AvatarURL(args struct{ Size *int32 }) *string {
+	if r.user.AvatarURL == "" {
+		return nil
+	}
+	if args.Size != nil {
+		for _, t := range r.user.AvatarThumbnails {
+			if int32(t.Size) >= *args.Size {
+				return &t.URL
+			}
+		}
+	}
+	return &r.user.AvatarURL
+}
+
+func (r *userResolver) CreatedAt() graphql.Time {
+	return graphql.Time{Time: r.user.CreatedAt}
+}
+
+func (r *userResolver) UpdatedAt() graphql.Time {
+	return graphql.Time{Time: r.user.UpdatedAt}
+}
+
+func (r *userResolver) LastLoginAt() *graphql.Time {
+	if r.user.LastLoginAt == nil {
+		return nil
+	}
+	return &graphql.Time{Time: *r.user.LastLoginAt}
+}
+
+type userConnectionResolver struct {
+	edges           []*userEdgeResolver
+	hasNextPage     bool
+	hasPreviousPage bool
+}
+
+func (r *userConnectionResolver) Edges() []*userEdgeResolver {
+	return r.edges
+}
+
+func (r *userConnectionResolver) PageInfo() *pageInfoResolver {
+	p := &pageInfoResolver{hasNextPage: r.hasNextPage, hasPreviousPage: r.hasPreviousPage}
+	if len(r.edges) > 0 {
+		start, end := r.edges[0].Cursor(), r.edges[len(r.edges)-1].Cursor()
+		p.startCursor, p.endCursor = &start, &end
+	}
+	return p
+}
+
+type userEdgeResolver struct {
+	user *model.User
+}
+
+func (r *userEdgeResolver) Cursor() string {
+	return encodeUserCursor(r.user.PublicID)
+}
+
+func (r *userEdgeResolver) Node() *userResolver {
+	return &userResolver{r.user}
+}
+
+type pageInfoResolver struct {
+	hasNextPage     bool
+	hasPreviousPage bool
+	startCursor     *string
+	endCursor       *string
+}
+
+func (r *pageInfoResolver) HasNextPage() bool {
+	return r.hasNextPage
+}
+
+func (r *pageInfoResolver) HasPreviousPage() bool {
+	return r.hasPreviousPage
+}
+
+func (r *pageInfoResolver) StartCursor() *string {
+	return r.startCursor
+}
+
+func (r *pageInfoResolver) EndCursor() *string {
+	return r.endCursor
+}
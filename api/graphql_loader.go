@@ -0,0 +1,106 @@
+package api
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/lokhman/example-users-microservice/model"
+)
+
+// Key of request context value.
+type contextKey string
+
+const userLoaderKey contextKey = "userLoader"
+
+// How long the loader waits for other keys before it fetches a batch.
+const userLoaderWait = time.Millisecond
+
+// Dataloader-style batching of user lookups within one GraphQL request.
+// Resolvers run concurrently, so lookups made in the same moment are fetched with a single query
+// instead of N+1 `First` calls, results are cached for the rest of the request.
+type userLoader struct {
+	api *API
+
+	mu    sync.Mutex
+	batch *userBatch
+	cache map[string]*userBatch
+}
+
+// Batch of user lookups fetched together.
+type userBatch struct {
+	ids   []string
+	users map[string]*model.User
+	err   error
+	done  chan struct{}
+}
+
+// Creates new user loader, it must not outlive the request.
+func newUserLoader(api *API) *userLoader {
+	return &userLoader{api: api, cache: make(map[string]*userBatch)}
+}
+
+// Returns user loader of the request.
+func userLoaderFromContext(ctx context.Context) *userLoader {
+	return ctx.Value(userLoaderKey).(*userLoader)
+}
+
+// Loads user by public ID, waits for the batch to be fetched.
+func (l *userLoader) Load(id string) (*model.User, error) {
+	l.mu.Lock()
+	b, ok := l.cache[id]
+	if !ok {
+		if l.batch == nil {
+			l.batch = &userBatch{done: make(chan struct{})}
+			go l.dispatch(l.batch)
+		}
+		b = l.batch
+		b.ids = append(b.ids, id)
+		l.cache[id] = b
+	}
+	l.mu.Unlock()
+
+	<-b.done
+	if b.err != nil {
+		return nil, b.err
+	}
+	if user, ok := b.users[id]; ok {
+		return user, nil
+	}
+	return nil, userNotFoundProblem
+}
+
+// Fetches the batch after a short wait for more keys.
+func (l *userLoader) dispatch(b *userBatch) {
+	time.Sleep(userLoaderWait)
+
+	// new lookups start the next batch from now on
+	l.mu.Lock()
+	l.batch = nil
+	l.mu.Unlock()
+
+	defer close(b.done)
+
+	users, err := l.api.FindUsers(b.ids)
+	if err != nil {
+		b.err = err
+		return
+	}
+
+	b.users = make(map[string]*model.User, len(users))
+	for i := range users {
+		b.users[users[i].PublicID] = &users[i]
+	}
+}
+
+// Primes the cache with already fetched user, e.g. from the list.
+func (l *userLoader) Prime(user *model.User) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.cache[user.PublicID]; !ok {
+		b := &userBatch{users: map[string]*model.User{user.PublicID: user}, done: make(chan struct{})}
+		close(b.done)
+		l.cache[user.PublicID] = b
+	}
+}
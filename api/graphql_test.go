@@ -0,0 +1,26 @@
+// +build !integration
+
+package api
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/lokhman/example-users-microservice/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGraphqlErr(t *testing.T) {
+	err := graphqlErr(userNotFoundProblem)
+	if assert.IsType(t, graphqlError{}, err) {
+		assert.Equal(t, common.ErrCodeUserNotFound, err.(graphqlError).Extensions()["code"])
+	}
+	assert.Equal(t, err, graphqlErr(err))
+
+	// test if unexpected errors are not exposed
+	err = graphqlErr(errors.New(`pq: duplicate key value violates unique constraint "uix_users_active_normalized_email"`))
+	if assert.IsType(t, graphqlError{}, err) {
+		assert.Equal(t, common.ErrCodeInternal, err.(graphqlError).Code)
+		assert.NotContains(t, err.Error(), "pq:")
+	}
+}
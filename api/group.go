@@ -0,0 +1,212 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lokhman/example-users-microservice/common"
+)
+
+// @Summary List groups
+// @Accept  json
+// @Produce json,application/x-msgpack,xml
+// @Success 200 {array} api.Group
+// @Router  /v1/groups [get]
+func (api *API) GroupIndexHandler(c *gin.Context) {
+	api = api.scoped(c)
+
+	groups, err := api.ListGroups()
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	respond(c, http.StatusOK, groups)
+}
+
+// @Summary Create new group (administrators only)
+// @Accept  json,x-www-form-urlencoded,application/x-msgpack
+// @Produce json,application/x-msgpack,xml
+// @Param   group body api.GroupInput true "New group details"
+// @Success 201 {object} api.Group
+// @Failure 400 {object} common.Problem
+// @Failure 403 {object} common.Problem
+// @Failure 422 {object} common.Problem
+// @Router  /v1/groups [post]
+func (api *API) GroupCreateHandler(c *gin.Context) {
+	api = api.scoped(c)
+
+	if !api.requireAdmin(c) {
+		return
+	}
+
+	var in GroupInput
+	if !bind(c, &in) {
+		return
+	}
+
+	group, err := api.CreateGroup(in)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	respond(c, http.StatusCreated, group)
+}
+
+// @Summary View group by ID
+// @Accept  json
+// @Produce json,application/x-msgpack,xml
+// @Param   id path string true "Group public ID" format(uuid)
+// @Success 200 {object} api.Group
+// @Failure 404 {object} common.Problem
+// @Router  /v1/groups/{id} [get]
+func (api *API) GroupViewHandler(c *gin.Context) {
+	api = api.scoped(c)
+
+	group, err := api.FindGroup(c.Param("id"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	respond(c, http.StatusOK, group)
+}
+
+// @Summary Update group by ID (administrators only)
+// @Accept  json,x-www-form-urlencoded,application/x-msgpack
+// @Produce json,application/x-msgpack,xml
+// @Param   id path string true "Group public ID" format(uuid)
+// @Param   group body api.GroupInput true "Group details"
+// @Success 200 {object} api.Group
+// @Failure 400 {object} common.Problem
+// @Failure 403 {object} common.Problem
+// @Failure 404 {object} common.Problem
+// @Failure 422 {object} common.Problem
+// @Router  /v1/groups/{id} [put]
+func (api *API) GroupUpdateHandler(c *gin.Context) {
+	api = api.scoped(c)
+
+	if !api.requireAdmin(c) {
+		return
+	}
+
+	var in GroupInput
+	if !bind(c, &in) {
+		return
+	}
+
+	group, err := api.UpdateGroup(c.Param("id"), in)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	respond(c, http.StatusOK, group)
+}
+
+// @Summary Delete group by ID (administrators only)
+// @Description Nested groups are moved to the parent of the deleted group.
+// @Accept  json
+// @Produce json,application/x-msgpack,xml
+// @Param   id path string true "Group public ID" format(uuid)
+// @Success 204 ""
+// @Failure 403 {object} common.Problem
+// @Router  /v1/groups/{id} [delete]
+func (api *API) GroupDeleteHandler(c *gin.Context) {
+	api = api.scoped(c)
+
+	if !api.requireAdmin(c) {
+		return
+	}
+
+	// DELETE request is idempotent, so we show that request was successful even if group doesn't exist
+	if err := api.DeleteGroup(c.Param("id")); err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	respond(c, http.StatusNoContent, nil)
+}
+
+// @Summary List members of group by ID
+// @Accept  json
+// @Produce json,application/x-msgpack,xml
+// @Param   id path string true "Group public ID" format(uuid)
+// @Param   transitive query bool false "Include members of nested groups"
+// @Success 200 {array} api.GroupMember
+// @Failure 404 {object} common.Problem
+// @Router  /v1/groups/{id}/members [get]
+func (api *API) GroupMemberIndexHandler(c *gin.Context) {
+	api = api.scoped(c)
+
+	transitive, _ := strconv.ParseBool(c.Query("transitive"))
+	members, err := api.ListGroupMembers(c.Param("id"), transitive)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	respond(c, http.StatusOK, members)
+}
+
+// @Summary Add user to group (administrators only)
+// @Accept  json
+// @Produce json,application/x-msgpack,xml
+// @Param   id path string true "Group public ID" format(uuid)
+// @Param   user_id path string true "User public ID" format(uuid)
+// @Success 204 ""
+// @Failure 403 {object} common.Problem
+// @Failure 404 {object} common.Problem
+// @Failure 422 {object} common.Problem
+// @Router  /v1/groups/{id}/members/{user_id} [put]
+func (api *API) GroupMemberSaveHandler(c *gin.Context) {
+	api = api.scoped(c)
+
+	if !api.requireAdmin(c) {
+		return
+	}
+
+	userID, err := api.ResolveUserID(c.Param("user_id"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	if err = api.AddGroupMember(c.Param("id"), userID); err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	respond(c, http.StatusNoContent, nil)
+}
+
+// @Summary Remove user from group (administrators only)
+// @Accept  json
+// @Produce json,application/x-msgpack,xml
+// @Param   id path string true "Group public ID" format(uuid)
+// @Param   user_id path string true "User public ID" format(uuid)
+// @Success 204 ""
+// @Failure 403 {object} common.Problem
+// @Failure 404 {object} common.Problem
+// @Router  /v1/groups/{id}/members/{user_id} [delete]
+func (api *API) GroupMemberDeleteHandler(c *gin.Context) {
+	api = api.scoped(c)
+
+	if !api.requireAdmin(c) {
+		return
+	}
+
+	// DELETE request is idempotent, so we show that request was successful even if user is not a member
+	userID, err := api.ResolveUserID(c.Param("user_id"))
+	if err == nil {
+		err = api.RemoveGroupMember(c.Param("id"), userID)
+	}
+	if err != nil && !common.IsProblem(err, common.ErrCodeUserNotFound) {
+		abortWithError(c, err)
+		return
+	}
+
+	respond(c, http.StatusNoContent, nil)
+}
@@ -0,0 +1,440 @@
+package api
+
+import (
+	"encoding/xml"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/lokhman/example-users-microservice/common"
+	"github.com/lokhman/example-users-microservice/model"
+)
+
+// NSQ topics of group changes.
+const (
+	TopicGroupCreate = "group.create"
+	TopicGroupUpdate = "group.update"
+	TopicGroupDelete = "group.delete"
+
+	TopicGroupMemberAdded   = "group.member_added"
+	TopicGroupMemberRemoved = "group.member_removed"
+)
+
+// key of the lock that serializes changes of group hierarchy, so concurrent changes can't make a cycle
+const groupHierarchyLock = 0x67726f7570
+
+var (
+	groupNotFoundProblem = common.NewProblem(http.StatusNotFound, common.ErrCodeGroupNotFound, "Group cannot be found")
+	groupParentProblem   = common.NewProblem(http.StatusUnprocessableEntity, common.ErrCodeInvalidGroupParent,
+		"Parent group cannot be found or is nested into the group")
+	notOrganizationMemberProblem = common.NewProblem(http.StatusUnprocessableEntity, common.ErrCodeNotOrganizationMember,
+		"User is not a member of the organization of the group")
+)
+
+// Group input structure.
+type GroupInput struct {
+	Name        string `json:"name" form:"name" binding:"required,max=128" example:"Engineering"`
+	Description string `json:"description" form:"description" binding:"max=1024" example:"Everyone who builds the product"`
+	ParentID    string `json:"parent_id" form:"parent_id" example:"01890a5d-ac96-774b-bcce-b302099a8057"`
+}
+
+// Group of users.
+type Group struct {
+	XMLName     xml.Name  `gorm:"-" json:"-" xml:"group"`
+	ID          string    `json:"id" xml:"id" example:"01890a5d-ac96-774b-bcce-b302099a8057"`
+	ParentID    *string   `json:"parent_id" xml:"parent_id,omitempty" example:"01890a5d-ac96-774b-bcce-b302099a8057"`
+	Name        string    `json:"name" xml:"name" example:"Engineering"`
+	Description string    `json:"description" xml:"description" example:"Everyone who builds the product"`
+	CreatedAt   time.Time `json:"created_at" xml:"created_at" example:"2019-01-01T00:00:00Z"`
+	UpdatedAt   time.Time `json:"updated_at" xml:"updated_at" example:"2019-01-01T00:00:00Z"`
+}
+
+// Group the user is member of, directly or through nested groups.
+type UserGroup struct {
+	Group
+	Direct bool `json:"direct" xml:"direct"`
+}
+
+// User who is member of the group.
+type GroupMember struct {
+	XMLName  xml.Name  `gorm:"-" json:"-" xml:"member"`
+	UserID   string    `json:"user_id" xml:"user_id" example:"01890a5d-ac96-774b-bcce-b302099a8057"`
+	Email    string    `json:"email" xml:"email" example:"alex.lokhman@gmail.com"`
+	Nickname string    `json:"nickname" xml:"nickname" example:"VisioN"`
+	GroupID  string    `json:"group_id" xml:"group_id" example:"01890a5d-ac96-774b-bcce-b302099a8057"`
+	AddedAt  time.Time `json:"added_at" xml:"added_at" example:"2019-01-01T00:00:00Z"`
+}
+
+// Message of "group.*" topics, tagged with the organization of the group (if any).
+type GroupMessage struct {
+	Group
+	OrgID string `json:"org_id,omitempty"`
+}
+
+// Message of "group.member_*" topics.
+type GroupMemberMessage struct {
+	GroupID string `json:"group_id"`
+	UserID  string `json:"user_id"`
+	OrgID   string `json:"org_id,omitempty"`
+}
+
+// columns of group representation (see `api.groups`)
+const groupColumns = "groups.public_id AS id, parents.public_id AS parent_id, groups.name, groups.description, groups.created_at, groups.updated_at"
+
+// Limits query of groups to the organization the API is scoped to.
+func (api *API) scopeGroups(db *gorm.DB) *gorm.DB {
+	if api.org == nil {
+		return db
+	}
+	return db.Where("groups.organization_id = ?", api.org.ID)
+}
+
+// Query of group representations.
+func (api *API) groups() *gorm.DB {
+	return api.scopeGroups(api.DB.Table("groups").
+		Select(groupColumns).
+		Joins("LEFT JOIN groups parents ON parents.id = groups.parent_id"))
+}
+
+// Finds representation of the group by internal ID.
+func (api *API) group(id int) (*Group, error) {
+	var group Group
+	if err := api.groups().Where("groups.id = ?", id).Scan(&group).Error; err != nil {
+		return nil, err
+	}
+	return &group, nil
+}
+
+// Finds group by public ID.
+func (api *API) findGroup(db *gorm.DB, publicID string) (*model.Group, error) {
+	if !common.IsUUID(publicID) {
+		return nil, groupNotFoundProblem
+	}
+
+	var group model.Group
+	if err := api.scopeGroups(db).Where("public_id = ?", publicID).First(&group).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, groupNotFoundProblem
+		}
+		return nil, err
+	}
+	return &group, nil
+}
+
+// Lists groups.
+func (api *API) ListGroups() ([]Group, error) {
+	groups := make([]Group, 0)
+	if err := api.groups().Order("groups.id").Scan(&groups).Error; err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+// Finds group by public ID.
+func (api *API) FindGroup(publicID string) (*Group, error) {
+	group, err := api.findGroup(api.DB, publicID)
+	if err != nil {
+		return nil, err
+	}
+	return api.group(group.ID)
+}
+
+// Sets parent of the group by public ID, the parent must be of the same organization and not nested into the group.
+// Changes of hierarchy are serialized within the transaction.
+func (api *API) setGroupParent(tx *gorm.DB, group *model.Group, parentID string) error {
+	if parentID == "" {
+		group.ParentID = nil
+		return nil
+	}
+	if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", groupHierarchyLock).Error; err != nil {
+		return err
+	}
+
+	parent, err := api.findGroup(tx, parentID)
+	if err != nil {
+		if common.IsProblem(err, common.ErrCodeGroupNotFound) {
+			return groupParentProblem
+		}
+		return err
+	}
+	if (parent.OrganizationID == nil) != (group.OrganizationID == nil) ||
+		parent.OrganizationID != nil && *parent.OrganizationID != *group.OrganizationID {
+		return groupParentProblem
+	}
+
+	// new group can't have nested groups yet
+	if group.ID > 0 {
+		var count int
+		err = tx.Raw(`
+			WITH RECURSIVE nested(id) AS (
+				SELECT ?::int
+				UNION
+				SELECT groups.id FROM groups JOIN nested ON groups.parent_id = nested.id
+			)
+			SELECT count(*) FROM nested WHERE id = ?
+		`, group.ID, parent.ID).Row().Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return groupParentProblem
+		}
+	}
+	group.ParentID = &parent.ID
+	return nil
+}
+
+// Creates new group in the organization the API is scoped to (if any) and publishes it to the queue.
+func (api *API) CreateGroup(in GroupInput) (*Group, error) {
+	if err := validate(&in); err != nil {
+		return nil, err
+	}
+
+	group := model.Group{PublicID: common.NewUUIDv7(), Name: strings.TrimSpace(in.Name), Description: in.Description}
+	if api.org != nil {
+		group.OrganizationID = &api.org.ID
+	}
+	err := api.transaction(func(tx *gorm.DB) error {
+		if err := api.setGroupParent(tx, &group, in.ParentID); err != nil {
+			return err
+		}
+		return tx.Create(&group).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := api.group(group.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// try to publish message to the queue under "group.create" topic (see `api.CreateUser` for more details)
+	if err = common.NSQPublish(api.NSQ, TopicGroupCreate, GroupMessage{Group: *out, OrgID: api.orgID()}); err != nil {
+		return nil, err
+	}
+
+	// some meaningful logs to default logger
+	log.Printf("[users] group with ID %d was created", group.ID)
+
+	return out, nil
+}
+
+// Updates group by public ID and publishes it to the queue.
+func (api *API) UpdateGroup(publicID string, in GroupInput) (*Group, error) {
+	if err := validate(&in); err != nil {
+		return nil, err
+	}
+
+	var group *model.Group
+	err := api.transaction(func(tx *gorm.DB) error {
+		var err error
+		if group, err = api.findGroup(tx, publicID); err != nil {
+			return err
+		}
+		if err = api.setGroupParent(tx, group, in.ParentID); err != nil {
+			return err
+		}
+		group.Name = strings.TrimSpace(in.Name)
+		group.Description = in.Description
+		return tx.Save(group).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := api.group(group.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// try to publish message to the queue under "group.update" topic (see `api.CreateUser` for more details)
+	if err = common.NSQPublish(api.NSQ, TopicGroupUpdate, GroupMessage{Group: *out, OrgID: api.orgID()}); err != nil {
+		return nil, err
+	}
+
+	// some meaningful logs to default logger
+	log.Printf("[users] group with ID %d was updated", group.ID)
+
+	return out, nil
+}
+
+// Deletes group by public ID with its members and publishes it to the queue.
+// Nested groups are moved to the parent of the group, so their members stay in the parent groups.
+// Deletion of the group that doesn't exist is not an error, as the operation is idempotent.
+func (api *API) DeleteGroup(publicID string) error {
+	out, err := api.FindGroup(publicID)
+	if err != nil {
+		if common.IsProblem(err, common.ErrCodeGroupNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	var group *model.Group
+	err = api.transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", groupHierarchyLock).Error; err != nil {
+			return err
+		}
+		var err error
+		if group, err = api.findGroup(tx, publicID); err != nil {
+			return err
+		}
+		err = tx.Model(&model.Group{}).Where("parent_id = ?", group.ID).UpdateColumn("parent_id", group.ParentID).Error
+		if err != nil {
+			return err
+		}
+		if err = tx.Where("group_id = ?", group.ID).Delete(&model.GroupMember{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(group).Error
+	})
+	if err != nil {
+		if common.IsProblem(err, common.ErrCodeGroupNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	// try to publish message to the queue under "group.delete" topic (see `api.CreateUser` for more details)
+	if err = common.NSQPublish(api.NSQ, TopicGroupDelete, GroupMessage{Group: *out, OrgID: api.orgID()}); err != nil {
+		return err
+	}
+
+	// some meaningful logs to default logger
+	log.Printf("[users] group with ID %d was deleted", group.ID)
+
+	return nil
+}
+
+// Lists members of the group by public ID, members of nested groups are included if `transitive` is set.
+// Every user is listed once with the earliest group they were added to.
+func (api *API) ListGroupMembers(publicID string, transitive bool) ([]GroupMember, error) {
+	group, err := api.findGroup(api.DB, publicID)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := "SELECT ?::int"
+	if transitive {
+		groups = `
+			WITH RECURSIVE nested(id) AS (
+				SELECT ?::int
+				UNION
+				SELECT groups.id FROM groups JOIN nested ON groups.parent_id = nested.id
+			)
+			SELECT id FROM nested`
+	}
+
+	members := make([]GroupMember, 0)
+	err = api.scopeUsers(api.DB.Table("group_members").
+		Select("DISTINCT ON (users.id) users.public_id AS user_id, users.email, users.nickname, groups.public_id AS group_id, group_members.created_at AS added_at").
+		Joins("JOIN users ON users.id = group_members.user_id AND users.deleted_at IS NULL").
+		Joins("JOIN groups ON groups.id = group_members.group_id").
+		Where("group_members.group_id IN ("+groups+")", group.ID)).
+		Order("users.id, group_members.created_at").Scan(&members).Error
+	if err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// Adds user (by internal ID) to the group (by public ID) and publishes it to the queue.
+// Group of organization takes only members of the organization, adding the user who is already a member does nothing.
+func (api *API) AddGroupMember(publicID string, userID int) error {
+	group, err := api.findGroup(api.DB, publicID)
+	if err != nil {
+		return err
+	}
+	user, err := api.FindUser(userID)
+	if err != nil {
+		return err
+	}
+	if group.OrganizationID != nil {
+		var count int
+		err = api.DB.Model(&model.Membership{}).Where("organization_id = ? AND user_id = ?", *group.OrganizationID, user.ID).Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return notOrganizationMemberProblem
+		}
+	}
+
+	result := api.DB.Exec("INSERT INTO group_members (group_id, user_id, created_at) VALUES (?, ?, ?) ON CONFLICT DO NOTHING",
+		group.ID, user.ID, gorm.NowFunc())
+	if result.Error != nil || result.RowsAffected == 0 {
+		return result.Error
+	}
+
+	// try to publish message to the queue under "group.member_added" topic (see `api.CreateUser` for more details)
+	message := GroupMemberMessage{GroupID: group.PublicID, UserID: user.PublicID, OrgID: api.orgID()}
+	if err = common.NSQPublish(api.NSQ, TopicGroupMemberAdded, message); err != nil {
+		return err
+	}
+
+	// some meaningful logs to default logger
+	log.Printf("[users] user with ID %d was added to group with ID %d", user.ID, group.ID)
+
+	return nil
+}
+
+// Removes user (by internal ID) from the group (by public ID) and publishes it to the queue.
+// Removal of the user who is not a member is not an error, as the operation is idempotent.
+func (api *API) RemoveGroupMember(publicID string, userID int) error {
+	group, err := api.findGroup(api.DB, publicID)
+	if err != nil {
+		return err
+	}
+
+	var user model.User
+	if err = api.scopeUsers(api.DB.Unscoped()).First(&user, userID).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil
+		}
+		return err
+	}
+
+	result := api.DB.Where("group_id = ? AND user_id = ?", group.ID, user.ID).Delete(&model.GroupMember{})
+	if result.Error != nil || result.RowsAffected == 0 {
+		return result.Error
+	}
+
+	// try to publish message to the queue under "group.member_removed" topic (see `api.CreateUser` for more details)
+	message := GroupMemberMessage{GroupID: group.PublicID, UserID: user.PublicID, OrgID: api.orgID()}
+	if err = common.NSQPublish(api.NSQ, TopicGroupMemberRemoved, message); err != nil {
+		return err
+	}
+
+	// some meaningful logs to default logger
+	log.Printf("[users] user with ID %d was removed from group with ID %d", user.ID, group.ID)
+
+	return nil
+}
+
+// Lists groups the user (by internal ID) is member of, directly or through nested groups.
+func (api *API) ListUserGroups(id int) ([]UserGroup, error) {
+	user, err := api.FindUser(id)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make([]UserGroup, 0)
+	err = api.groups().
+		Select(groupColumns+", EXISTS (SELECT 1 FROM group_members WHERE group_id = groups.id AND user_id = ?) AS direct", user.ID).
+		Where(`groups.id IN (
+			WITH RECURSIVE ancestors(id) AS (
+				SELECT group_id FROM group_members WHERE user_id = ?
+				UNION
+				SELECT groups.parent_id FROM groups JOIN ancestors ON groups.id = ancestors.id WHERE groups.parent_id IS NOT NULL
+			)
+			SELECT id FROM ancestors
+		)`, user.ID).
+		Order("groups.id").Scan(&groups).Error
+	if err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
@@ -0,0 +1,334 @@
+package api
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/lokhman/example-users-microservice/common"
+	"github.com/lokhman/example-users-microservice/model"
+	usersv1 "github.com/lokhman/example-users-microservice/proto/users/v1"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// gRPC user service, shares business logic with RESTful handlers.
+type userServiceServer struct {
+	usersv1.UnimplementedUserServiceServer
+
+	api *API
+}
+
+// Creates gRPC server with user, health and reflection services.
+func (api *API) NewGRPCServer() *grpc.Server {
+	s := grpc.NewServer(
+		grpc.UnaryInterceptor(recoveryUnaryInterceptor),
+		grpc.StreamInterceptor(recoveryStreamInterceptor),
+	)
+	usersv1.RegisterUserServiceServer(s, &userServiceServer{api: api})
+
+	hs := health.NewServer()
+	hs.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	hs.SetServingStatus(usersv1.UserService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(s, hs)
+
+	reflection.Register(s)
+	return s
+}
+
+// Recovers from panics in unary handlers, similarly to `api.RecoveryMiddleware`.
+func recoveryUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[recovery] panic recovered in %s: %v\n%s", info.FullMethod, r, debug.Stack())
+			err = status.Error(codes.Internal, internalProblem.Detail)
+		}
+	}()
+	return handler(ctx, req)
+}
+
+// Recovers from panics in stream handlers, similarly to `api.RecoveryMiddleware`.
+func recoveryStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[recovery] panic recovered in %s: %v\n%s", info.FullMethod, r, debug.Stack())
+			err = status.Error(codes.Internal, internalProblem.Detail)
+		}
+	}()
+	return handler(srv, ss)
+}
+
+// Converts error to gRPC status, field errors are attached as bad request details.
+func grpcError(err error) error {
+	p, ok := err.(common.Problem)
+	if !ok {
+		log.Printf("[grpc] unexpected error: %s", err)
+		return status.Error(codes.Internal, internalProblem.Detail)
+	}
+
+	code := codes.Unknown
+	switch {
+	case p.Code == common.ErrCodeEmailExists, p.Code == common.ErrCodeNicknameExists:
+		code = codes.AlreadyExists
+	case p.Code == common.ErrCodeStatusTransition, p.Code == common.ErrCodePhoneNotSet, p.Code == common.ErrCodePhoneVerified:
+		code = codes.FailedPrecondition
+	case p.Status == http.StatusForbidden:
+		code = codes.PermissionDenied
+	case p.Status == http.StatusNotFound:
+		code = codes.NotFound
+	case p.Status == http.StatusTooManyRequests:
+		code = codes.ResourceExhausted
+	case p.Status == http.StatusConflict:
+		code = codes.Aborted
+	case p.Status == http.StatusBadRequest, p.Status == http.StatusUnprocessableEntity:
+		code = codes.InvalidArgument
+	}
+
+	st := status.New(code, p.Detail)
+	if withDetails, err := st.WithDetails(&errdetails.ErrorInfo{Reason: p.Code, Domain: "users.v1"}); err == nil {
+		st = withDetails
+	}
+	if len(p.Errors) > 0 {
+		br := &errdetails.BadRequest{}
+		for _, fe := range p.Errors {
+			br.FieldViolations = append(br.FieldViolations, &errdetails.BadRequest_FieldViolation{
+				Field:       fe.Field,
+				Description: fe.Message,
+			})
+		}
+		if withDetails, err := st.WithDetails(br); err == nil {
+			st = withDetails
+		}
+	}
+	return st.Err()
+}
+
+// Converts user model to protobuf message.
+func userToProto(user *model.User) *usersv1.User {
+	out := &usersv1.User{
+		PublicId:     user.PublicID,
+		Email:        user.Email,
+		FirstName:    user.FirstName,
+		LastName:     user.LastName,
+		Nickname:     user.Nickname,
+		Country:      user.Country,
+		Phone:        user.Phone,
+		Status:       user.Status,
+		StatusReason: user.StatusReason,
+		AvatarUrl:    user.AvatarURL,
+		CreatedAt:    timestamppb.New(user.CreatedAt),
+		UpdatedAt:    timestamppb.New(user.UpdatedAt),
+	}
+	for _, t := range user.AvatarThumbnails {
+		out.AvatarThumbnails = append(out.AvatarThumbnails, &usersv1.AvatarThumbnail{Size: int32(t.Size), Url: t.URL})
+	}
+	if len(user.Attributes) > 0 {
+		// attributes are decoded from JSON, so they always convert
+		out.Attributes, _ = structpb.NewStruct(user.Attributes)
+	}
+	if user.StatusExpiresAt != nil {
+		out.StatusExpiresAt = timestamppb.New(*user.StatusExpiresAt)
+	}
+	if user.LastLoginAt != nil {
+		out.LastLoginAt = timestamppb.New(*user.LastLoginAt)
+	}
+	if user.PhoneVerifiedAt != nil {
+		out.PhoneVerifiedAt = timestamppb.New(*user.PhoneVerifiedAt)
+	}
+	return out
+}
+
+// Identifies caller of the RPC for the audit log, request ID is taken from "x-request-id" metadata if present.
+func grpcActor(ctx context.Context) Actor {
+	actor := Actor{Name: "grpc"}
+	if p, ok := peer.FromContext(ctx); ok {
+		if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+			actor.IP = host
+		}
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get("x-request-id"); len(ids) > 0 && requestIDRegexp.MatchString(ids[0]) {
+			actor.RequestID = ids[0]
+		}
+	}
+	if actor.RequestID == "" {
+		actor.RequestID = common.NewUUIDv7()
+	}
+	return actor
+}
+
+// Returns the API scoped to the organization of "x-organization-id" metadata, see `api.TenantMiddleware`.
+// Only administrators ("authorization" metadata as the header in `api.isAdmin`) may omit it.
+func (s *userServiceServer) scoped(ctx context.Context) (*API, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if ids := md.Get("x-organization-id"); len(ids) > 0 && ids[0] != "" {
+		org, err := s.api.FindOrganization(ids[0])
+		if err != nil {
+			return nil, err
+		}
+		return s.api.inOrganization(org), nil
+	}
+	if auth := md.Get("authorization"); len(auth) > 0 && s.api.isAdminAuthorization(auth[0]) {
+		return s.api, nil
+	}
+	return nil, organizationRequiredProblem
+}
+
+// Request referring user by public ID.
+type userRefRequest interface {
+	GetPublicId() string
+}
+
+// Resolves user ID of the request by the API scoped to the organization of the request.
+func (s *userServiceServer) userID(ctx context.Context, req userRefRequest) (*API, int, error) {
+	api, err := s.scoped(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	id, err := api.ResolveUserID(req.GetPublicId())
+	return api, id, err
+}
+
+// Converts optional protobuf timestamp to time, zero time if not set.
+func timeFromProto(ts *timestamppb.Timestamp) time.Time {
+	if ts == nil {
+		return time.Time{}
+	}
+	return ts.AsTime()
+}
+
+// Converts protobuf message to user input.
+func userInputFromProto(in *usersv1.UserInput) UserInput {
+	return UserInput{
+		Email:     in.GetEmail(),
+		Password:  in.GetPassword(),
+		FirstName: in.GetFirstName(),
+		LastName:  in.GetLastName(),
+		Nickname:  in.GetNickname(),
+		Country:   in.GetCountry(),
+		Phone:     in.GetPhone(),
+	}
+}
+
+func (s *userServiceServer) CreateUser(ctx context.Context, req *usersv1.CreateUserRequest) (*usersv1.User, error) {
+	api, err := s.scoped(ctx)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	user, err := api.CreateUser(grpcActor(ctx), userInputFromProto(req.GetUser()))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return userToProto(user), nil
+}
+
+func (s *userServiceServer) GetUser(ctx context.Context, req *usersv1.GetUserRequest) (*usersv1.User, error) {
+	api, id, err := s.userID(ctx, req)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	user, err := api.FindUser(id)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return userToProto(user), nil
+}
+
+func (s *userServiceServer) ListUsers(ctx context.Context, req *usersv1.ListUsersRequest) (*usersv1.ListUsersResponse, error) {
+	api, err := s.scoped(ctx)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	users, err := api.ListUsers(UserFilter{
+		Country:         req.GetCountry(),
+		Status:          req.GetStatus(),
+		CreatedAfter:    timeFromProto(req.GetCreatedAfter()),
+		CreatedBefore:   timeFromProto(req.GetCreatedBefore()),
+		UpdatedAfter:    timeFromProto(req.GetUpdatedAfter()),
+		UpdatedBefore:   timeFromProto(req.GetUpdatedBefore()),
+		LastLoginAfter:  timeFromProto(req.GetLastLoginAfter()),
+		LastLoginBefore: timeFromProto(req.GetLastLoginBefore()),
+		Sort:            req.GetSort(),
+	})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	resp := &usersv1.ListUsersResponse{Users: make([]*usersv1.User, len(users))}
+	for i := range users {
+		resp.Users[i] = userToProto(&users[i])
+	}
+	return resp, nil
+}
+
+func (s *userServiceServer) UpdateUser(ctx context.Context, req *usersv1.UpdateUserRequest) (*usersv1.User, error) {
+	api, id, err := s.userID(ctx, req)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	user, err := api.UpdateUser(grpcActor(ctx), id, userInputFromProto(req.GetUser()))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return userToProto(user), nil
+}
+
+func (s *userServiceServer) DeleteUser(ctx context.Context, req *usersv1.DeleteUserRequest) (*emptypb.Empty, error) {
+	// deletion is idempotent (see `api.DeleteUser`)
+	api, id, err := s.userID(ctx, req)
+	if err != nil {
+		if common.IsProblem(err, common.ErrCodeUserNotFound) {
+			return &emptypb.Empty{}, nil
+		}
+		return nil, grpcError(err)
+	}
+
+	if err = api.DeleteUser(grpcActor(ctx), id); err != nil {
+		return nil, grpcError(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// Event types by NSQ topics.
+var userEventTypes = map[string]usersv1.UserEvent_Type{
+	TopicUserCreate:  usersv1.UserEvent_TYPE_CREATED,
+	TopicUserUpdate:  usersv1.UserEvent_TYPE_UPDATED,
+	TopicUserDelete:  usersv1.UserEvent_TYPE_DELETED,
+	TopicUserRestore: usersv1.UserEvent_TYPE_RESTORED,
+	TopicUserPurge:   usersv1.UserEvent_TYPE_PURGED,
+	TopicUserErased:  usersv1.UserEvent_TYPE_ERASED,
+
+	TopicUserStatusChanged: usersv1.UserEvent_TYPE_STATUS_CHANGED,
+}
+
+func (s *userServiceServer) WatchUsers(req *usersv1.WatchUsersRequest, stream usersv1.UserService_WatchUsersServer) error {
+	api, err := s.scoped(stream.Context())
+	if err != nil {
+		return grpcError(err)
+	}
+
+	err = api.WatchUsers(stream.Context(), func(topic string, user model.User) error {
+		return stream.Send(&usersv1.UserEvent{Type: userEventTypes[topic], User: userToProto(&user)})
+	})
+	if err != nil {
+		return grpcError(err)
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package api
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/lokhman/example-users-microservice/common"
+	"github.com/lokhman/example-users-microservice/model"
+)
+
+const idempotencyKeyHeader = "Idempotency-Key"
+
+var (
+	invalidIdempotencyKeyProblem = common.NewProblem(http.StatusBadRequest, common.ErrCodeIdempotencyKey,
+		"Idempotency key must not exceed 255 characters")
+	idempotencyKeyReusedProblem = common.NewProblem(http.StatusConflict, common.ErrCodeIdempotencyReused,
+		"Idempotency key was already used with a different request")
+	idempotencyKeyConflictProblem = common.NewProblem(http.StatusConflict, common.ErrCodeIdempotencyConflict,
+		"Request with the same idempotency key is being processed")
+)
+
+// Response writer that keeps a copy of the response body.
+type bodyRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *bodyRecorder) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *bodyRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Identifies the caller who chose the idempotency key: credentials of the request or address of anonymous client.
+func idempotencyCaller(c *gin.Context) string {
+	if auth := c.GetHeader("Authorization"); auth != "" {
+		return "auth:" + auth
+	}
+	return "ip:" + c.ClientIP()
+}
+
+// Makes POST requests with `Idempotency-Key` header safe to retry.
+// The first response is stored with the request fingerprint and replayed to any retry with the same key,
+// whereas reusing the key with a different request results in 409 Conflict. Keys are scoped to the caller.
+func (api *API) IdempotencyMiddleware(c *gin.Context) {
+	key := c.GetHeader(idempotencyKeyHeader)
+	if c.Request.Method != http.MethodPost || key == "" {
+		c.Next()
+		return
+	}
+	if len(key) > 255 {
+		abortWithProblem(c, invalidIdempotencyKeyProblem)
+		return
+	}
+
+	// body is read here, so it must be restored for the handler
+	body, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		abortWithProblem(c, common.NewProblem(http.StatusBadRequest, common.ErrCodeInvalidRequest, err.Error()))
+		return
+	}
+	c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	// the same request in scope of another organization is a different request
+	path := c.Request.URL.Path
+	if org := c.GetHeader(OrganizationHeader); org != "" {
+		path = org + ":" + path
+	}
+	fingerprint := common.RequestFingerprint(c.Request.Method, path,
+		c.GetHeader("Content-Type"), c.GetHeader("Accept"), body)
+	key = common.ScopedKey(idempotencyCaller(c), key)
+
+	// expired keys are simply forgotten, so they can be used again
+	now := time.Now()
+	if err = api.DB.Where("key = ? AND expires_at < ?", key, now).Delete(model.IdempotencyKey{}).Error; err != nil {
+		panic(err)
+	}
+
+	// primary key guarantees that only one of concurrent requests with the same key gets through
+	record := model.IdempotencyKey{Key: key, Fingerprint: fingerprint, ExpiresAt: now.Add(api.IdempotencyTTL)}
+	if err = api.DB.Create(&record).Error; err != nil {
+		if !common.IsUniqueConstraintError(err, model.IdempotencyKeyPrimaryKeyConstraintName) {
+			panic(err)
+		}
+
+		if err = api.DB.Where("key = ?", key).First(&record).Error; err != nil {
+			panic(err)
+		}
+
+		switch {
+		case record.Fingerprint != fingerprint:
+			abortWithProblem(c, idempotencyKeyReusedProblem)
+		case record.IsPending():
+			abortWithProblem(c, idempotencyKeyConflictProblem)
+		default:
+			c.Header("Idempotent-Replayed", "true")
+			c.Data(record.StatusCode, record.ContentType, record.Body)
+			c.Abort()
+		}
+		return
+	}
+
+	// if handler panics or fails with server error the key is released, so the client may retry
+	stored := false
+	defer func() {
+		if !stored {
+			_ = api.DB.Delete(&record).Error
+		}
+	}()
+
+	w := &bodyRecorder{ResponseWriter: c.Writer}
+	c.Writer = w
+	c.Next()
+
+	if w.Status() >= http.StatusInternalServerError {
+		return
+	}
+
+	record.StatusCode = w.Status()
+	record.ContentType = w.Header().Get("Content-Type")
+	record.Body = w.body.Bytes()
+
+	// response is already sent, so failure is only logged, and the key stays pending until it expires
+	// rather than being released for a retry that would repeat the request
+	stored = true
+	if err = api.DB.Save(&record).Error; err != nil {
+		log.Printf("[users] response to idempotent request cannot be stored: %s", err)
+	}
+}
+
+// Deletes cached responses of idempotent requests that contain any of the values (e.g. personal data of erased user).
+// Bodies are matched as bytes, so any representation with the value as is (JSON, XML or MessagePack) is found.
+func deleteIdempotentResponses(db *gorm.DB, values ...string) error {
+	for _, value := range values {
+		if value == "" {
+			continue
+		}
+		if err := db.Where("position(? IN body) > 0", []byte(value)).Delete(&model.IdempotencyKey{}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lokhman/example-users-microservice/model"
+)
+
+// @Summary List invitations (administrators only)
+// @Accept  json
+// @Produce json,application/x-msgpack,xml
+// @Param   status query string false "Invitation status" Enums(pending, accepted, revoked)
+// @Success 200 {array} api.Invitation
+// @Failure 403 {object} common.Problem
+// @Router  /v1/invitations [get]
+func (api *API) InvitationIndexHandler(c *gin.Context) {
+	api = api.scoped(c)
+
+	if !api.requireAdmin(c) {
+		return
+	}
+
+	invitations, err := api.ListInvitations(c.Query("status"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	respond(c, http.StatusOK, invitations)
+}
+
+// @Summary Invite new user by email (administrators only)
+// @Description The token is sent to the email, the invitee accepts the invitation with their own password.
+// @Accept  json,x-www-form-urlencoded,application/x-msgpack
+// @Produce json,application/x-msgpack,xml
+// @Param   invitation body api.InvitationInput true "Email and optional organization with the role"
+// @Success 201 {object} api.Invitation
+// @Failure 400 {object} common.Problem
+// @Failure 403 {object} common.Problem
+// @Failure 404 {object} common.Problem
+// @Failure 409 {object} common.Problem
+// @Failure 422 {object} common.Problem
+// @Router  /v1/invitations [post]
+func (api *API) InvitationCreateHandler(c *gin.Context) {
+	api = api.scoped(c)
+
+	if !api.requireAdmin(c) {
+		return
+	}
+
+	var in InvitationInput
+	if !bind(c, &in) {
+		return
+	}
+
+	invitation, err := api.CreateInvitation(in)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	respond(c, http.StatusCreated, invitation)
+}
+
+// @Summary Send a new token of invitation by ID (administrators only)
+// @Description Tokens that were sent before become invalid.
+// @Accept  json
+// @Produce json,application/x-msgpack,xml
+// @Param   id path string true "Invitation public ID" format(uuid)
+// @Success 200 {object} api.Invitation
+// @Failure 403 {object} common.Problem
+// @Failure 404 {object} common.Problem
+// @Failure 409 {object} common.Problem
+// @Router  /v1/invitations/{id}/resend [post]
+func (api *API) InvitationResendHandler(c *gin.Context) {
+	api = api.scoped(c)
+
+	if !api.requireAdmin(c) {
+		return
+	}
+
+	invitation, err := api.ResendInvitation(c.Param("id"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	respond(c, http.StatusOK, invitation)
+}
+
+// @Summary Revoke invitation by ID (administrators only)
+// @Accept  json
+// @Produce json,application/x-msgpack,xml
+// @Param   id path string true "Invitation public ID" format(uuid)
+// @Success 204 ""
+// @Failure 403 {object} common.Problem
+// @Failure 404 {object} common.Problem
+// @Failure 409 {object} common.Problem
+// @Router  /v1/invitations/{id}/revoke [post]
+func (api *API) InvitationRevokeHandler(c *gin.Context) {
+	api = api.scoped(c)
+
+	if !api.requireAdmin(c) {
+		return
+	}
+
+	if err := api.RevokeInvitation(c.Param("id")); err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	respond(c, http.StatusNoContent, nil)
+}
+
+// Accepts invitation by the token from path parameter, reports problem if it fails.
+// Token takes the place of ID in the path, as router doesn't allow different parameters at the same position.
+func (api *API) acceptInvitation(c *gin.Context) (*model.User, bool) {
+	var in InvitationAcceptInput
+	if !bind(c, &in) {
+		return nil, false
+	}
+
+	user, err := api.AcceptInvitation(api.actor(c), c.Param("id"), in)
+	if err != nil {
+		abortWithError(c, err)
+		return nil, false
+	}
+	return user, true
+}
+
+// @Summary Accept invitation by token and create user
+// @Accept  json,x-www-form-urlencoded,application/x-msgpack
+// @Produce json,application/x-msgpack,xml
+// @Param   token path string true "Invitation token from the email"
+// @Param   user body api.InvitationAcceptInput true "New user details"
+// @Success 201 {object} model.User
+// @Failure 400 {object} common.Problem
+// @Failure 404 {object} common.Problem
+// @Failure 422 {object} common.Problem
+// @Router  /v1/invitations/{token}/accept [post]
+func (api *API) InvitationAcceptHandler(c *gin.Context) {
+	if user, ok := api.acceptInvitation(c); ok {
+		respond(c, http.StatusCreated, user)
+	}
+}
+
+// @Summary Accept invitation by token and create user
+// @Accept  json,x-www-form-urlencoded,application/x-msgpack
+// @Produce json,application/x-msgpack,xml
+// @Param   token path string true "Invitation token from the email"
+// @Param   user body api.InvitationAcceptInput true "New user details"
+// @Success 201 {object} api.UserV2
+// @Failure 400 {object} common.Problem
+// @Failure 404 {object} common.Problem
+// @Failure 422 {object} common.Problem
+// @Router  /v2/invitations/{token}/accept [post]
+func (api *API) InvitationAcceptV2Handler(c *gin.Context) {
+	if user, ok := api.acceptInvitation(c); ok {
+		c.Header("Location", "/v2/users/"+user.PublicID)
+		respond(c, http.StatusCreated, newUserV2(user))
+	}
+}
@@ -0,0 +1,372 @@
+package api
+
+import (
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/lokhman/example-users-microservice/common"
+	"github.com/lokhman/example-users-microservice/model"
+)
+
+// NSQ topics of invitations.
+const (
+	TopicInvitationCreate = "invitation.create"
+	TopicInvitationResend = "invitation.resend"
+	TopicInvitationRevoke = "invitation.revoke"
+	TopicInvitationAccept = "invitation.accept"
+)
+
+var (
+	invitationNotFoundProblem = common.NewProblem(http.StatusNotFound, common.ErrCodeInvitationNotFound,
+		"Invitation cannot be found")
+	invalidInvitationProblem = common.NewProblem(http.StatusNotFound, common.ErrCodeInvalidInvitation,
+		"Invitation is invalid or expired")
+	invitationNotPendingProblem = common.NewProblem(http.StatusConflict, common.ErrCodeInvitationNotPending,
+		"Invitation is already accepted or revoked")
+)
+
+// Reports that pending invitation to the email exists.
+func invitationExistsProblem(email string) common.Problem {
+	return common.NewProblem(http.StatusConflict, common.ErrCodeInvitationExists,
+		fmt.Sprintf(`Invitation to "%s" is already sent`, email)).WithParam("email", email)
+}
+
+// Invitation input structure.
+type InvitationInput struct {
+	Email          string `json:"email" form:"email" binding:"required,email" example:"alex.lokhman@gmail.com"`
+	OrganizationID string `json:"organization_id" form:"organization_id" example:"01890a5d-ac96-774b-bcce-b302099a8057"`
+	Role           string `json:"role" form:"role" example:"member"`
+}
+
+// Input structure of accepted invitation, the invitee sets their own password and details.
+type InvitationAcceptInput struct {
+	Password  string `json:"password" form:"password" binding:"required,min=3,max=72" example:"MyPassword"`
+	FirstName string `json:"first_name" form:"first_name" binding:"required,max=72" example:"Alex"`
+	LastName  string `json:"last_name" form:"last_name" binding:"required,max=72" example:"Lokhman"`
+	Nickname  string `json:"nickname" form:"nickname" binding:"required,max=32" example:"VisioN"`
+	Country   string `json:"country" form:"country" binding:"required,country" example:"RU"`
+	Phone     string `json:"phone" form:"phone" binding:"max=32" example:"+79161234567"`
+}
+
+// Invitation of a new user, the token is never exposed.
+type Invitation struct {
+	XMLName        xml.Name   `gorm:"-" json:"-" xml:"invitation"`
+	ID             string     `json:"id" xml:"id" example:"01890a5d-ac96-774b-bcce-b302099a8057"`
+	Email          string     `json:"email" xml:"email" example:"alex.lokhman@gmail.com"`
+	OrganizationID *string    `json:"organization_id" xml:"organization_id,omitempty" example:"01890a5d-ac96-774b-bcce-b302099a8057"`
+	Role           string     `json:"role,omitempty" xml:"role,omitempty" example:"member"`
+	Status         string     `json:"status" xml:"status" example:"pending"`
+	ExpiresAt      time.Time  `json:"expires_at" xml:"expires_at" example:"2019-01-01T00:00:00Z"`
+	UserID         *string    `json:"user_id" xml:"user_id,omitempty" example:"01890a5d-ac96-774b-bcce-b302099a8057"`
+	AcceptedAt     *time.Time `json:"accepted_at" xml:"accepted_at,omitempty" example:"2019-01-01T00:00:00Z"`
+	CreatedAt      time.Time  `json:"created_at" xml:"created_at" example:"2019-01-01T00:00:00Z"`
+}
+
+// columns of invitation representation (see `api.invitations`)
+const invitationColumns = "invitations.public_id AS id, invitations.email, organizations.public_id AS organization_id, " +
+	"invitations.role, invitations.status, invitations.expires_at, users.public_id AS user_id, invitations.accepted_at, invitations.created_at"
+
+// Query of invitation representations, scoped API sees only invitations into its organization.
+func (api *API) invitations() *gorm.DB {
+	db := api.DB.Table("invitations").Select(invitationColumns).
+		Joins("LEFT JOIN organizations ON organizations.id = invitations.organization_id").
+		Joins("LEFT JOIN users ON users.id = invitations.user_id")
+	if api.org != nil {
+		db = db.Where("invitations.organization_id = ?", api.org.ID)
+	}
+	return db
+}
+
+// Finds representation of the invitation by internal ID.
+func (api *API) invitation(id int) (*Invitation, error) {
+	var out Invitation
+	if err := api.invitations().Where("invitations.id = ?", id).Scan(&out).Error; err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Finds invitation by public ID.
+func (api *API) findInvitation(publicID string) (*model.Invitation, error) {
+	if !common.IsUUID(publicID) {
+		return nil, invitationNotFoundProblem
+	}
+
+	db := api.DB
+	if api.org != nil {
+		db = db.Where("organization_id = ?", api.org.ID)
+	}
+	var inv model.Invitation
+	if err := db.Where("public_id = ?", publicID).First(&inv).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, invitationNotFoundProblem
+		}
+		return nil, err
+	}
+	return &inv, nil
+}
+
+// Publishes the invitation change to the queue under the topic.
+func (api *API) publishInvitation(topic string, id int) (*Invitation, error) {
+	out, err := api.invitation(id)
+	if err != nil {
+		return nil, err
+	}
+	if err = common.NSQPublish(api.NSQ, topic, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Returns expiry time of a new invitation token.
+// Token keeps expiry time in seconds, so does the invitation.
+func (api *API) invitationExpiry() time.Time {
+	return gorm.NowFunc().Add(api.InvitationTTL).Truncate(time.Second)
+}
+
+// Returns link to accept invitation with the token, which replaces "{token}" in `InvitationURL` or is appended to it.
+func (api *API) invitationLink(token string) string {
+	if strings.Contains(api.InvitationURL, "{token}") {
+		return strings.Replace(api.InvitationURL, "{token}", token, 1)
+	}
+	return api.InvitationURL + token
+}
+
+// Sends email with the token of the invitation.
+func (api *API) sendInvitation(inv *model.Invitation) error {
+	token := common.SignToken(api.InvitationSecret, inv.PublicID, inv.ExpiresAt)
+	return api.Mailer.Send(inv.Email, "You are invited", fmt.Sprintf(
+		"You are invited to join us, please follow the link to create your account:\n%s\n\nThe link expires at %s.",
+		api.invitationLink(token), inv.ExpiresAt.UTC().Format(time.RFC1123)))
+}
+
+// Lists invitations, optionally by status.
+func (api *API) ListInvitations(status string) ([]Invitation, error) {
+	invitations := make([]Invitation, 0)
+
+	db := api.invitations()
+	if status != "" {
+		db = db.Where("invitations.status = ?", status)
+	}
+	if err := db.Order("invitations.id").Scan(&invitations).Error; err != nil {
+		return nil, err
+	}
+	return invitations, nil
+}
+
+// Creates invitation of a new user by email, sends the token by email and publishes it to the queue.
+// Invitation of the scoped API is into its organization, unless the organization is given.
+func (api *API) CreateInvitation(in InvitationInput) (*Invitation, error) {
+	if err := validate(&in); err != nil {
+		return nil, err
+	}
+
+	email, err := normalizeEmail(in.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	inv := model.Invitation{PublicID: common.NewUUIDv7(), Email: in.Email, NormalizedEmail: email, Status: model.InvitationStatusPending}
+	org := api.org
+	if in.OrganizationID != "" {
+		if org, err = api.FindOrganization(in.OrganizationID); err != nil {
+			return nil, err
+		}
+	}
+	if org != nil {
+		inv.OrganizationID = &org.ID
+		inv.Role = model.OrgRoleMember
+		if in.Role != "" {
+			inv.Role = in.Role
+		}
+		if !model.IsOrgRole(inv.Role) {
+			return nil, common.FieldProblem("role", "oneof", strings.Join([]string{model.OrgRoleOwner, model.OrgRoleAdmin, model.OrgRoleMember}, " "))
+		}
+	} else if in.Role != "" {
+		return nil, common.FieldProblem("organization_id", "required", "")
+	}
+
+	// emails are unique among all users, so existing users and invitations are checked regardless of the scope
+	var count int
+	if err = api.DB.Model(&model.User{}).Where("normalized_email = ?", email).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, emailExistsProblem(in.Email)
+	}
+	err = api.DB.Model(&model.Invitation{}).Where("normalized_email = ? AND status = ? AND expires_at > ?",
+		email, model.InvitationStatusPending, gorm.NowFunc()).Count(&count).Error
+	if err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, invitationExistsProblem(in.Email)
+	}
+
+	inv.ExpiresAt = api.invitationExpiry()
+	if err = api.DB.Create(&inv).Error; err != nil {
+		return nil, err
+	}
+	if err = api.sendInvitation(&inv); err != nil {
+		// invitation that was not delivered must not hold back the next one
+		api.DB.Delete(&inv)
+		return nil, err
+	}
+
+	// try to publish message to the queue under "invitation.create" topic (see `api.CreateUser` for more details)
+	out, err := api.publishInvitation(TopicInvitationCreate, inv.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// some meaningful logs to default logger
+	log.Printf("[users] invitation with ID %d was sent", inv.ID)
+
+	return out, nil
+}
+
+// Sends a new token of the pending invitation by public ID and publishes it to the queue, previous tokens become invalid.
+func (api *API) ResendInvitation(publicID string) (*Invitation, error) {
+	inv, err := api.findInvitation(publicID)
+	if err != nil {
+		return nil, err
+	}
+	if inv.Status != model.InvitationStatusPending {
+		return nil, invitationNotPendingProblem
+	}
+
+	// new expiry time makes previous tokens invalid
+	inv.ExpiresAt = api.invitationExpiry()
+	if err = api.DB.Model(inv).UpdateColumn("expires_at", inv.ExpiresAt).Error; err != nil {
+		return nil, err
+	}
+	if err = api.sendInvitation(inv); err != nil {
+		return nil, err
+	}
+
+	// try to publish message to the queue under "invitation.resend" topic (see `api.CreateUser` for more details)
+	out, err := api.publishInvitation(TopicInvitationResend, inv.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// some meaningful logs to default logger
+	log.Printf("[users] invitation with ID %d was sent again", inv.ID)
+
+	return out, nil
+}
+
+// Revokes the pending invitation by public ID and publishes it to the queue.
+// Revoking the invitation that is already revoked does nothing.
+func (api *API) RevokeInvitation(publicID string) error {
+	inv, err := api.findInvitation(publicID)
+	if err != nil {
+		return err
+	}
+
+	result := api.DB.Model(inv).Where("status = ?", model.InvitationStatusPending).
+		UpdateColumns(map[string]interface{}{"status": model.InvitationStatusRevoked, "updated_at": gorm.NowFunc()})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		if inv.Status == model.InvitationStatusRevoked {
+			return nil
+		}
+		return invitationNotPendingProblem
+	}
+
+	// try to publish message to the queue under "invitation.revoke" topic (see `api.CreateUser` for more details)
+	if _, err = api.publishInvitation(TopicInvitationRevoke, inv.ID); err != nil {
+		return err
+	}
+
+	// some meaningful logs to default logger
+	log.Printf("[users] invitation with ID %d was revoked", inv.ID)
+
+	return nil
+}
+
+// Accepts the invitation by token: creates the user with the invited email and the given password,
+// adds them to the organization with the role (if any), records it in the audit log and publishes it to the queue.
+// The token is the only authority, so the invitation is accepted regardless of the scope of the API.
+func (api *API) AcceptInvitation(actor Actor, token string, in InvitationAcceptInput) (*model.User, error) {
+	if err := validate(&in); err != nil {
+		return nil, err
+	}
+
+	publicID, expiresAt, err := common.VerifyToken(api.InvitationSecret, token, gorm.NowFunc())
+	if err != nil {
+		return nil, invalidInvitationProblem
+	}
+	var inv model.Invitation
+	if err = api.DB.Where("public_id = ?", publicID).First(&inv).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, invalidInvitationProblem
+		}
+		return nil, err
+	}
+	if inv.Status != model.InvitationStatusPending || inv.ExpiresAt.Unix() != expiresAt.Unix() {
+		return nil, invalidInvitationProblem
+	}
+
+	var org *model.Organization
+	if inv.OrganizationID != nil {
+		org = &model.Organization{}
+		if err = api.DB.First(org, *inv.OrganizationID).Error; err != nil {
+			if gorm.IsRecordNotFoundError(err) {
+				return nil, invalidInvitationProblem
+			}
+			return nil, err
+		}
+	}
+
+	// user created in scope of the organization becomes its member (see `api.CreateUser`)
+	scoped := api.inOrganization(org)
+	user, err := scoped.createUser(actor, UserInput{
+		Email:     inv.Email,
+		Password:  in.Password,
+		FirstName: in.FirstName,
+		LastName:  in.LastName,
+		Nickname:  in.Nickname,
+		Country:   in.Country,
+		Phone:     in.Phone,
+	}, func(tx *gorm.DB, user *model.User) error {
+		// concurrent acceptance (or revocation) of the same invitation wins only once
+		result := tx.Model(&inv).Where("status = ?", model.InvitationStatusPending).UpdateColumns(map[string]interface{}{
+			"status":      model.InvitationStatusAccepted,
+			"user_id":     user.ID,
+			"accepted_at": gorm.NowFunc(),
+			"updated_at":  gorm.NowFunc(),
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return invalidInvitationProblem
+		}
+		if org != nil && inv.Role != model.OrgRoleMember {
+			return tx.Model(&model.Membership{}).Where("organization_id = ? AND user_id = ?", org.ID, user.ID).
+				UpdateColumn("role", inv.Role).Error
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// try to publish message to the queue under "invitation.accept" topic (see `api.CreateUser` for more details)
+	if _, err = scoped.publishInvitation(TopicInvitationAccept, inv.ID); err != nil {
+		return nil, err
+	}
+
+	// some meaningful logs to default logger
+	log.Printf("[users] invitation with ID %d was accepted by user with ID %d", inv.ID, user.ID)
+
+	return user, nil
+}
@@ -0,0 +1,42 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Login input structure.
+type LoginInput struct {
+	Email    string `json:"email" form:"email" binding:"required,email" example:"alex.lokhman@gmail.com"`
+	Password string `json:"password" form:"password" binding:"required" example:"MyPassword"`
+
+	// types of policies in force the user accepts with the login (see `api.PublishPolicy`)
+	Accept []string `json:"accept" form:"accept" example:"terms"`
+}
+
+// @Summary Log user in by email and password
+// @Accept  json,x-www-form-urlencoded,application/x-msgpack
+// @Produce json,application/x-msgpack,xml
+// @Param   credentials body api.LoginInput true "User credentials"
+// @Success 200 {object} model.User
+// @Failure 401 {object} common.Problem
+// @Failure 403 {object} common.Problem
+// @Failure 422 {object} common.Problem
+// @Router  /v1/login [post]
+func (api *API) LoginHandler(c *gin.Context) {
+	api = api.scoped(c)
+
+	var in LoginInput
+	if !bind(c, &in) {
+		return
+	}
+
+	user, err := api.Login(api.actor(c), in)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	respond(c, http.StatusOK, user)
+}
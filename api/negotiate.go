@@ -0,0 +1,208 @@
+package api
+
+import (
+	"encoding/xml"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/gin-gonic/gin/render"
+	"github.com/lokhman/example-users-microservice/common"
+)
+
+const (
+	mimeJSON      = binding.MIMEJSON
+	mimeXML       = binding.MIMEXML
+	mimeXML2      = binding.MIMEXML2
+	mimeMsgPack   = binding.MIMEMSGPACK
+	mimeMsgPack2  = binding.MIMEMSGPACK2
+	mimeForm      = binding.MIMEPOSTForm
+	mimeMultipart = binding.MIMEMultipartPOSTForm
+
+	formatKey = "format"
+)
+
+// Response media types in order of preference.
+var offeredFormats = []string{mimeJSON, mimeMsgPack, mimeXML}
+
+var (
+	unsupportedMediaTypeProblem = common.NewProblem(http.StatusUnsupportedMediaType, common.ErrCodeUnsupportedMediaType,
+		"Request content type is not supported")
+	notAcceptableProblem = common.NewProblem(http.StatusNotAcceptable, common.ErrCodeNotAcceptable,
+		"None of the accepted response content types is supported")
+)
+
+// Element types may name the root element of their XML list, if it's not the plural of the element name.
+type xmlListNamer interface {
+	XMLListName() string
+}
+
+// List wrapped into root element for XML response, as XML document must have a single root element.
+// Root is named after the elements, e.g. list of "user" elements is wrapped into "users" (see `xmlListName`).
+type xmlList struct {
+	items reflect.Value
+}
+
+func (l xmlList) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	start := xml.StartElement{Name: xml.Name{Local: xmlListName(l.items.Type().Elem())}}
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	for i := 0; i < l.items.Len(); i++ {
+		if err := e.Encode(l.items.Index(i).Interface()); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+// Names root element of XML list by the element type: the plural of element name from its `XMLName` field.
+func xmlListName(t reflect.Type) string {
+	if namer, ok := reflect.Zero(t).Interface().(xmlListNamer); ok {
+		return namer.XMLListName()
+	}
+
+	name := "item"
+	if t.Kind() == reflect.Struct {
+		if f, ok := t.FieldByName("XMLName"); ok {
+			if tag := strings.Split(f.Tag.Get("xml"), ",")[0]; tag != "" {
+				name = tag
+			}
+		}
+	}
+	if strings.HasSuffix(name, "y") {
+		return name[:len(name)-1] + "ies"
+	}
+	return name + "s"
+}
+
+// Parses `Accept` header value into media ranges ordered by quality.
+func parseAccept(header string) []string {
+	type weighted struct {
+		mediaRange string
+		q          float64
+	}
+
+	var ranges []weighted
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		mediaRange := strings.ToLower(strings.TrimSpace(fields[0]))
+		if mediaRange == "" {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if q > 0 {
+			ranges = append(ranges, weighted{mediaRange, q})
+		}
+	}
+
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].q > ranges[j].q
+	})
+
+	result := make([]string, len(ranges))
+	for i, r := range ranges {
+		result[i] = r.mediaRange
+	}
+	return result
+}
+
+// Selects response media type from the offered ones by `Accept` header.
+// Returns empty string if none of the offered types is acceptable.
+func negotiateFormat(accept string, offered []string) string {
+	ranges := parseAccept(accept)
+	if len(ranges) == 0 {
+		return offered[0]
+	}
+
+	for _, mediaRange := range ranges {
+		// aliases are accepted, but the canonical media type is responded
+		switch mediaRange {
+		case mimeXML2:
+			mediaRange = mimeXML
+		case mimeMsgPack2:
+			mediaRange = mimeMsgPack
+		}
+
+		for _, format := range offered {
+			switch {
+			case mediaRange == "*/*", mediaRange == format:
+				return format
+			case strings.HasSuffix(mediaRange, "/*") && strings.HasPrefix(format, mediaRange[:len(mediaRange)-1]):
+				return format
+			}
+		}
+	}
+	return ""
+}
+
+// Returns response media type negotiated for the request.
+func responseFormat(c *gin.Context) string {
+	if format, ok := c.Get(formatKey); ok {
+		return format.(string)
+	}
+	return mimeJSON
+}
+
+// Negotiates response media type by `Accept` header and rejects requests with unsupported one.
+func (api *API) NegotiationMiddleware(c *gin.Context) {
+	format := negotiateFormat(c.GetHeader("Accept"), offeredFormats)
+	if format == "" {
+		abortWithProblem(c, notAcceptableProblem)
+		return
+	}
+	c.Set(formatKey, format)
+	c.Next()
+}
+
+// Renders data in the negotiated media type.
+func respond(c *gin.Context, code int, data interface{}) {
+	switch responseFormat(c) {
+	case mimeMsgPack:
+		c.Render(code, render.MsgPack{Data: data})
+	case mimeXML:
+		// XML document must have a single root element
+		if v := reflect.ValueOf(data); v.Kind() == reflect.Slice {
+			data = xmlList{items: v}
+		}
+		c.XML(code, data)
+	default:
+		c.JSON(code, data)
+	}
+}
+
+// Binds request body to the input structure according to `Content-Type` header and reports problem if it fails.
+// Requests without content type are treated as JSON for backward compatibility.
+func bind(c *gin.Context, in interface{}) bool {
+	var b binding.Binding
+	switch c.ContentType() {
+	case "", mimeJSON:
+		b = binding.JSON
+	case mimeForm:
+		b = binding.Form
+	case mimeMsgPack, mimeMsgPack2:
+		b = binding.MsgPack
+	default:
+		abortWithProblem(c, unsupportedMediaTypeProblem)
+		return false
+	}
+
+	if err := c.ShouldBindWith(in, b); err != nil {
+		abortWithProblem(c, common.BindingProblem(err, in))
+		return false
+	}
+	return true
+}
@@ -0,0 +1,39 @@
+// +build !integration
+
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lokhman/example-users-microservice/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegotiateFormat(t *testing.T) {
+	assert.Equal(t, mimeJSON, negotiateFormat("", offeredFormats))
+	assert.Equal(t, mimeJSON, negotiateFormat("*/*", offeredFormats))
+	assert.Equal(t, mimeJSON, negotiateFormat("application/*", offeredFormats))
+	assert.Equal(t, mimeXML, negotiateFormat("text/xml", offeredFormats))
+	assert.Equal(t, mimeXML, negotiateFormat("application/json;q=0.5, application/xml", offeredFormats))
+	assert.Equal(t, mimeMsgPack, negotiateFormat("application/msgpack, */*;q=0.1", offeredFormats))
+	assert.Equal(t, "", negotiateFormat("text/html, application/json;q=0", offeredFormats))
+}
+
+func TestRespondXMLList(t *testing.T) {
+	render := func(data interface{}) string {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Set(formatKey, mimeXML)
+		respond(c, http.StatusOK, data)
+		return w.Body.String()
+	}
+
+	assert.Contains(t, render([]UserV2{{ID: "01890a5d-ac96-774b-bcce-b302099a8057"}}), "<users><user><id>01890a5d")
+	assert.Contains(t, render([]model.PolicyVersion{{Type: "terms"}}), "<policies><policy>")
+	assert.Contains(t, render([]model.UserAudit{{Action: "create"}}), "<history><entry>")
+	assert.Equal(t, "<countries></countries>", render([]Country{}))
+	assert.Contains(t, render(Country{Code: "GB"}), "<country>")
+}
@@ -0,0 +1,26 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// @Summary Check if nickname is available
+// @Description Nickname is not available if it is reserved or (if nicknames are unique) taken in any case.
+// @Description Free variants are suggested for nickname that is not available.
+// @Accept  json
+// @Produce json,application/x-msgpack,xml
+// @Param   nickname query string true "Nickname"
+// @Success 200 {object} api.NicknameAvailability
+// @Failure 400 {object} common.Problem
+// @Router  /v1/nicknames/availability [get]
+func (api *API) NicknameAvailabilityHandler(c *gin.Context) {
+	availability, err := api.CheckNicknameAvailability(c.Query("nickname"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	respond(c, http.StatusOK, availability)
+}
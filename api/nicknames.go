@@ -0,0 +1,121 @@
+package api
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/lokhman/example-users-microservice/common"
+	"github.com/lokhman/example-users-microservice/model"
+)
+
+const (
+	// maximum length of nickname in characters (see `model.User`)
+	nicknameMaxLen = 32
+
+	// number of free nicknames suggested when the requested one is not available
+	nicknameSuggestions = 5
+)
+
+// Reasons why nickname is not available.
+const (
+	NicknameReserved = "reserved"
+	NicknameTaken    = "taken"
+)
+
+// Availability of nickname with free variants suggested if it is not available.
+type NicknameAvailability struct {
+	XMLName     xml.Name `json:"-" xml:"availability"`
+	Nickname    string   `json:"nickname" xml:"nickname" example:"VisioN"`
+	Available   bool     `json:"available" xml:"available" example:"false"`
+	Reason      string   `json:"reason,omitempty" xml:"reason,omitempty" example:"taken"`
+	Suggestions []string `json:"suggestions,omitempty" xml:"suggestions>nickname,omitempty" example:"VisioN42,VisioN_815"`
+}
+
+// Reports that user with nickname already exists.
+func nicknameExistsProblem(nickname string) common.Problem {
+	return common.NewProblem(http.StatusUnprocessableEntity, common.ErrCodeNicknameExists,
+		fmt.Sprintf(`User with nickname "%s" exists`, nickname)).WithParam("nickname", nickname)
+}
+
+// Reports problem if nickname is reserved.
+func checkNickname(nickname string) error {
+	if common.IsReservedNickname(nickname) {
+		return common.FieldProblem("nickname", "reserved", "")
+	}
+	return nil
+}
+
+// Finds which of the nicknames are taken by users that are not deleted (in lower case).
+// Nothing is taken if nicknames are not unique.
+func (api *API) takenNicknames(nicknames []string) (map[string]bool, error) {
+	taken := make(map[string]bool)
+	if !model.UniqueNicknames || len(nicknames) == 0 {
+		return taken, nil
+	}
+
+	lower := make([]string, len(nicknames))
+	for i, nickname := range nicknames {
+		lower[i] = strings.ToLower(nickname)
+	}
+	var found []string
+	if err := api.DB.Model(&model.User{}).Where("lower(nickname) IN (?)", lower).Pluck("lower(nickname)", &found).Error; err != nil {
+		return nil, err
+	}
+	for _, nickname := range found {
+		taken[nickname] = true
+	}
+	return taken, nil
+}
+
+// Suggests free variants of the nickname, there may be less of them if random variants happen to be taken.
+func (api *API) SuggestNicknames(nickname string) ([]string, error) {
+	variants := common.NicknameVariants(nickname, nicknameSuggestions*2, nicknameMaxLen)
+	taken, err := api.takenNicknames(variants)
+	if err != nil {
+		return nil, err
+	}
+
+	suggestions := make([]string, 0, nicknameSuggestions)
+	for _, v := range variants {
+		if len(suggestions) == nicknameSuggestions {
+			break
+		}
+		if !taken[strings.ToLower(v)] && !common.IsReservedNickname(v) {
+			suggestions = append(suggestions, v)
+		}
+	}
+	return suggestions, nil
+}
+
+// Checks if nickname is available for a new user, suggests free variants if it is not.
+func (api *API) CheckNicknameAvailability(nickname string) (*NicknameAvailability, error) {
+	nickname = strings.TrimSpace(nickname)
+	if nickname == "" || utf8.RuneCountInString(nickname) > nicknameMaxLen {
+		return nil, invalidQueryProblem("nickname")
+	}
+
+	out := &NicknameAvailability{Nickname: nickname, Available: true}
+	if common.IsReservedNickname(nickname) {
+		out.Available, out.Reason = false, NicknameReserved
+	} else {
+		taken, err := api.takenNicknames([]string{nickname})
+		if err != nil {
+			return nil, err
+		}
+		if taken[strings.ToLower(nickname)] {
+			out.Available, out.Reason = false, NicknameTaken
+		}
+	}
+
+	if !out.Available {
+		suggestions, err := api.SuggestNicknames(nickname)
+		if err != nil {
+			return nil, err
+		}
+		out.Suggestions = suggestions
+	}
+	return out, nil
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// @Summary List organizations
+// @Accept  json
+// @Produce json,application/x-msgpack,xml
+// @Success 200 {array} model.Organization
+// @Router  /v1/organizations [get]
+func (api *API) OrganizationIndexHandler(c *gin.Context) {
+	api = api.scoped(c)
+
+	orgs, err := api.ListOrganizations()
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	respond(c, http.StatusOK, orgs)
+}
+
+// @Summary Create new organization (administrators only)
+// @Accept  json,x-www-form-urlencoded,application/x-msgpack
+// @Produce json,application/x-msgpack,xml
+// @Param   organization body api.OrganizationInput true "New organization details"
+// @Success 201 {object} model.Organization
+// @Failure 400 {object} common.Problem
+// @Failure 403 {object} common.Problem
+// @Failure 422 {object} common.Problem
+// @Router  /v1/organizations [post]
+func (api *API) OrganizationCreateHandler(c *gin.Context) {
+	if !api.requireAdmin(c) {
+		return
+	}
+
+	var in OrganizationInput
+	if !bind(c, &in) {
+		return
+	}
+
+	org, err := api.CreateOrganization(in)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	respond(c, http.StatusCreated, org)
+}
+
+// @Summary View organization by ID
+// @Accept  json
+// @Produce json,application/x-msgpack,xml
+// @Param   id path string true "Organization public ID" format(uuid)
+// @Success 200 {object} model.Organization
+// @Failure 404 {object} common.Problem
+// @Router  /v1/organizations/{id} [get]
+func (api *API) OrganizationViewHandler(c *gin.Context) {
+	api = api.scoped(c)
+
+	org, err := api.FindOrganization(c.Param("id"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	respond(c, http.StatusOK, org)
+}
+
+// @Summary Update organization by ID (administrators only)
+// @Accept  json,x-www-form-urlencoded,application/x-msgpack
+// @Produce json,application/x-msgpack,xml
+// @Param   id path string true "Organization public ID" format(uuid)
+// @Param   organization body api.OrganizationInput true "Organization details"
+// @Success 200 {object} model.Organization
+// @Failure 400 {object} common.Problem
+// @Failure 403 {object} common.Problem
+// @Failure 404 {object} common.Problem
+// @Failure 422 {object} common.Problem
+// @Router  /v1/organizations/{id} [put]
+func (api *API) OrganizationUpdateHandler(c *gin.Context) {
+	api = api.scoped(c)
+
+	if !api.requireAdmin(c) {
+		return
+	}
+
+	var in OrganizationInput
+	if !bind(c, &in) {
+		return
+	}
+
+	org, err := api.UpdateOrganization(c.Param("id"), in)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	respond(c, http.StatusOK, org)
+}
+
+// @Summary Delete organization by ID with all memberships (administrators only)
+// @Accept  json
+// @Produce json,application/x-msgpack,xml
+// @Param   id path string true "Organization public ID" format(uuid)
+// @Success 204 ""
+// @Failure 403 {object} common.Problem
+// @Router  /v1/organizations/{id} [delete]
+func (api *API) OrganizationDeleteHandler(c *gin.Context) {
+	api = api.scoped(c)
+
+	if !api.requireAdmin(c) {
+		return
+	}
+
+	// DELETE request is idempotent, so we show that request was successful even if organization doesn't exist
+	if err := api.DeleteOrganization(c.Param("id")); err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	respond(c, http.StatusNoContent, nil)
+}
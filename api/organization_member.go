@@ -0,0 +1,84 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// @Summary List members of organization by ID
+// @Accept  json
+// @Produce json,application/x-msgpack,xml
+// @Param   id path string true "Organization public ID" format(uuid)
+// @Success 200 {array} api.Member
+// @Failure 404 {object} common.Problem
+// @Router  /v1/organizations/{id}/members [get]
+func (api *API) OrganizationMemberIndexHandler(c *gin.Context) {
+	api = api.scoped(c)
+
+	members, err := api.ListMembers(c.Param("id"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	respond(c, http.StatusOK, members)
+}
+
+// @Summary Add user to organization or change the role of the member (administrators only)
+// @Accept  json,x-www-form-urlencoded,application/x-msgpack
+// @Produce json,application/x-msgpack,xml
+// @Param   id path string true "Organization public ID" format(uuid)
+// @Param   user_id path string true "User public ID" format(uuid)
+// @Param   member body api.MemberInput true "Role of the member"
+// @Success 200 {object} api.Member
+// @Failure 400 {object} common.Problem
+// @Failure 403 {object} common.Problem
+// @Failure 404 {object} common.Problem
+// @Failure 422 {object} common.Problem
+// @Router  /v1/organizations/{id}/members/{user_id} [put]
+func (api *API) OrganizationMemberSaveHandler(c *gin.Context) {
+	api = api.scoped(c)
+
+	if !api.requireAdmin(c) {
+		return
+	}
+
+	var in MemberInput
+	if !bind(c, &in) {
+		return
+	}
+
+	member, err := api.SetMember(c.Param("id"), c.Param("user_id"), in)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	respond(c, http.StatusOK, member)
+}
+
+// @Summary Remove user from organization (administrators only)
+// @Accept  json
+// @Produce json,application/x-msgpack,xml
+// @Param   id path string true "Organization public ID" format(uuid)
+// @Param   user_id path string true "User public ID" format(uuid)
+// @Success 204 ""
+// @Failure 403 {object} common.Problem
+// @Failure 404 {object} common.Problem
+// @Router  /v1/organizations/{id}/members/{user_id} [delete]
+func (api *API) OrganizationMemberDeleteHandler(c *gin.Context) {
+	api = api.scoped(c)
+
+	if !api.requireAdmin(c) {
+		return
+	}
+
+	// DELETE request is idempotent, so we show that request was successful even if user is not a member
+	if err := api.RemoveMember(c.Param("id"), c.Param("user_id")); err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	respond(c, http.StatusNoContent, nil)
+}
@@ -0,0 +1,407 @@
+package api
+
+import (
+	"encoding/xml"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/lokhman/example-users-microservice/common"
+	"github.com/lokhman/example-users-microservice/model"
+)
+
+// NSQ topics of organization changes.
+const (
+	TopicOrganizationCreate = "organization.create"
+	TopicOrganizationUpdate = "organization.update"
+	TopicOrganizationDelete = "organization.delete"
+
+	TopicOrganizationMemberChanged = "organization.member_changed"
+	TopicOrganizationMemberRemoved = "organization.member_removed"
+)
+
+const (
+	// public ID of the organization that request is scoped to
+	OrganizationHeader = "X-Organization-ID"
+
+	organizationKey = "organization"
+)
+
+var (
+	organizationNotFoundProblem = common.NewProblem(http.StatusNotFound, common.ErrCodeOrganizationNotFound,
+		"Organization cannot be found")
+	organizationRequiredProblem = common.NewProblem(http.StatusBadRequest, common.ErrCodeOrganizationRequired,
+		`Organization is required, set "X-Organization-ID" header`)
+)
+
+// Organization input structure.
+type OrganizationInput struct {
+	Name string `json:"name" form:"name" binding:"required,max=128" example:"Acme Corporation"`
+}
+
+// Member input structure.
+type MemberInput struct {
+	Role string `json:"role" form:"role" binding:"required" example:"member"`
+}
+
+// User who is member of the organization.
+type Member struct {
+	XMLName  xml.Name  `gorm:"-" json:"-" xml:"member"`
+	UserID   string    `json:"user_id" xml:"user_id" example:"01890a5d-ac96-774b-bcce-b302099a8057"`
+	Email    string    `json:"email" xml:"email" example:"alex.lokhman@gmail.com"`
+	Nickname string    `json:"nickname" xml:"nickname" example:"VisioN"`
+	Role     string    `json:"role" xml:"role" example:"member"`
+	JoinedAt time.Time `json:"joined_at" xml:"joined_at" example:"2019-01-01T00:00:00Z"`
+}
+
+// Message of "organization.member_*" topics.
+type MemberMessage struct {
+	OrgID string `json:"org_id"`
+	Member
+}
+
+// Message of user topics, tagged with the organization the change was made in (if any)
+// and all organizations the user is member of, so consumers of every tenant can pick up their changes.
+type UserMessage struct {
+	model.User
+	OrgID  string   `json:"org_id,omitempty"`
+	OrgIDs []string `json:"org_ids"`
+}
+
+// Tenant isolation: API scoped to the organization sees only the organization and users who are its members.
+// Requests are scoped by `X-Organization-ID` header (see `api.TenantMiddleware`), it is required for access to users
+// and organizations (see `api.RequireTenantMiddleware`), only administrators may omit it to access all organizations.
+
+// Returns copy of the API scoped to the organization.
+func (api *API) inOrganization(org *model.Organization) *API {
+	scoped := *api
+	scoped.org = org
+	return &scoped
+}
+
+// Returns the API scoped to the organization of the request (if any).
+func (api *API) scoped(c *gin.Context) *API {
+	if org, ok := c.Get(organizationKey); ok {
+		return api.inOrganization(org.(*model.Organization))
+	}
+	return api
+}
+
+// Returns public ID of the organization the API is scoped to, empty if not scoped.
+func (api *API) orgID() string {
+	if api.org == nil {
+		return ""
+	}
+	return api.org.PublicID
+}
+
+// Resolves organization of `X-Organization-ID` header, unknown organization is not found.
+func (api *API) TenantMiddleware(c *gin.Context) {
+	publicID := c.GetHeader(OrganizationHeader)
+	if publicID == "" {
+		c.Next()
+		return
+	}
+
+	org, err := api.FindOrganization(publicID)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Set(organizationKey, org)
+	c.Next()
+}
+
+// Rejects requests that are not scoped to organization, unless they are made by administrator.
+func (api *API) RequireTenantMiddleware(c *gin.Context) {
+	if _, ok := c.Get(organizationKey); !ok && !api.isAdmin(c) {
+		abortWithProblem(c, organizationRequiredProblem)
+		return
+	}
+	c.Next()
+}
+
+// Limits query of users to members of the organization the API is scoped to.
+func (api *API) scopeUsers(db *gorm.DB) *gorm.DB {
+	if api.org == nil {
+		return db
+	}
+	return db.Where("users.id IN (SELECT user_id FROM memberships WHERE organization_id = ?)", api.org.ID)
+}
+
+// Finds public IDs of organizations of the users by user IDs.
+func userOrgIDs(db *gorm.DB, ids ...int) (map[int][]string, error) {
+	rows, err := db.Table("memberships").Select("memberships.user_id, organizations.public_id").
+		Joins("JOIN organizations ON organizations.id = memberships.organization_id").
+		Where("memberships.user_id IN (?)", ids).Order("memberships.organization_id").Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	orgIDs := make(map[int][]string)
+	for rows.Next() {
+		var userID int
+		var orgID string
+		if err = rows.Scan(&userID, &orgID); err != nil {
+			return nil, err
+		}
+		orgIDs[userID] = append(orgIDs[userID], orgID)
+	}
+	return orgIDs, rows.Err()
+}
+
+// Creates message of the user change with the given organizations of the user.
+func (api *API) userMessage(user *model.User, orgIDs []string) UserMessage {
+	if orgIDs == nil {
+		orgIDs = []string{}
+	}
+	return UserMessage{User: *user, OrgID: api.orgID(), OrgIDs: orgIDs}
+}
+
+// Publishes the user change to the queue under the topic, tagged with organizations of the user.
+func (api *API) publishUser(topic string, user *model.User) error {
+	orgIDs, err := userOrgIDs(api.DB, user.ID)
+	if err != nil {
+		return err
+	}
+	return common.NSQPublish(api.NSQ, topic, api.userMessage(user, orgIDs[user.ID]))
+}
+
+// Finds organization by public ID.
+func (api *API) FindOrganization(publicID string) (*model.Organization, error) {
+	if !common.IsUUID(publicID) || api.org != nil && api.org.PublicID != publicID {
+		return nil, organizationNotFoundProblem
+	}
+
+	var org model.Organization
+	if err := api.DB.Where("public_id = ?", publicID).First(&org).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, organizationNotFoundProblem
+		}
+		return nil, err
+	}
+	return &org, nil
+}
+
+// Lists organizations.
+func (api *API) ListOrganizations() ([]model.Organization, error) {
+	orgs := make([]model.Organization, 0)
+
+	db := api.DB
+	if api.org != nil {
+		db = db.Where("id = ?", api.org.ID)
+	}
+	if err := db.Order("id").Find(&orgs).Error; err != nil {
+		return nil, err
+	}
+	return orgs, nil
+}
+
+// Creates new organization and publishes it to the queue.
+func (api *API) CreateOrganization(in OrganizationInput) (*model.Organization, error) {
+	if err := validate(&in); err != nil {
+		return nil, err
+	}
+
+	org := model.Organization{PublicID: common.NewUUIDv7(), Name: strings.TrimSpace(in.Name)}
+	if err := api.DB.Create(&org).Error; err != nil {
+		return nil, err
+	}
+
+	// try to publish message to the queue under "organization.create" topic (see `api.CreateUser` for more details)
+	if err := common.NSQPublish(api.NSQ, TopicOrganizationCreate, org); err != nil {
+		return nil, err
+	}
+
+	// some meaningful logs to default logger
+	log.Printf("[users] organization with ID %d was created", org.ID)
+
+	return &org, nil
+}
+
+// Updates organization by public ID and publishes it to the queue.
+func (api *API) UpdateOrganization(publicID string, in OrganizationInput) (*model.Organization, error) {
+	if err := validate(&in); err != nil {
+		return nil, err
+	}
+
+	org, err := api.FindOrganization(publicID)
+	if err != nil {
+		return nil, err
+	}
+	org.Name = strings.TrimSpace(in.Name)
+	if err = api.DB.Save(org).Error; err != nil {
+		return nil, err
+	}
+
+	// try to publish message to the queue under "organization.update" topic (see `api.CreateUser` for more details)
+	if err = common.NSQPublish(api.NSQ, TopicOrganizationUpdate, org); err != nil {
+		return nil, err
+	}
+
+	// some meaningful logs to default logger
+	log.Printf("[users] organization with ID %d was updated", org.ID)
+
+	return org, nil
+}
+
+// Deletes organization by public ID with all memberships and groups and publishes it to the queue, users are kept.
+// Deletion of the organization that doesn't exist is not an error, as the operation is idempotent.
+func (api *API) DeleteOrganization(publicID string) error {
+	org, err := api.FindOrganization(publicID)
+	if err != nil {
+		if common.IsProblem(err, common.ErrCodeOrganizationNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	err = api.transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("organization_id = ?", org.ID).Delete(&model.Membership{}).Error; err != nil {
+			return err
+		}
+		err := tx.Where("group_id IN (SELECT id FROM groups WHERE organization_id = ?)", org.ID).Delete(&model.GroupMember{}).Error
+		if err != nil {
+			return err
+		}
+		if err = tx.Where("organization_id = ?", org.ID).Delete(&model.Group{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(org).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	// try to publish message to the queue under "organization.delete" topic (see `api.CreateUser` for more details)
+	if err = common.NSQPublish(api.NSQ, TopicOrganizationDelete, org); err != nil {
+		return err
+	}
+
+	// some meaningful logs to default logger
+	log.Printf("[users] organization with ID %d was deleted", org.ID)
+
+	return nil
+}
+
+// Query of members (that are not deleted) of the organization.
+func (api *API) members(orgID int) *gorm.DB {
+	return api.DB.Table("memberships").
+		Select("users.public_id AS user_id, users.email, users.nickname, memberships.role, memberships.created_at AS joined_at").
+		Joins("JOIN users ON users.id = memberships.user_id AND users.deleted_at IS NULL").
+		Where("memberships.organization_id = ?", orgID)
+}
+
+// Lists members of the organization by public ID.
+func (api *API) ListMembers(publicID string) ([]Member, error) {
+	org, err := api.FindOrganization(publicID)
+	if err != nil {
+		return nil, err
+	}
+
+	members := make([]Member, 0)
+	if err = api.members(org.ID).Order("memberships.user_id").Scan(&members).Error; err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// Adds user (by public ID) to the organization (by public ID) or changes the role of the member,
+// and publishes it to the queue.
+func (api *API) SetMember(publicID, userID string, in MemberInput) (*Member, error) {
+	if err := validate(&in); err != nil {
+		return nil, err
+	}
+	if !model.IsOrgRole(in.Role) {
+		return nil, common.FieldProblem("role", "oneof", strings.Join([]string{model.OrgRoleOwner, model.OrgRoleAdmin, model.OrgRoleMember}, " "))
+	}
+
+	org, err := api.FindOrganization(publicID)
+	if err != nil {
+		return nil, err
+	}
+
+	// users who are not members yet are out of the scope, so they are found among all users
+	if !common.IsUUID(userID) {
+		return nil, invalidUserIDProblem
+	}
+	var user model.User
+	if err = api.DB.Where("public_id = ?", userID).First(&user).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, userNotFoundProblem
+		}
+		return nil, err
+	}
+
+	now := gorm.NowFunc()
+	err = api.DB.Exec(`
+		INSERT INTO memberships (organization_id, user_id, role, created_at, updated_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (organization_id, user_id) DO UPDATE SET role = EXCLUDED.role, updated_at = EXCLUDED.updated_at
+	`, org.ID, user.ID, in.Role, now, now).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var member Member
+	if err = api.members(org.ID).Where("memberships.user_id = ?", user.ID).Scan(&member).Error; err != nil {
+		return nil, err
+	}
+
+	// try to publish message to the queue under "organization.member_changed" topic (see `api.CreateUser` for more details)
+	if err = common.NSQPublish(api.NSQ, TopicOrganizationMemberChanged, MemberMessage{OrgID: org.PublicID, Member: member}); err != nil {
+		return nil, err
+	}
+
+	// some meaningful logs to default logger
+	log.Printf("[users] user with ID %d is %s of organization with ID %d", user.ID, in.Role, org.ID)
+
+	return &member, nil
+}
+
+// Removes user (by public ID) from the organization (by public ID) and its groups and publishes it to the queue.
+// Removal of the user who is not a member is not an error, as the operation is idempotent.
+func (api *API) RemoveMember(publicID, userID string) error {
+	org, err := api.FindOrganization(publicID)
+	if err != nil {
+		return err
+	}
+	if !common.IsUUID(userID) {
+		return invalidUserIDProblem
+	}
+
+	var member Member
+	err = api.members(org.ID).Where("users.public_id = ?", userID).Scan(&member).Error
+	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil
+		}
+		return err
+	}
+	// groups of the organization take only its members (see `api.AddGroupMember`), so the user leaves them too
+	err = api.transaction(func(tx *gorm.DB) error {
+		err := tx.Exec("DELETE FROM group_members WHERE group_id IN (SELECT id FROM groups WHERE organization_id = ?) AND user_id = (SELECT id FROM users WHERE public_id = ?)",
+			org.ID, userID).Error
+		if err != nil {
+			return err
+		}
+		return tx.Exec("DELETE FROM memberships WHERE organization_id = ? AND user_id = (SELECT id FROM users WHERE public_id = ?)",
+			org.ID, userID).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	// try to publish message to the queue under "organization.member_removed" topic (see `api.CreateUser` for more details)
+	if err = common.NSQPublish(api.NSQ, TopicOrganizationMemberRemoved, MemberMessage{OrgID: org.PublicID, Member: member}); err != nil {
+		return err
+	}
+
+	// some meaningful logs to default logger
+	log.Printf("[users] user %s was removed from organization with ID %d", userID, org.ID)
+
+	return nil
+}
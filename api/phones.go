@@ -0,0 +1,201 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/lokhman/example-users-microservice/common"
+	"github.com/lokhman/example-users-microservice/model"
+)
+
+const (
+	// number of digits in verification code
+	phoneCodeLength = 6
+
+	// how long verification code is valid
+	phoneCodeTTL = 10 * time.Minute
+
+	// how long user waits before the next code is sent, as every message costs money
+	phoneCodeResendAfter = time.Minute
+
+	// number of wrong codes after which the code has to be sent again
+	phoneCodeMaxAttempts = 5
+)
+
+var (
+	phoneNotSetProblem = common.NewProblem(http.StatusConflict, common.ErrCodePhoneNotSet,
+		"User has no phone number")
+	phoneVerifiedProblem = common.NewProblem(http.StatusConflict, common.ErrCodePhoneVerified,
+		"Phone number of the user is already verified")
+	invalidVerificationCodeProblem = common.NewProblem(http.StatusUnprocessableEntity, common.ErrCodeInvalidVerificationCode,
+		"Verification code is invalid or expired")
+)
+
+// Reports that verification code was sent recently and the next one can be sent after a while.
+func verificationTooSoonProblem(wait time.Duration) common.Problem {
+	seconds := strconv.Itoa(int(wait.Seconds() + 1))
+	return common.NewProblem(http.StatusTooManyRequests, common.ErrCodeVerificationTooSoon,
+		fmt.Sprintf("Verification code can be sent again in %s seconds", seconds)).WithParam("seconds", seconds)
+}
+
+// Phone verification input structure.
+type PhoneVerificationInput struct {
+	Code string `json:"code" form:"code" binding:"required" example:"123456"`
+}
+
+// Normalizes phone of the input to E.164 format with the country as default region, reports problem of the field if it fails.
+// Empty phone is kept as is, as it is optional.
+func normalizePhone(phone, country string) (string, error) {
+	if phone == "" {
+		return "", nil
+	}
+	normalized, err := common.NormalizePhone(phone, country)
+	if err != nil {
+		return "", common.FieldProblem("phone", "phone", "")
+	}
+	return normalized, nil
+}
+
+// Generates random numeric verification code.
+func newPhoneCode() (string, error) {
+	max := new(big.Int).Exp(big.NewInt(10), big.NewInt(phoneCodeLength), nil)
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", phoneCodeLength, n), nil
+}
+
+// Hashes verification code bound to the user and the phone, so codes are never stored as they are.
+func hashPhoneCode(userID int, phone, code string) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%d:%s:%s", userID, phone, code)))
+	return hex.EncodeToString(sum[:])
+}
+
+// Sends verification code to the phone of the user by SMS, the previous code (if any) becomes invalid.
+func (api *API) SendPhoneVerification(id int) error {
+	user, err := api.FindUser(id)
+	if err != nil {
+		return err
+	}
+	if user.Phone == "" {
+		return phoneNotSetProblem
+	}
+	if user.PhoneVerifiedAt != nil {
+		return phoneVerifiedProblem
+	}
+
+	now := gorm.NowFunc()
+	var previous model.PhoneVerification
+	err = api.DB.Where("user_id = ?", id).First(&previous).Error
+	if err == nil && previous.Phone == user.Phone {
+		if wait := previous.CreatedAt.Add(phoneCodeResendAfter).Sub(now); wait > 0 {
+			return verificationTooSoonProblem(wait)
+		}
+	} else if err != nil && !gorm.IsRecordNotFoundError(err) {
+		return err
+	}
+
+	code, err := newPhoneCode()
+	if err != nil {
+		return err
+	}
+
+	// throttle is checked again by the upsert itself, so of concurrent requests only one sends the code
+	result := api.DB.Exec(`
+		INSERT INTO phone_verifications (user_id, phone, code_hash, attempts, expires_at, created_at) VALUES (?, ?, ?, 0, ?, ?)
+		ON CONFLICT (user_id) DO UPDATE SET phone = EXCLUDED.phone, code_hash = EXCLUDED.code_hash, attempts = 0,
+			expires_at = EXCLUDED.expires_at, created_at = EXCLUDED.created_at
+		WHERE phone_verifications.phone <> EXCLUDED.phone OR phone_verifications.created_at <= ?
+	`, id, user.Phone, hashPhoneCode(id, user.Phone, code), now.Add(phoneCodeTTL), now, now.Add(-phoneCodeResendAfter))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return verificationTooSoonProblem(phoneCodeResendAfter)
+	}
+
+	if err = api.SMS.Send(user.Phone, fmt.Sprintf("Your verification code is %s", code)); err != nil {
+		// code that was not delivered must not hold back the next one
+		api.DB.Where("user_id = ?", id).Delete(&model.PhoneVerification{})
+		return err
+	}
+
+	// some meaningful logs to default logger
+	log.Printf("[users] verification code was sent to phone of user with ID %d", id)
+
+	return nil
+}
+
+// Verifies phone of the user by the code sent by SMS, records it in the audit log and publishes it to the queue.
+// Code is valid only for the phone it was sent to and only for a few attempts.
+func (api *API) VerifyUserPhone(actor Actor, id int, code string) (*model.User, error) {
+	user, err := api.FindUser(id)
+	if err != nil {
+		return nil, err
+	}
+
+	// attempt is claimed before the code is compared, so concurrent guesses can't exceed the limit
+	v := model.PhoneVerification{UserID: id, Phone: user.Phone}
+	err = api.DB.Raw(`
+		UPDATE phone_verifications SET attempts = attempts + 1
+		WHERE user_id = ? AND phone = ? AND attempts < ? AND expires_at > ?
+		RETURNING code_hash
+	`, id, user.Phone, phoneCodeMaxAttempts, gorm.NowFunc()).Row().Scan(&v.CodeHash)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, invalidVerificationCodeProblem
+		}
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(v.CodeHash), []byte(hashPhoneCode(id, v.Phone, code))) != 1 {
+		return nil, invalidVerificationCodeProblem
+	}
+
+	// user is locked, so the phone can't be changed while it is verified
+	err = api.transaction(func(tx *gorm.DB) error {
+		if err := tx.Set("gorm:query_option", "FOR UPDATE").First(user, id).Error; err != nil {
+			if gorm.IsRecordNotFoundError(err) {
+				return userNotFoundProblem
+			}
+			return err
+		}
+		if user.Phone != v.Phone {
+			return invalidVerificationCodeProblem
+		}
+		before := *user
+
+		now := gorm.NowFunc()
+		user.PhoneVerifiedAt = &now
+		if err := tx.Save(user).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&v).Error; err != nil {
+			return err
+		}
+		return audit(tx, actor, model.UserAuditUpdate, &before, user)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// try to publish message to the queue under "user.update" topic (see `api.CreateUser` for more details)
+	if err = api.publishUser(TopicUserUpdate, user); err != nil {
+		return nil, err
+	}
+
+	// some meaningful logs to default logger
+	log.Printf("[users] phone of user with ID %d was verified", user.ID)
+
+	return user, nil
+}
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// @Summary List published versions of policies
+// @Description The newest version of each type is in force.
+// @Accept  json
+// @Produce json,application/x-msgpack,xml
+// @Success 200 {array} model.PolicyVersion
+// @Router  /v1/policies [get]
+func (api *API) PolicyIndexHandler(c *gin.Context) {
+	api = api.scoped(c)
+
+	policies, err := api.ListPolicies()
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	respond(c, http.StatusOK, policies)
+}
+
+// @Summary Publish new version of policy (administrators only)
+// @Description Version is in force right away, users have to accept it on their next login.
+// @Accept  json,x-www-form-urlencoded,application/x-msgpack
+// @Produce json,application/x-msgpack,xml
+// @Param   policy body api.PolicyInput true "Type of policy (terms or privacy) and version"
+// @Success 201 {object} model.PolicyVersion
+// @Failure 400 {object} common.Problem
+// @Failure 403 {object} common.Problem
+// @Failure 409 {object} common.Problem
+// @Failure 422 {object} common.Problem
+// @Router  /v1/policies [post]
+func (api *API) PolicyCreateHandler(c *gin.Context) {
+	api = api.scoped(c)
+
+	if !api.requireAdmin(c) {
+		return
+	}
+
+	var in PolicyInput
+	if !bind(c, &in) {
+		return
+	}
+
+	policy, err := api.PublishPolicy(in)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	respond(c, http.StatusCreated, policy)
+}
@@ -0,0 +1,151 @@
+package api
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/lokhman/example-users-microservice/common"
+	"github.com/lokhman/example-users-microservice/model"
+	"golang.org/x/text/language"
+)
+
+// Preferences input structure, only given values are changed.
+type PreferencesInput struct {
+	Locale        *string                      `json:"locale" form:"locale" binding:"omitempty,max=35" example:"en-GB"`
+	Timezone      *string                      `json:"timezone" form:"timezone" binding:"omitempty,max=64" example:"Europe/London"`
+	Theme         *string                      `json:"theme" form:"theme" example:"dark"`
+	Notifications NotificationPreferencesInput `json:"notifications"`
+}
+
+// Notification toggles input structure, only given values are changed.
+type NotificationPreferencesInput struct {
+	Email     *bool `json:"email" form:"notifications.email" example:"true"`
+	Push      *bool `json:"push" form:"notifications.push" example:"true"`
+	SMS       *bool `json:"sms" form:"notifications.sms" example:"false"`
+	Marketing *bool `json:"marketing" form:"notifications.marketing" example:"false"`
+}
+
+// Message of "user.preferences_changed" topic.
+type PreferencesChangedMessage struct {
+	PublicID    string            `json:"public_id"`
+	Preferences model.Preferences `json:"preferences"`
+
+	// organizations as in `UserMessage`
+	OrgID  string   `json:"org_id,omitempty"`
+	OrgIDs []string `json:"org_ids"`
+}
+
+// Returns columns of the given preferences, reports problem if any value is invalid.
+// Locale is normalized to canonical BCP 47 language tag (e.g. "en-gb" becomes "en-GB").
+func (in *PreferencesInput) columns() (map[string]interface{}, error) {
+	columns := make(map[string]interface{})
+	if in.Locale != nil {
+		tag, err := language.Parse(*in.Locale)
+		if err != nil {
+			return nil, common.FieldProblem("locale", "locale", "")
+		}
+		locale := tag.String()
+		columns["locale"] = &locale
+	}
+	if in.Timezone != nil {
+		// empty name and "Local" are valid for Go, but mean UTC and the time zone of the server
+		if _, err := time.LoadLocation(*in.Timezone); err != nil || *in.Timezone == "" || *in.Timezone == "Local" {
+			return nil, common.FieldProblem("timezone", "timezone", "")
+		}
+		columns["timezone"] = in.Timezone
+	}
+	if in.Theme != nil {
+		if !model.IsTheme(*in.Theme) {
+			return nil, common.FieldProblem("theme", "oneof", strings.Join([]string{model.ThemeLight, model.ThemeDark, model.ThemeSystem}, " "))
+		}
+		columns["theme"] = in.Theme
+	}
+	for column, value := range map[string]*bool{
+		"notify_email":     in.Notifications.Email,
+		"notify_push":      in.Notifications.Push,
+		"notify_sms":       in.Notifications.SMS,
+		"notify_marketing": in.Notifications.Marketing,
+	} {
+		if value != nil {
+			columns[column] = value
+		}
+	}
+	return columns, nil
+}
+
+// Finds preferences of the user by ID, users who never changed them get the defaults.
+func (api *API) FindUserPreferences(id int) (*model.Preferences, error) {
+	if _, err := api.FindUser(id); err != nil {
+		return nil, err
+	}
+
+	var stored model.UserPreferences
+	if err := api.DB.Where("user_id = ?", id).First(&stored).Error; err != nil && !gorm.IsRecordNotFoundError(err) {
+		return nil, err
+	}
+	preferences := stored.Resolve()
+	return &preferences, nil
+}
+
+// Changes given preferences of the user by ID and publishes them to the queue if they changed.
+func (api *API) UpdateUserPreferences(id int, in PreferencesInput) (*model.Preferences, error) {
+	if err := validate(&in); err != nil {
+		return nil, err
+	}
+	columns, err := in.columns()
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := api.FindUser(id)
+	if err != nil {
+		return nil, err
+	}
+
+	// preferences are created on the first change and locked, so concurrent partial updates don't overwrite each other
+	var before, after model.Preferences
+	err = api.transaction(func(tx *gorm.DB) error {
+		err := tx.Exec("INSERT INTO user_preferences (user_id, updated_at) VALUES (?, ?) ON CONFLICT DO NOTHING",
+			user.ID, gorm.NowFunc()).Error
+		if err != nil {
+			return err
+		}
+
+		var stored model.UserPreferences
+		if err = tx.Set("gorm:query_option", "FOR UPDATE").Where("user_id = ?", user.ID).First(&stored).Error; err != nil {
+			return err
+		}
+		before = stored.Resolve()
+
+		if len(columns) > 0 {
+			if err = tx.Model(&stored).Updates(columns).Error; err != nil {
+				return err
+			}
+		}
+		after = stored.Resolve()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if after != before {
+		// try to publish message to the queue under "user.preferences_changed" topic (see `api.CreateUser` for more details)
+		orgIDs, err := userOrgIDs(api.DB, user.ID)
+		if err != nil {
+			return nil, err
+		}
+		tagged := api.userMessage(user, orgIDs[user.ID])
+		message := PreferencesChangedMessage{PublicID: user.PublicID, Preferences: after, OrgID: tagged.OrgID, OrgIDs: tagged.OrgIDs}
+		if err = common.NSQPublish(api.NSQ, TopicUserPreferencesChanged, message); err != nil {
+			return nil, err
+		}
+
+		// some meaningful logs to default logger
+		log.Printf("[users] preferences of user with ID %d were changed", user.ID)
+	}
+
+	return &after, nil
+}
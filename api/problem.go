@@ -0,0 +1,108 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lokhman/example-users-microservice/common"
+	"github.com/lokhman/example-users-microservice/i18n"
+)
+
+const localizerKey = "localizer"
+
+var (
+	internalProblem         = common.NewProblem(http.StatusInternalServerError, common.ErrCodeInternal, "Unexpected server error")
+	notFoundProblem         = common.NewProblem(http.StatusNotFound, common.ErrCodeNotFound, "Resource cannot be found")
+	methodNotAllowedProblem = common.NewProblem(http.StatusMethodNotAllowed, common.ErrCodeMethodNotAllowed, "Method is not allowed")
+)
+
+// Translates problem detail and field error messages to the language of the request.
+func localizeProblem(c *gin.Context, p common.Problem) common.Problem {
+	v, ok := c.Get(localizerKey)
+	if !ok {
+		return p
+	}
+	l := v.(*i18n.Localizer)
+
+	if detail, ok := l.Translate("error."+p.Code, p.Params()); ok {
+		p.Detail = detail
+	}
+
+	errs := make([]common.FieldError, len(p.Errors))
+	for i, fe := range p.Errors {
+		label, ok := l.Translate("field."+fe.Field, nil)
+		if !ok {
+			label = fe.Field
+		}
+
+		params := map[string]string{"field": label, "param": fe.Param}
+		if message, ok := l.Translate("validation."+fe.Rule, params); ok {
+			fe.Message = message
+		} else if message, ok := l.Translate("validation.default", params); ok {
+			fe.Message = message
+		}
+		errs[i] = fe
+	}
+	if len(errs) > 0 {
+		p.Errors = errs
+	}
+
+	c.Header("Content-Language", l.Lang)
+	return p
+}
+
+// Writes problem details to the response and stops the handlers chain.
+func abortWithProblem(c *gin.Context, p common.Problem) {
+	p = localizeProblem(c, p)
+	p.Instance = c.Request.URL.RequestURI()
+	if responseFormat(c) == mimeXML {
+		c.Header("Content-Type", common.ProblemXMLContentType)
+		c.XML(p.Status, p)
+	} else {
+		c.Header("Content-Type", common.ProblemContentType)
+		c.JSON(p.Status, p)
+	}
+	c.Abort()
+}
+
+// Reports problem to the client or panics if error is unexpected.
+func abortWithError(c *gin.Context, err error) {
+	if p, ok := err.(common.Problem); ok {
+		abortWithProblem(c, p)
+		return
+	}
+	panic(err)
+}
+
+// Resolves language of the request by `Accept-Language` header for localized messages.
+func (api *API) LocaleMiddleware(c *gin.Context) {
+	lang := api.Catalog.Match(c.GetHeader("Accept-Language"))
+	c.Set(localizerKey, api.Catalog.Localizer(lang))
+	c.Next()
+}
+
+// Recovers from panics in handlers and responds with problem details.
+// Should be registered before other middlewares to let them clean up on panic.
+func (api *API) RecoveryMiddleware(c *gin.Context) {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Printf("[recovery] panic recovered: %v\n%s", err, debug.Stack())
+			if !c.Writer.Written() {
+				abortWithProblem(c, internalProblem)
+			}
+		}
+	}()
+	c.Next()
+}
+
+// Responds with problem details to requests that do not match any route.
+func (api *API) NotFoundHandler(c *gin.Context) {
+	abortWithProblem(c, notFoundProblem)
+}
+
+// Responds with problem details to requests with unsupported method.
+func (api *API) MethodNotAllowedHandler(c *gin.Context) {
+	abortWithProblem(c, methodNotAllowedProblem)
+}
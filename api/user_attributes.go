@@ -0,0 +1,70 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lokhman/example-users-microservice/model"
+)
+
+// Sets or deletes (if value is not given) custom attributes of the user from path parameters, reports problem if it fails.
+func (api *API) setUserAttributes(c *gin.Context, del bool) (*model.User, bool) {
+	id, err := api.userIDFromParam(c)
+	if err != nil {
+		abortWithError(c, err)
+		return nil, false
+	}
+
+	var value interface{}
+	if !del {
+		data, ok := readJSON(c)
+		if !ok {
+			return nil, false
+		}
+		_ = json.Unmarshal(data, &value)
+	}
+
+	user, err := api.SetUserAttributes(api.actor(c), id, c.Param("namespace"), value)
+	if err != nil {
+		abortWithError(c, err)
+		return nil, false
+	}
+	return user, true
+}
+
+// @Summary Set custom attributes of user by ID and namespace
+// @Description Request body is any JSON value, which must match the schema of the namespace (null deletes attributes).
+// @Accept  json
+// @Produce json,application/x-msgpack,xml
+// @Param   id path string true "User public ID" format(uuid)
+// @Param   namespace path string true "Attributes namespace"
+// @Param   attributes body object true "Attributes value"
+// @Success 200 {object} model.User
+// @Failure 400 {object} common.Problem
+// @Failure 404 {object} common.Problem
+// @Failure 422 {object} common.Problem
+// @Router  /v1/users/{id}/attributes/{namespace} [put]
+func (api *API) UserAttributesHandler(c *gin.Context) {
+	api = api.scoped(c)
+
+	if user, ok := api.setUserAttributes(c, false); ok {
+		respond(c, http.StatusOK, user)
+	}
+}
+
+// @Summary Delete custom attributes of user by ID and namespace
+// @Accept  json
+// @Produce json,application/x-msgpack,xml
+// @Param   id path string true "User public ID" format(uuid)
+// @Param   namespace path string true "Attributes namespace"
+// @Success 204 ""
+// @Failure 404 {object} common.Problem
+// @Router  /v1/users/{id}/attributes/{namespace} [delete]
+func (api *API) UserAttributesDeleteHandler(c *gin.Context) {
+	api = api.scoped(c)
+
+	if _, ok := api.setUserAttributes(c, true); ok {
+		respond(c, http.StatusNoContent, nil)
+	}
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lokhman/example-users-microservice/common"
+	"github.com/lokhman/example-users-microservice/model"
+)
+
+const (
+	// multipart form field of uploaded avatar
+	avatarFormField = "avatar"
+
+	// room for multipart boundaries and headers on top of the avatar size limit
+	multipartOverhead = 64 << 10
+)
+
+// Reports problem of avatar upload that failed to read.
+// `http.MaxBytesReader` error has no distinct type, so it is recognized by the message.
+func abortWithUploadError(c *gin.Context, err error, limit int64) {
+	if strings.Contains(err.Error(), "request body too large") {
+		abortWithProblem(c, avatarTooLargeProblem(limit))
+		return
+	}
+	abortWithProblem(c, common.NewProblem(http.StatusBadRequest, common.ErrCodeInvalidRequest, err.Error()))
+}
+
+// Reads avatar from multipart form or raw request body (with image content type), reports problem if it fails.
+func (api *API) readAvatar(c *gin.Context) ([]byte, bool) {
+	limit := api.AvatarMaxSize
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit+multipartOverhead)
+
+	var r io.Reader
+	switch ct := c.ContentType(); {
+	case ct == mimeMultipart:
+		file, _, err := c.Request.FormFile(avatarFormField)
+		if err != nil {
+			abortWithUploadError(c, err, limit)
+			return nil, false
+		}
+		defer func() { _ = file.Close() }()
+		r = file
+	case strings.HasPrefix(ct, "image/"):
+		r = c.Request.Body
+	default:
+		abortWithProblem(c, unsupportedMediaTypeProblem)
+		return nil, false
+	}
+
+	// one byte over the limit is enough to reject the avatar
+	data, err := ioutil.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		abortWithUploadError(c, err, limit)
+		return nil, false
+	}
+	return data, true
+}
+
+// Replaces avatar of the user from path parameter, reports problem if it fails.
+func (api *API) setUserAvatar(c *gin.Context) (*model.User, bool) {
+	id, err := api.userIDFromParam(c)
+	if err != nil {
+		abortWithError(c, err)
+		return nil, false
+	}
+
+	data, ok := api.readAvatar(c)
+	if !ok {
+		return nil, false
+	}
+
+	user, err := api.SetUserAvatar(api.actor(c), id, data)
+	if err != nil {
+		abortWithError(c, err)
+		return nil, false
+	}
+	return user, true
+}
+
+// @Summary Upload user avatar by ID
+// @Description Accepts JPEG, PNG or WebP image as raw request body or `avatar` field of multipart form.
+// @Description Image is re-encoded without metadata and square thumbnails are generated.
+// @Accept  image/jpeg,image/png,image/webp,multipart/form-data
+// @Produce json,application/x-msgpack,xml
+// @Param   id path string true "User public ID" format(uuid)
+// @Param   avatar formData file false "Avatar image"
+// @Success 200 {object} model.User
+// @Failure 400 {object} common.Problem
+// @Failure 404 {object} common.Problem
+// @Failure 413 {object} common.Problem
+// @Failure 415 {object} common.Problem
+// @Failure 422 {object} common.Problem
+// @Router  /v1/users/{id}/avatar [put]
+func (api *API) UserAvatarHandler(c *gin.Context) {
+	api = api.scoped(c)
+
+	if user, ok := api.setUserAvatar(c); ok {
+		respond(c, http.StatusOK, user)
+	}
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Lists consents of the user from path parameter, reports problem if it fails.
+func (api *API) listUserConsents(c *gin.Context) ([]UserConsent, bool) {
+	id, err := api.userIDFromParam(c)
+	if err != nil {
+		abortWithError(c, err)
+		return nil, false
+	}
+
+	consents, err := api.ListUserConsents(id)
+	if err != nil {
+		abortWithError(c, err)
+		return nil, false
+	}
+	return consents, true
+}
+
+// Records consent of the user from path parameter, reports problem if it fails.
+func (api *API) giveUserConsent(c *gin.Context) ([]UserConsent, bool) {
+	id, err := api.userIDFromParam(c)
+	if err != nil {
+		abortWithError(c, err)
+		return nil, false
+	}
+
+	var in ConsentInput
+	if !bind(c, &in) {
+		return nil, false
+	}
+
+	consents, err := api.GiveUserConsent(api.actor(c), id, in)
+	if err != nil {
+		abortWithError(c, err)
+		return nil, false
+	}
+	return consents, true
+}
+
+// @Summary List consents of user by ID
+// @Description Policies in force that user has not accepted are `required`.
+// @Accept  json
+// @Produce json,application/x-msgpack,xml
+// @Param   id path string true "User public ID" format(uuid)
+// @Success 200 {array} api.UserConsent
+// @Failure 404 {object} common.Problem
+// @Router  /v1/users/{id}/consents [get]
+func (api *API) UserConsentIndexHandler(c *gin.Context) {
+	api = api.scoped(c)
+
+	if consents, ok := api.listUserConsents(c); ok {
+		respond(c, http.StatusOK, consents)
+	}
+}
+
+// @Summary Give or withdraw consent of user by ID
+// @Description Policies are accepted in the version in force, marketing consents may be withdrawn with `granted` false.
+// @Accept  json,x-www-form-urlencoded,application/x-msgpack
+// @Produce json,application/x-msgpack,xml
+// @Param   id path string true "User public ID" format(uuid)
+// @Param   consent body api.ConsentInput true "Type of consent, version of policy"
+// @Success 200 {array} api.UserConsent
+// @Failure 400 {object} common.Problem
+// @Failure 404 {object} common.Problem
+// @Failure 422 {object} common.Problem
+// @Router  /v1/users/{id}/consents [post]
+func (api *API) UserConsentCreateHandler(c *gin.Context) {
+	api = api.scoped(c)
+
+	if consents, ok := api.giveUserConsent(c); ok {
+		respond(c, http.StatusOK, consents)
+	}
+}
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Requests data export of the user from path parameter (administrators only), reports problem if it fails.
+func (api *API) userDataExport(c *gin.Context) (*DataExport, bool) {
+	if !api.requireAdmin(c) {
+		return nil, false
+	}
+
+	id, err := api.userIDFromParam(c)
+	if err != nil {
+		abortWithError(c, err)
+		return nil, false
+	}
+
+	export, err := api.RequestDataExport(api.actor(c), id)
+	if err != nil {
+		abortWithError(c, err)
+		return nil, false
+	}
+	return export, true
+}
+
+// @Summary Request export of all data of user by ID (administrators only)
+// @Description Archive is assembled in background, status of the export is available by URL from `Location` header.
+// @Accept  json
+// @Produce json,application/x-msgpack,xml
+// @Param   id path string true "User public ID" format(uuid)
+// @Success 202 {object} api.DataExport
+// @Failure 403 {object} common.Problem
+// @Failure 404 {object} common.Problem
+// @Router  /v1/users/{id}/data-export [post]
+func (api *API) UserDataExportHandler(c *gin.Context) {
+	api = api.scoped(c)
+
+	if export, ok := api.userDataExport(c); ok {
+		c.Header("Location", "/v1/exports/"+export.ID)
+		respond(c, http.StatusAccepted, export)
+	}
+}
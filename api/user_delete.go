@@ -1,56 +1,63 @@
 package api
 
 import (
-	"log"
 	"net/http"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
-	"github.com/jinzhu/gorm"
 	"github.com/lokhman/example-users-microservice/common"
-	"github.com/lokhman/example-users-microservice/model"
 )
 
-// @Summary Delete user by ID
-// @Accept  json
-// @Produce json
-// @Param   id path int true "User ID" mininum(1)
-// @Success 204 ""
-// @Failure 404 {object} common.HTTPError
-// @Router  /users/{id} [delete]
-func (api *API) UserDeleteHandler(c *gin.Context) {
-	id, err := strconv.Atoi(c.Param("id"))
+// Soft deletes user or purges it if requested by administrator, reports problem if it fails.
+func (api *API) deleteOrPurgeUser(c *gin.Context, legacy bool) bool {
+	var id int
+	var err error
+	if legacy {
+		id, err = api.legacyUserIDFromParam(c)
+	} else {
+		id, err = api.userIDFromParam(c)
+	}
 	if err != nil {
-		c.JSON(http.StatusNotFound, invalidUserIDError)
-		return
+		// DELETE request is idempotent, so we show that request was successful even if user doesn't exist
+		if common.IsProblem(err, common.ErrCodeUserNotFound) {
+			return true
+		}
+		abortWithError(c, err)
+		return false
 	}
 
-	var user model.User
-	if err = api.DB.First(&user, id).Error; err != nil {
-		if gorm.IsRecordNotFoundError(err) {
-			// DELETE request is idempotent, so we may show that request was successful
-			c.JSON(http.StatusNoContent, nil)
-			return
+	if purge, _ := strconv.ParseBool(c.Query("purge")); purge {
+		if !api.requireAdmin(c) {
+			return false
 		}
-		panic(err)
+		err = api.PurgeUser(api.actor(c), id)
+	} else {
+		err = api.DeleteUser(api.actor(c), id)
 	}
 
-	// try to delete user entity from the database
-	if err = api.DB.Delete(&user).Error; err != nil {
-		panic(err)
+	if err != nil {
+		abortWithError(c, err)
+		return false
 	}
+	return true
+}
 
-	// try to publish message to the queue under "user.delete" topic
-	if err := common.NSQPublish(api.NSQ, "user.delete", user); err != nil {
-		// correct behaviour should be defined by requirements and may include:
-		// - rollback transaction above and panic
-		// - log error with data and continue
-		// - log error and continue
-		// - panic! (yes, I'm cutting corners...)
-		panic(err)
+// @Summary Delete user by ID
+// @Accept  json
+// @Produce json,application/x-msgpack,xml
+// @Param   id path string true "User public ID (or integer ID, deprecated until INTEGER_ID_SUNSET)"
+// @Param   purge query bool false "Delete permanently (administrators only)"
+// @Success 204 ""
+// @Failure 403 {object} common.Problem
+// @Failure 404 {object} common.Problem
+// @Router  /v1/users/{id} [delete]
+func (api *API) UserDeleteHandler(c *gin.Context) {
+	api = api.scoped(c)
+
+	// DELETE request is idempotent, so we show that request was successful even if user doesn't exist
+	if !api.deleteOrPurgeUser(c, true) {
+		return
 	}
-	// some meaningful logs to default logger
-	log.Printf("[users] user with ID %d was deleted", user.ID)
 
-	c.JSON(http.StatusNoContent, nil)
+	respond(c, http.StatusNoContent, nil)
 }
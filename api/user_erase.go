@@ -0,0 +1,43 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Erases personal data of the user from path parameter (administrators only), reports problem if it fails.
+func (api *API) eraseUser(c *gin.Context) bool {
+	if !api.requireAdmin(c) {
+		return false
+	}
+
+	id, err := api.userIDFromParam(c)
+	if err != nil {
+		abortWithError(c, err)
+		return false
+	}
+
+	if err = api.EraseUser(api.actor(c), id); err != nil {
+		abortWithError(c, err)
+		return false
+	}
+	return true
+}
+
+// @Summary Erase personal data of user by ID irreversibly (administrators only)
+// @Description User is deleted and anonymized, related data is purged. Unlike purged, erased user is kept for the audit log.
+// @Accept  json
+// @Produce json,application/x-msgpack,xml
+// @Param   id path string true "User public ID" format(uuid)
+// @Success 204 ""
+// @Failure 403 {object} common.Problem
+// @Failure 404 {object} common.Problem
+// @Router  /v1/users/{id}/erase [post]
+func (api *API) UserEraseHandler(c *gin.Context) {
+	api = api.scoped(c)
+
+	if api.eraseUser(c) {
+		respond(c, http.StatusNoContent, nil)
+	}
+}
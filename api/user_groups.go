@@ -0,0 +1,39 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Lists groups of the user from path parameter, reports problem if it fails.
+func (api *API) userGroups(c *gin.Context) ([]UserGroup, bool) {
+	id, err := api.userIDFromParam(c)
+	if err != nil {
+		abortWithError(c, err)
+		return nil, false
+	}
+
+	groups, err := api.ListUserGroups(id)
+	if err != nil {
+		abortWithError(c, err)
+		return nil, false
+	}
+	return groups, true
+}
+
+// @Summary List groups of user by ID
+// @Description Groups include parents of the groups user is member of, direct membership is flagged.
+// @Accept  json
+// @Produce json,application/x-msgpack,xml
+// @Param   id path string true "User public ID" format(uuid)
+// @Success 200 {array} api.UserGroup
+// @Failure 404 {object} common.Problem
+// @Router  /v1/users/{id}/groups [get]
+func (api *API) UserGroupsHandler(c *gin.Context) {
+	api = api.scoped(c)
+
+	if groups, ok := api.userGroups(c); ok {
+		respond(c, http.StatusOK, groups)
+	}
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lokhman/example-users-microservice/model"
+)
+
+// Page size of user history.
+const (
+	userHistoryDefaultLimit = 50
+	userHistoryMaxLimit     = 100
+)
+
+// Reads page of the history of the user from path parameter by administrator, reports problem if it fails.
+// Link to the next page (if any) is returned in `Link` header.
+func (api *API) userHistory(c *gin.Context) ([]model.UserAudit, bool) {
+	if !api.requireAdmin(c) {
+		return nil, false
+	}
+
+	id, err := api.userIDFromParam(c)
+	if err != nil {
+		abortWithError(c, err)
+		return nil, false
+	}
+
+	limit := userHistoryDefaultLimit
+	if value := c.Query("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 || limit > userHistoryMaxLimit {
+			abortWithProblem(c, invalidQueryProblem("limit"))
+			return nil, false
+		}
+	}
+
+	var beforeID int
+	if value := c.Query("before"); value != "" {
+		if beforeID, err = strconv.Atoi(value); err != nil || beforeID < 1 {
+			abortWithProblem(c, invalidQueryProblem("before"))
+			return nil, false
+		}
+	}
+
+	// one extra entry tells if there is the next page
+	entries, err := api.ListUserHistory(id, beforeID, limit+1)
+	if err != nil {
+		abortWithError(c, err)
+		return nil, false
+	}
+	if len(entries) > limit {
+		entries = entries[:limit]
+		c.Header("Link", fmt.Sprintf(`<%s?limit=%d&before=%d>; rel="next"`, c.Request.URL.Path, limit, entries[limit-1].ID))
+	}
+	return entries, true
+}
+
+// @Summary List changes of user by ID from the newest (administrators only)
+// @Accept  json
+// @Produce json,application/x-msgpack,xml
+// @Param   id path string true "User public ID" format(uuid)
+// @Param   limit query int false "Page size" minimum(1) maximum(100) default(50)
+// @Param   before query int false "ID of the last entry of the previous page"
+// @Success 200 {array} model.UserAudit
+// @Failure 400 {object} common.Problem
+// @Failure 403 {object} common.Problem
+// @Failure 404 {object} common.Problem
+// @Router  /v1/users/{id}/history [get]
+func (api *API) UserHistoryHandler(c *gin.Context) {
+	api = api.scoped(c)
+
+	if entries, ok := api.userHistory(c); ok {
+		respond(c, http.StatusOK, entries)
+	}
+}
+
+// @Summary List changes of user by ID from the newest (administrators only)
+// @Accept  json
+// @Produce json,application/x-msgpack,xml
+// @Param   id path string true "User public ID" format(uuid)
+// @Param   limit query int false "Page size" minimum(1) maximum(100) default(50)
+// @Param   before query int false "ID of the last entry of the previous page"
+// @Success 200 {array} model.UserAudit
+// @Failure 400 {object} common.Problem
+// @Failure 403 {object} common.Problem
+// @Failure 404 {object} common.Problem
+// @Router  /v2/users/{id}/history [get]
+func (api *API) UserHistoryV2Handler(c *gin.Context) {
+	api = api.scoped(c)
+
+	if entries, ok := api.userHistory(c); ok {
+		respond(c, http.StatusOK, entries)
+	}
+}
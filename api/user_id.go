@@ -0,0 +1,29 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Reads user public ID from "id" path parameter and resolves it.
+func (api *API) userIDFromParam(c *gin.Context) (int, error) {
+	return api.ResolveUserID(c.Param("id"))
+}
+
+// Reads user ID from "id" path parameter of v1 routes that existed before public IDs (view, update, delete and restore).
+// As a temporary shim these also accept integer IDs until `IntegerIDSunset` (the shim is off if it is not set),
+// responses to such requests are marked with `Deprecation` and `Sunset` (RFC 8594) headers.
+func (api *API) legacyUserIDFromParam(c *gin.Context) (int, error) {
+	param := c.Param("id")
+	if id, err := strconv.Atoi(param); err == nil && time.Now().Before(api.IntegerIDSunset) {
+		log.Printf("[users] deprecated integer user ID is used in %s %s", c.Request.Method, c.Request.URL.Path)
+		c.Header("Deprecation", "true")
+		c.Header("Sunset", api.IntegerIDSunset.UTC().Format(http.TimeFormat))
+		return id, nil
+	}
+	return api.ResolveUserID(param)
+}
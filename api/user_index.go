@@ -2,30 +2,102 @@ package api
 
 import (
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/lokhman/example-users-microservice/model"
 )
 
+// Reads users list filter from query parameters, reports problem if it's not allowed.
+func (api *API) userFilterFromQuery(c *gin.Context) (UserFilter, bool) {
+	filter := UserFilter{Country: c.Query("country"), Status: c.Query("status"), Sort: c.Query("sort")}
+
+	// time ranges are given in RFC 3339 format
+	for param, t := range map[string]*time.Time{
+		"created_after":     &filter.CreatedAfter,
+		"created_before":    &filter.CreatedBefore,
+		"updated_after":     &filter.UpdatedAfter,
+		"updated_before":    &filter.UpdatedBefore,
+		"last_login_after":  &filter.LastLoginAfter,
+		"last_login_before": &filter.LastLoginBefore,
+	} {
+		if value := c.Query(param); value != "" {
+			var err error
+			if *t, err = time.Parse(time.RFC3339, value); err != nil {
+				abortWithProblem(c, invalidQueryProblem(param))
+				return filter, false
+			}
+		}
+	}
+
+	// custom attributes are given as `attr.<namespace>.<key>=<value>`
+	for param, values := range c.Request.URL.Query() {
+		if !strings.HasPrefix(param, attributeQueryPrefix) {
+			continue
+		}
+		if filter.Attributes == nil {
+			filter.Attributes = make(map[string]interface{})
+		}
+		if err := addAttributeFilter(filter.Attributes, param, values[0]); err != nil {
+			abortWithError(c, err)
+			return filter, false
+		}
+	}
+
+	// organization is given by public ID
+	if publicID := c.Query("organization"); publicID != "" {
+		org, err := api.FindOrganization(publicID)
+		if err != nil {
+			abortWithError(c, err)
+			return filter, false
+		}
+		filter.OrganizationID = org.ID
+	}
+
+	// only administrators may see soft deleted users
+	if includeDeleted, _ := strconv.ParseBool(c.Query("include_deleted")); includeDeleted {
+		if !api.requireAdmin(c) {
+			return filter, false
+		}
+		filter.IncludeDeleted = true
+	}
+	return filter, true
+}
+
 // @Summary List users
 // @Accept  json
-// @Produce json
+// @Produce json,application/x-msgpack,xml
 // @Param   country query string false "User country" minlength(2) maxlength(2)
+// @Param   status query string false "User status" Enums(pending, active, suspended, banned)
+// @Param   organization query string false "Organization public ID" format(uuid)
+// @Param   include_deleted query bool false "Include soft deleted users (administrators only)"
+// @Param   created_after query string false "Users created at or after the time" format(date-time)
+// @Param   created_before query string false "Users created before the time" format(date-time)
+// @Param   updated_after query string false "Users updated at or after the time" format(date-time)
+// @Param   updated_before query string false "Users updated before the time" format(date-time)
+// @Param   last_login_after query string false "Users logged in at or after the time" format(date-time)
+// @Param   last_login_before query string false "Users logged in before the time" format(date-time)
+// @Param   sort query string false "Sort column, prefixed with minus for descending order" Enums(id, -id, created_at, -created_at, updated_at, -updated_at, last_login_at, -last_login_at)
+// @Param   attr.{namespace}.{key} query string false "Custom attribute value (parsed as JSON if possible)"
 // @Success 200 {array} model.User
-// @Router  /users [get]
+// @Failure 400 {object} common.Problem
+// @Failure 403 {object} common.Problem
+// @Failure 404 {object} common.Problem
+// @Router  /v1/users [get]
 func (api *API) UserIndexHandler(c *gin.Context) {
-	var users []model.User
+	api = api.scoped(c)
 
-	db := api.DB
-	if country, ok := c.GetQuery("country"); ok {
-		db = db.Where(&model.User{Country: country})
+	filter, ok := api.userFilterFromQuery(c)
+	if !ok {
+		return
 	}
 
-	// PostgreSQL doesn't have default order by primary key
-	// (entities in the list do not "shuffle" when we update one)
-	if err := db.Order("id").Find(&users).Error; err != nil {
-		panic(err)
+	users, err := api.ListUsers(filter)
+	if err != nil {
+		abortWithError(c, err)
+		return
 	}
 
-	c.JSON(http.StatusOK, users)
+	respond(c, http.StatusOK, users)
 }
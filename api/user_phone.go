@@ -0,0 +1,80 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lokhman/example-users-microservice/model"
+)
+
+// Sends verification code to the phone of the user from path parameter, reports problem if it fails.
+func (api *API) sendPhoneVerification(c *gin.Context) bool {
+	id, err := api.userIDFromParam(c)
+	if err != nil {
+		abortWithError(c, err)
+		return false
+	}
+
+	if err = api.SendPhoneVerification(id); err != nil {
+		abortWithError(c, err)
+		return false
+	}
+	return true
+}
+
+// Verifies phone of the user from path parameter by the code, reports problem if it fails.
+func (api *API) verifyUserPhone(c *gin.Context) (*model.User, bool) {
+	id, err := api.userIDFromParam(c)
+	if err != nil {
+		abortWithError(c, err)
+		return nil, false
+	}
+
+	var in PhoneVerificationInput
+	if !bind(c, &in) {
+		return nil, false
+	}
+
+	user, err := api.VerifyUserPhone(api.actor(c), id, in.Code)
+	if err != nil {
+		abortWithError(c, err)
+		return nil, false
+	}
+	return user, true
+}
+
+// @Summary Send verification code to user phone by ID
+// @Description Code is sent by SMS and valid for 10 minutes, the next code can be sent in a minute.
+// @Accept  json
+// @Produce json,application/x-msgpack,xml
+// @Param   id path string true "User public ID" format(uuid)
+// @Success 204 ""
+// @Failure 404 {object} common.Problem
+// @Failure 409 {object} common.Problem
+// @Failure 429 {object} common.Problem
+// @Router  /v1/users/{id}/phone/verification [post]
+func (api *API) UserPhoneVerificationHandler(c *gin.Context) {
+	api = api.scoped(c)
+
+	if api.sendPhoneVerification(c) {
+		respond(c, http.StatusNoContent, nil)
+	}
+}
+
+// @Summary Verify user phone by ID with the code sent by SMS
+// @Accept  json,x-www-form-urlencoded,application/x-msgpack
+// @Produce json,application/x-msgpack,xml
+// @Param   id path string true "User public ID" format(uuid)
+// @Param   verification body api.PhoneVerificationInput true "Verification code"
+// @Success 200 {object} model.User
+// @Failure 400 {object} common.Problem
+// @Failure 404 {object} common.Problem
+// @Failure 422 {object} common.Problem
+// @Router  /v1/users/{id}/phone/verification/confirm [post]
+func (api *API) UserPhoneVerifyHandler(c *gin.Context) {
+	api = api.scoped(c)
+
+	if user, ok := api.verifyUserPhone(c); ok {
+		respond(c, http.StatusOK, user)
+	}
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lokhman/example-users-microservice/model"
+)
+
+// Finds preferences of the user from path parameter, reports problem if it fails.
+func (api *API) userPreferences(c *gin.Context) (*model.Preferences, bool) {
+	id, err := api.userIDFromParam(c)
+	if err != nil {
+		abortWithError(c, err)
+		return nil, false
+	}
+
+	preferences, err := api.FindUserPreferences(id)
+	if err != nil {
+		abortWithError(c, err)
+		return nil, false
+	}
+	return preferences, true
+}
+
+// Changes preferences of the user from path parameter, reports problem if it fails.
+func (api *API) updateUserPreferences(c *gin.Context) (*model.Preferences, bool) {
+	id, err := api.userIDFromParam(c)
+	if err != nil {
+		abortWithError(c, err)
+		return nil, false
+	}
+
+	var in PreferencesInput
+	if !bind(c, &in) {
+		return nil, false
+	}
+
+	preferences, err := api.UpdateUserPreferences(id, in)
+	if err != nil {
+		abortWithError(c, err)
+		return nil, false
+	}
+	return preferences, true
+}
+
+// @Summary View user preferences by ID
+// @Description Preferences that user never changed have default values.
+// @Accept  json
+// @Produce json,application/x-msgpack,xml
+// @Param   id path string true "User public ID" format(uuid)
+// @Success 200 {object} model.Preferences
+// @Failure 404 {object} common.Problem
+// @Router  /v1/users/{id}/preferences [get]
+func (api *API) UserPreferencesHandler(c *gin.Context) {
+	api = api.scoped(c)
+
+	if preferences, ok := api.userPreferences(c); ok {
+		respond(c, http.StatusOK, preferences)
+	}
+}
+
+// @Summary Update user preferences by ID
+// @Description Only given preferences are changed, the others are left as they are.
+// @Accept  json,x-www-form-urlencoded,application/x-msgpack
+// @Produce json,application/x-msgpack,xml
+// @Param   id path string true "User public ID" format(uuid)
+// @Param   preferences body api.PreferencesInput true "Changed preferences"
+// @Success 200 {object} model.Preferences
+// @Failure 400 {object} common.Problem
+// @Failure 404 {object} common.Problem
+// @Failure 422 {object} common.Problem
+// @Router  /v1/users/{id}/preferences [put]
+func (api *API) UserPreferencesUpdateHandler(c *gin.Context) {
+	api = api.scoped(c)
+
+	if preferences, ok := api.updateUserPreferences(c); ok {
+		respond(c, http.StatusOK, preferences)
+	}
+}
@@ -0,0 +1,34 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// @Summary Restore soft deleted user by ID
+// @Accept  json
+// @Produce json,application/x-msgpack,xml
+// @Param   id path string true "User public ID (or integer ID, deprecated until INTEGER_ID_SUNSET)"
+// @Success 200 {object} model.User
+// @Failure 404 {object} common.Problem
+// @Failure 409 {object} common.Problem
+// @Failure 422 {object} common.Problem
+// @Router  /v1/users/{id}/restore [post]
+func (api *API) UserRestoreHandler(c *gin.Context) {
+	api = api.scoped(c)
+
+	id, err := api.legacyUserIDFromParam(c)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	user, err := api.RestoreUser(api.actor(c), id)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	respond(c, http.StatusOK, user)
+}
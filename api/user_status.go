@@ -0,0 +1,98 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lokhman/example-users-microservice/model"
+)
+
+// User status change input structure.
+type UserStatusInput struct {
+	Reason    string     `json:"reason" form:"reason" binding:"max=255" example:"Spam"`
+	ExpiresAt *time.Time `json:"expires_at" form:"expires_at" example:"2019-01-01T00:00:00Z"`
+}
+
+// Changes status of the user from path parameter by administrator, reports problem if it fails.
+func (api *API) changeUserStatus(c *gin.Context, status string) (*model.User, bool) {
+	if !api.requireAdmin(c) {
+		return nil, false
+	}
+
+	id, err := api.userIDFromParam(c)
+	if err != nil {
+		abortWithError(c, err)
+		return nil, false
+	}
+
+	// status change doesn't require request body
+	var in UserStatusInput
+	if c.Request.ContentLength != 0 && !bind(c, &in) {
+		return nil, false
+	}
+
+	user, err := api.ChangeUserStatus(api.actor(c), id, status, in)
+	if err != nil {
+		abortWithError(c, err)
+		return nil, false
+	}
+	return user, true
+}
+
+// @Summary Suspend user by ID (administrators only)
+// @Accept  json,x-www-form-urlencoded,application/x-msgpack
+// @Produce json,application/x-msgpack,xml
+// @Param   id path string true "User public ID" format(uuid)
+// @Param   status body api.UserStatusInput true "Reason (required) and optional expiry time"
+// @Success 200 {object} model.User
+// @Failure 403 {object} common.Problem
+// @Failure 404 {object} common.Problem
+// @Failure 409 {object} common.Problem
+// @Failure 422 {object} common.Problem
+// @Router  /v1/users/{id}/suspend [post]
+func (api *API) UserSuspendHandler(c *gin.Context) {
+	api = api.scoped(c)
+
+	if user, ok := api.changeUserStatus(c, model.UserStatusSuspended); ok {
+		respond(c, http.StatusOK, user)
+	}
+}
+
+// @Summary Ban user by ID (administrators only)
+// @Accept  json,x-www-form-urlencoded,application/x-msgpack
+// @Produce json,application/x-msgpack,xml
+// @Param   id path string true "User public ID" format(uuid)
+// @Param   status body api.UserStatusInput true "Reason (required) and optional expiry time"
+// @Success 200 {object} model.User
+// @Failure 403 {object} common.Problem
+// @Failure 404 {object} common.Problem
+// @Failure 409 {object} common.Problem
+// @Failure 422 {object} common.Problem
+// @Router  /v1/users/{id}/ban [post]
+func (api *API) UserBanHandler(c *gin.Context) {
+	api = api.scoped(c)
+
+	if user, ok := api.changeUserStatus(c, model.UserStatusBanned); ok {
+		respond(c, http.StatusOK, user)
+	}
+}
+
+// @Summary Activate user by ID (administrators only)
+// @Accept  json,x-www-form-urlencoded,application/x-msgpack
+// @Produce json,application/x-msgpack,xml
+// @Param   id path string true "User public ID" format(uuid)
+// @Param   status body api.UserStatusInput false "Optional reason"
+// @Success 200 {object} model.User
+// @Failure 403 {object} common.Problem
+// @Failure 404 {object} common.Problem
+// @Failure 409 {object} common.Problem
+// @Failure 422 {object} common.Problem
+// @Router  /v1/users/{id}/activate [post]
+func (api *API) UserActivateHandler(c *gin.Context) {
+	api = api.scoped(c)
+
+	if user, ok := api.changeUserStatus(c, model.UserStatusActive); ok {
+		respond(c, http.StatusOK, user)
+	}
+}
@@ -2,41 +2,31 @@ package api
 
 import (
 	"net/http"
-	"strconv"
 
 	"github.com/gin-gonic/gin"
-	"github.com/jinzhu/gorm"
-	"github.com/lokhman/example-users-microservice/common"
-	"github.com/lokhman/example-users-microservice/model"
-)
-
-var (
-	invalidUserIDError = common.HTTPError{Err: "Invalid user ID"}
-	userNotFoundError  = common.HTTPError{Err: "User cannot be found"}
 )
 
 // @Summary View user details
 // @Accept  json
-// @Produce json
-// @Param   id path int true "User ID" mininum(1)
+// @Produce json,application/x-msgpack,xml
+// @Param   id path string true "User public ID (or integer ID, deprecated until INTEGER_ID_SUNSET)"
 // @Success 200 {object} model.User
-// @Failure 404 {object} common.HTTPError
-// @Router  /users/{id} [get]
+// @Failure 404 {object} common.Problem
+// @Router  /v1/users/{id} [get]
 func (api *API) UserViewHandler(c *gin.Context) {
-	id, err := strconv.Atoi(c.Param("id"))
+	api = api.scoped(c)
+
+	id, err := api.legacyUserIDFromParam(c)
 	if err != nil {
-		c.JSON(http.StatusNotFound, invalidUserIDError)
+		abortWithError(c, err)
 		return
 	}
 
-	var user model.User
-	if err = api.DB.First(&user, id).Error; err != nil {
-		if gorm.IsRecordNotFoundError(err) {
-			c.JSON(http.StatusNotFound, userNotFoundError)
-			return
-		}
-		panic(err)
+	user, err := api.FindUser(id)
+	if err != nil {
+		abortWithError(c, err)
+		return
 	}
 
-	c.JSON(http.StatusOK, user)
+	respond(c, http.StatusOK, user)
 }
@@ -0,0 +1,966 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/jinzhu/gorm"
+	"github.com/lokhman/example-users-microservice/common"
+	"github.com/lokhman/example-users-microservice/model"
+	"github.com/nsqio/go-nsq"
+)
+
+// NSQ topics of user changes.
+const (
+	TopicUserCreate  = "user.create"
+	TopicUserUpdate  = "user.update"
+	TopicUserDelete  = "user.delete"
+	TopicUserRestore = "user.restore"
+	TopicUserPurge   = "user.purge"
+	TopicUserErased  = "user.erased"
+
+	TopicUserStatusChanged      = "user.status_changed"
+	TopicUserPreferencesChanged = "user.preferences_changed"
+)
+
+var (
+	invalidUserIDProblem = common.NewProblem(http.StatusNotFound, common.ErrCodeInvalidUserID, "Invalid user ID")
+	userNotFoundProblem  = common.NewProblem(http.StatusNotFound, common.ErrCodeUserNotFound, "User cannot be found")
+	userErasedProblem    = common.NewProblem(http.StatusConflict, common.ErrCodeUserErased, "User is erased and cannot be restored")
+)
+
+// Reports that user with email already exists.
+func emailExistsProblem(email string) common.Problem {
+	return common.NewProblem(http.StatusUnprocessableEntity, common.ErrCodeEmailExists,
+		fmt.Sprintf(`User with email "%s" exists`, email)).WithParam("email", email)
+}
+
+// Reports that user status cannot be changed.
+func statusTransitionProblem(from, to string) common.Problem {
+	return common.NewProblem(http.StatusConflict, common.ErrCodeStatusTransition,
+		fmt.Sprintf(`User status cannot be changed from "%s" to "%s"`, from, to)).WithParam("from", from).WithParam("to", to)
+}
+
+// Problems of users who are not allowed to log in by status.
+var userStatusProblems = map[string]common.Problem{
+	model.UserStatusPending:   common.NewProblem(http.StatusForbidden, common.ErrCodeUserPending, "User is not activated yet"),
+	model.UserStatusSuspended: common.NewProblem(http.StatusForbidden, common.ErrCodeUserSuspended, "User is suspended"),
+	model.UserStatusBanned:    common.NewProblem(http.StatusForbidden, common.ErrCodeUserBanned, "User is banned"),
+}
+
+// Reports that query parameter is invalid.
+func invalidQueryProblem(param string) common.Problem {
+	return common.NewProblem(http.StatusBadRequest, common.ErrCodeInvalidQuery,
+		fmt.Sprintf(`Query parameter "%s" is invalid`, param)).WithParam("param", param)
+}
+
+// Columns users list may be sorted by.
+var userSortColumns = map[string]string{
+	"id":            "id",
+	"created_at":    "created_at",
+	"updated_at":    "updated_at",
+	"last_login_at": "last_login_at",
+}
+
+// Filter of users list.
+type UserFilter struct {
+	Country string
+	Status  string
+
+	// members of the organization (see `api.FindOrganization`), in addition to the scope of the API
+	OrganizationID int
+
+	// include soft deleted users
+	IncludeDeleted bool
+
+	// time ranges (zero time is not limited), lower bound is inclusive and upper bound is exclusive
+	CreatedAfter    time.Time
+	CreatedBefore   time.Time
+	UpdatedAfter    time.Time
+	UpdatedBefore   time.Time
+	LastLoginAfter  time.Time
+	LastLoginBefore time.Time
+
+	// nested object of custom attributes that users must contain (see `addAttributeFilter`)
+	Attributes map[string]interface{}
+
+	// column to sort by (see `userSortColumns`), prefixed with "-" for descending order, "id" by default
+	Sort string
+
+	// keyset pagination: users with ID greater than `AfterID`, at most `Limit` (if set)
+	// (only makes sense with the default sorting)
+	AfterID int
+	Limit   int
+}
+
+var invalidCredentialsProblem = common.NewProblem(http.StatusUnauthorized, common.ErrCodeInvalidCredentials,
+	"Email or password is incorrect")
+
+// hash of a random password that is checked when user is not found, so response time doesn't reveal existing emails
+var dummyPasswordHash = common.MustHashPassword(fmt.Sprint(rand.Int63()))
+
+// Validates input structure with the same rules as request binding.
+func validate(in interface{}) error {
+	if err := binding.Validator.ValidateStruct(in); err != nil {
+		return common.BindingProblem(err, in)
+	}
+	return nil
+}
+
+// Business logic below is shared by RESTful and gRPC APIs.
+// Expected failures are returned as `common.Problem`, any other error is unexpected.
+
+// Finds user by ID.
+func (api *API) FindUser(id int) (*model.User, error) {
+	var user model.User
+	if err := api.scopeUsers(api.DB).First(&user, id).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, userNotFoundProblem
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+// Finds users by public IDs in one query, missing users are skipped.
+func (api *API) FindUsers(publicIDs []string) ([]model.User, error) {
+	users := make([]model.User, 0, len(publicIDs))
+
+	// malformed IDs would fail the whole query
+	valid := make([]string, 0, len(publicIDs))
+	for _, publicID := range publicIDs {
+		if common.IsUUID(publicID) {
+			valid = append(valid, publicID)
+		}
+	}
+	if len(valid) == 0 {
+		return users, nil
+	}
+
+	if err := api.scopeUsers(api.DB).Where("public_id IN (?)", valid).Find(&users).Error; err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// Resolves public ID of the user (even if soft deleted) to internal ID.
+func (api *API) ResolveUserID(publicID string) (int, error) {
+	if !common.IsUUID(publicID) {
+		return 0, invalidUserIDProblem
+	}
+
+	var ids []int
+	if err := api.scopeUsers(api.DB.Unscoped().Model(&model.User{})).Where("public_id = ?", publicID).Pluck("id", &ids).Error; err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, userNotFoundProblem
+	}
+	return ids[0], nil
+}
+
+// Lists users by filter.
+func (api *API) ListUsers(filter UserFilter) ([]model.User, error) {
+	users := make([]model.User, 0)
+
+	db := api.scopeUsers(api.DB)
+	if filter.IncludeDeleted {
+		db = db.Unscoped()
+	}
+	if filter.OrganizationID > 0 {
+		db = db.Where("users.id IN (SELECT user_id FROM memberships WHERE organization_id = ?)", filter.OrganizationID)
+	}
+	if filter.Country != "" {
+		if !common.IsCountry(filter.Country) {
+			return nil, invalidQueryProblem("country")
+		}
+		db = db.Where(&model.User{Country: common.NormalizeCountry(filter.Country)})
+	}
+	if filter.Status != "" {
+		if !model.IsUserStatus(filter.Status) {
+			return nil, invalidQueryProblem("status")
+		}
+		db = db.Where("status = ?", filter.Status)
+	}
+	if len(filter.Attributes) > 0 {
+		data, err := json.Marshal(filter.Attributes)
+		if err != nil {
+			return nil, err
+		}
+		db = db.Where("attributes @> ?", string(data))
+	}
+	db = whereTimeRange(db, "created_at", filter.CreatedAfter, filter.CreatedBefore)
+	db = whereTimeRange(db, "updated_at", filter.UpdatedAfter, filter.UpdatedBefore)
+	db = whereTimeRange(db, "last_login_at", filter.LastLoginAfter, filter.LastLoginBefore)
+	if filter.AfterID > 0 {
+		db = db.Where("id > ?", filter.AfterID)
+	}
+	if filter.Limit > 0 {
+		db = db.Limit(filter.Limit)
+	}
+
+	// users who never logged in go last in both directions
+	if filter.Sort != "" && filter.Sort != "id" {
+		column, order := strings.TrimPrefix(filter.Sort, "-"), "ASC"
+		if column != filter.Sort {
+			order = "DESC"
+		}
+		if _, ok := userSortColumns[column]; !ok {
+			return nil, invalidQueryProblem("sort")
+		}
+		db = db.Order(fmt.Sprintf("%s %s NULLS LAST", userSortColumns[column], order))
+	}
+
+	// PostgreSQL doesn't have default order by primary key
+	// (entities in the list do not "shuffle" when we update one)
+	if err := db.Order("id").Find(&users).Error; err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// Limits column to time range, zero time is not limited.
+func whereTimeRange(db *gorm.DB, column string, after, before time.Time) *gorm.DB {
+	if !after.IsZero() {
+		db = db.Where(column+" >= ?", after)
+	}
+	if !before.IsZero() {
+		db = db.Where(column+" < ?", before)
+	}
+	return db
+}
+
+// Finds user by email and password and records the time of login.
+// Policies in force have to be accepted by the user, either before or with the login.
+func (api *API) Login(actor Actor, in LoginInput) (*model.User, error) {
+	email, password := in.Email, in.Password
+
+	var user model.User
+	normalized, err := common.NormalizeEmail(email)
+	if err == nil {
+		// users with colliding emails registered before normalization are found by raw email
+		err = api.scopeUsers(api.DB).Where("normalized_email = ? OR normalized_email IS NULL AND email = ?", normalized, email).First(&user).Error
+	}
+	if err != nil {
+		if err == common.ErrInvalidEmail || gorm.IsRecordNotFoundError(err) {
+			common.CheckPassword(dummyPasswordHash, password)
+			return nil, invalidCredentialsProblem
+		}
+		return nil, err
+	}
+	if !common.CheckPassword(user.Password, password) {
+		return nil, invalidCredentialsProblem
+	}
+	if err := api.checkUserStatus(&user); err != nil {
+		return nil, err
+	}
+	if err := api.acceptPolicies(actor, &user, in.Accept); err != nil {
+		return nil, err
+	}
+
+	// login is not a change of user details, so `updated_at` is kept as is
+	now := gorm.NowFunc()
+	if err := api.DB.Model(&user).UpdateColumn("last_login_at", now).Error; err != nil {
+		return nil, err
+	}
+	user.LastLoginAt = &now
+
+	// some meaningful logs to default logger
+	log.Printf("[users] user with ID %d logged in", user.ID)
+
+	return &user, nil
+}
+
+// Creates new user, records it in the audit log and publishes it to the queue.
+func (api *API) CreateUser(actor Actor, in UserInput) (*model.User, error) {
+	return api.createUser(actor, in, nil)
+}
+
+// Creates new user, `then` (if set) is called in the same transaction after the user is created.
+func (api *API) createUser(actor Actor, in UserInput, then func(tx *gorm.DB, user *model.User) error) (*model.User, error) {
+	if err := validate(&in); err != nil {
+		return nil, err
+	}
+
+	email, err := normalizeEmail(in.Email)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkNickname(in.Nickname); err != nil {
+		return nil, err
+	}
+	phone, err := normalizePhone(in.Phone, in.Country)
+	if err != nil {
+		return nil, err
+	}
+
+	// new entity
+	user := model.User{
+		PublicID:             common.NewUUIDv7(),
+		Email:                in.Email,
+		NormalizedEmail:      &email,
+		NormalizedEmailRules: common.EmailRulesVersion(),
+		Password:             common.MustHashPassword(in.Password),
+		FirstName:            in.FirstName,
+		LastName:             in.LastName,
+		Nickname:             in.Nickname,
+		Country:              common.NormalizeCountry(in.Country),
+		Phone:                phone,
+		Status:               model.UserStatusActive,
+	}
+
+	// try to save user entity to the database, user created in scope of organization becomes its member
+	err = api.transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		if api.org != nil {
+			membership := model.Membership{OrganizationID: api.org.ID, UserID: user.ID, Role: model.OrgRoleMember}
+			if err := tx.Create(&membership).Error; err != nil {
+				return err
+			}
+		}
+		if then != nil {
+			if err := then(tx, &user); err != nil {
+				return err
+			}
+		}
+		return audit(tx, actor, model.UserAuditCreate, nil, &user)
+	})
+	if err != nil {
+		if common.IsUniqueConstraintError(err, model.UserEmailUniqueConstraintName) {
+			return nil, emailExistsProblem(in.Email)
+		}
+		if common.IsUniqueConstraintError(err, model.UserNicknameUniqueConstraintName) {
+			return nil, nicknameExistsProblem(in.Nickname)
+		}
+		return nil, err
+	}
+
+	// try to publish message to the queue under "user.create" topic
+	if err := api.publishUser(TopicUserCreate, &user); err != nil {
+		// correct behaviour should be defined by requirements and may include:
+		// - rollback transaction above and return error
+		// - log error with data and continue
+		// - log error and continue
+		// - return error and let the caller panic! (yes, I'm cutting corners...)
+		return nil, err
+	}
+
+	// some meaningful logs to default logger
+	log.Printf("[users] user with ID %d was created", user.ID)
+
+	return &user, nil
+}
+
+// Checks if user is allowed to log in by status, expired suspension or ban is lifted right away.
+func (api *API) checkUserStatus(user *model.User) error {
+	if user.Status == model.UserStatusActive {
+		return nil
+	}
+	if user.StatusExpiresAt != nil && !user.StatusExpiresAt.After(gorm.NowFunc()) {
+		return api.saveUserStatus(systemActor, user, model.UserStatusActive, "", nil)
+	}
+	return userStatusProblems[user.Status]
+}
+
+// Changes user status by ID, records it in the audit log and publishes it to the queue.
+// Suspension and ban require the reason and may expire, then user gets active again.
+func (api *API) ChangeUserStatus(actor Actor, id int, status string, in UserStatusInput) (*model.User, error) {
+	if err := validate(&in); err != nil {
+		return nil, err
+	}
+	if status != model.UserStatusActive {
+		if in.Reason == "" {
+			return nil, common.FieldProblem("reason", "required", "")
+		}
+		if in.ExpiresAt != nil && !in.ExpiresAt.After(gorm.NowFunc()) {
+			return nil, common.FieldProblem("expires_at", "future", "")
+		}
+	} else {
+		in.ExpiresAt = nil
+	}
+
+	user, err := api.FindUser(id)
+	if err != nil {
+		return nil, err
+	}
+	if !model.CanChangeUserStatus(user.Status, status) {
+		return nil, statusTransitionProblem(user.Status, status)
+	}
+
+	if err = api.saveUserStatus(actor, user, status, in.Reason, in.ExpiresAt); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// Saves user status, records it in the audit log and publishes it to the queue.
+func (api *API) saveUserStatus(actor Actor, user *model.User, status, reason string, expiresAt *time.Time) error {
+	before := *user
+	err := api.transaction(func(tx *gorm.DB) error {
+		err := tx.Model(user).Updates(map[string]interface{}{
+			"status":            status,
+			"status_reason":     reason,
+			"status_expires_at": expiresAt,
+		}).Error
+		if err != nil {
+			return err
+		}
+		user.Status = status
+		user.StatusReason = reason
+		user.StatusExpiresAt = expiresAt
+
+		return audit(tx, actor, model.UserAuditStatusChanged, &before, user)
+	})
+	if err != nil {
+		return err
+	}
+
+	// try to publish message to the queue under "user.status_changed" topic (see `api.CreateUser` for more details)
+	if err = api.publishUser(TopicUserStatusChanged, user); err != nil {
+		return err
+	}
+
+	// some meaningful logs to default logger
+	log.Printf("[users] user with ID %d is %s", user.ID, status)
+
+	return nil
+}
+
+// Activates up to `limit` users whose suspension or ban expired before the time, returns number of activated users.
+// Rows locked by another node are skipped, so every user is activated (and published) only once.
+func (api *API) ActivateExpiredUsers(before time.Time, limit int) (int, error) {
+	tx := api.DB.Begin()
+
+	var users []model.User
+	err := tx.Set("gorm:query_option", "FOR UPDATE SKIP LOCKED").
+		Where("status IN (?) AND status_expires_at < ?", []string{model.UserStatusSuspended, model.UserStatusBanned}, before).
+		Order("id").Limit(limit).Find(&users).Error
+	if err != nil || len(users) == 0 {
+		tx.Rollback()
+		return 0, err
+	}
+
+	ids := make([]int, len(users))
+	for i := range users {
+		ids[i] = users[i].ID
+	}
+	now := gorm.NowFunc()
+	err = tx.Model(&model.User{}).Where("id IN (?)", ids).UpdateColumns(map[string]interface{}{
+		"status":            model.UserStatusActive,
+		"status_reason":     "",
+		"status_expires_at": nil,
+		"updated_at":        now,
+	}).Error
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	for i := range users {
+		before := users[i]
+		users[i].Status = model.UserStatusActive
+		users[i].StatusReason = ""
+		users[i].StatusExpiresAt = nil
+		users[i].UpdatedAt = now
+		if err = audit(tx, systemActor, model.UserAuditStatusChanged, &before, &users[i]); err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+	if err = tx.Commit().Error; err != nil {
+		return 0, err
+	}
+
+	orgIDs, err := userOrgIDs(api.DB, ids...)
+	if err != nil {
+		return len(users), err
+	}
+	for i := range users {
+		if err = common.NSQPublish(api.NSQ, TopicUserStatusChanged, api.userMessage(&users[i], orgIDs[users[i].ID])); err != nil {
+			return len(users), err
+		}
+		log.Printf("[users] user with ID %d is %s", users[i].ID, model.UserStatusActive)
+	}
+	return len(users), nil
+}
+
+// Updates user by ID, records it in the audit log and publishes it to the queue.
+func (api *API) UpdateUser(actor Actor, id int, in UserInput) (*model.User, error) {
+	if err := validate(&in); err != nil {
+		return nil, err
+	}
+
+	email, err := normalizeEmail(in.Email)
+	if err != nil {
+		return nil, err
+	}
+	phone, err := normalizePhone(in.Phone, in.Country)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := api.FindUser(id)
+	if err != nil {
+		return nil, err
+	}
+	before := *user
+
+	// users keep reserved nicknames they had before the list was changed
+	if !strings.EqualFold(before.Nickname, in.Nickname) {
+		if err = checkNickname(in.Nickname); err != nil {
+			return nil, err
+		}
+	}
+
+	user.Email = in.Email
+	user.NormalizedEmail = &email
+	user.NormalizedEmailRules = common.EmailRulesVersion()
+	user.FirstName = in.FirstName
+	user.LastName = in.LastName
+	user.Nickname = in.Nickname
+	user.Country = common.NormalizeCountry(in.Country)
+
+	// changed phone has to be verified again
+	if user.Phone != phone {
+		user.Phone = phone
+		user.PhoneVerifiedAt = nil
+	}
+
+	// for password change I'd rather introduce a separate endpoint or transform request method to PATCH but with care,
+	// as later may face problems with nullable fields (pointer type), since identifying if request body contains
+	// a specific field or the field is empty will be tricky
+	//
+	// can also allow empty password in request body (as an exception) and update it only if provided
+	// the implementation entirely depends on the project design and some limitations of Go and framework
+	user.Password = common.MustHashPassword(in.Password)
+
+	// try to save user entity to the database
+	err = api.transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(user).Error; err != nil {
+			return err
+		}
+		return audit(tx, actor, model.UserAuditUpdate, &before, user)
+	})
+	if err != nil {
+		if common.IsUniqueConstraintError(err, model.UserEmailUniqueConstraintName) {
+			return nil, emailExistsProblem(in.Email)
+		}
+		if common.IsUniqueConstraintError(err, model.UserNicknameUniqueConstraintName) {
+			return nil, nicknameExistsProblem(in.Nickname)
+		}
+		return nil, err
+	}
+
+	// try to publish message to the queue under "user.update" topic (see `api.CreateUser` for more details)
+	if err = api.publishUser(TopicUserUpdate, user); err != nil {
+		return nil, err
+	}
+
+	// some meaningful logs to default logger
+	log.Printf("[users] user with ID %d was updated", user.ID)
+
+	return user, nil
+}
+
+// Soft deletes user by ID, records it in the audit log and publishes it to the queue.
+// Deletion of the user that doesn't exist is not an error, as the operation is idempotent.
+func (api *API) DeleteUser(actor Actor, id int) error {
+	user, err := api.FindUser(id)
+	if err != nil {
+		if common.IsProblem(err, common.ErrCodeUserNotFound) {
+			return nil
+		}
+		return err
+	}
+	before := *user
+
+	// try to soft delete user entity in the database
+	// (same as GORM does on `Delete()`, but the time is kept for the message)
+	err = api.transaction(func(tx *gorm.DB) error {
+		now := gorm.NowFunc()
+		if err := tx.Model(user).Update("deleted_at", now).Error; err != nil {
+			return err
+		}
+		user.DeletedAt = &now
+
+		return audit(tx, actor, model.UserAuditDelete, &before, user)
+	})
+	if err != nil {
+		return err
+	}
+
+	// try to publish message to the queue under "user.delete" topic (see `api.CreateUser` for more details)
+	if err = api.publishUser(TopicUserDelete, user); err != nil {
+		return err
+	}
+
+	// some meaningful logs to default logger
+	log.Printf("[users] user with ID %d was deleted", user.ID)
+
+	return nil
+}
+
+// Restores soft deleted user by ID, records it in the audit log and publishes it to the queue.
+// Restoring the user that is not deleted does nothing.
+func (api *API) RestoreUser(actor Actor, id int) (*model.User, error) {
+	var user model.User
+	if err := api.scopeUsers(api.DB.Unscoped()).First(&user, id).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, userNotFoundProblem
+		}
+		return nil, err
+	}
+	if user.ErasedAt != nil {
+		return nil, userErasedProblem
+	}
+	if user.DeletedAt == nil {
+		return &user, nil
+	}
+	before := user
+
+	// email or nickname could be taken by another user while this one was deleted
+	err := api.transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Model(&user).Update("deleted_at", nil).Error; err != nil {
+			return err
+		}
+		user.DeletedAt = nil
+
+		return audit(tx, actor, model.UserAuditRestore, &before, &user)
+	})
+	if err != nil {
+		if common.IsUniqueConstraintError(err, model.UserEmailUniqueConstraintName) {
+			return nil, emailExistsProblem(user.Email)
+		}
+		if common.IsUniqueConstraintError(err, model.UserNicknameUniqueConstraintName) {
+			return nil, nicknameExistsProblem(user.Nickname)
+		}
+		return nil, err
+	}
+
+	// try to publish message to the queue under "user.restore" topic (see `api.CreateUser` for more details)
+	if err := api.publishUser(TopicUserRestore, &user); err != nil {
+		return nil, err
+	}
+
+	// some meaningful logs to default logger
+	log.Printf("[users] user with ID %d was restored", user.ID)
+
+	return &user, nil
+}
+
+// Permanently deletes user by ID (even if soft deleted), records it in the audit log and publishes it to the queue.
+// Purging the user that doesn't exist is not an error, as the operation is idempotent.
+func (api *API) PurgeUser(actor Actor, id int) error {
+	var user model.User
+	if err := api.scopeUsers(api.DB.Unscoped()).First(&user, id).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil
+		}
+		return err
+	}
+
+	// memberships are purged with the user, but the message is still tagged with organizations
+	orgIDs, err := userOrgIDs(api.DB, user.ID)
+	if err != nil {
+		return err
+	}
+
+	// try to delete user entity from the database
+	err = api.transaction(func(tx *gorm.DB) error {
+		if err := purgeUserData(tx, user.ID); err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Delete(&user).Error; err != nil {
+			return err
+		}
+		return audit(tx, actor, model.UserAuditPurge, &user, nil)
+	})
+	if err != nil {
+		return err
+	}
+	api.deleteAvatar(user.AvatarKey)
+	api.deleteDataExport(dataExportPrefix(user.PublicID))
+
+	// try to publish message to the queue under "user.purge" topic (see `api.CreateUser` for more details)
+	if err := common.NSQPublish(api.NSQ, TopicUserPurge, api.userMessage(&user, orgIDs[user.ID])); err != nil {
+		return err
+	}
+
+	// some meaningful logs to default logger
+	log.Printf("[users] user with ID %d was purged", user.ID)
+
+	return nil
+}
+
+// Deletes data related to the users that are about to be purged.
+func purgeUserData(tx *gorm.DB, ids ...int) error {
+	tables := []interface{}{
+		&model.UserPreferences{}, &model.PhoneVerification{}, &model.Membership{}, &model.GroupMember{},
+		&model.Invitation{}, &model.DataExport{}, &model.Consent{},
+	}
+	for _, related := range tables {
+		if err := tx.Where("user_id IN (?)", ids).Delete(related).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Permanently deletes up to `limit` users that were soft deleted before the time, returns number of purged users.
+// Rows locked by another node are skipped, so every user is purged (and published) only once.
+func (api *API) PurgeDeletedUsers(before time.Time, limit int) (int, error) {
+	tx := api.DB.Begin()
+
+	var users []model.User
+	err := tx.Unscoped().Set("gorm:query_option", "FOR UPDATE SKIP LOCKED").
+		Where("deleted_at < ? AND erased_at IS NULL", before).Order("id").Limit(limit).Find(&users).Error
+	if err != nil || len(users) == 0 {
+		tx.Rollback()
+		return 0, err
+	}
+
+	ids := make([]int, len(users))
+	for i := range users {
+		ids[i] = users[i].ID
+	}
+	orgIDs, err := userOrgIDs(tx, ids...)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	if err = purgeUserData(tx, ids...); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	if err = tx.Unscoped().Where("id IN (?)", ids).Delete(&model.User{}).Error; err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	for i := range users {
+		if err = audit(tx, systemActor, model.UserAuditPurge, &users[i], nil); err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+	if err = tx.Commit().Error; err != nil {
+		return 0, err
+	}
+
+	for i := range users {
+		api.deleteAvatar(users[i].AvatarKey)
+		api.deleteDataExport(dataExportPrefix(users[i].PublicID))
+		if err = common.NSQPublish(api.NSQ, TopicUserPurge, api.userMessage(&users[i], orgIDs[users[i].ID])); err != nil {
+			return len(users), err
+		}
+		log.Printf("[users] user with ID %d was purged", users[i].ID)
+	}
+	return len(users), nil
+}
+
+// Irreversibly erases personal data of the user by ID (even if soft deleted), records it in the audit log
+// and publishes it to the queue, so other services erase their copies too.
+// Unlike purged, erased user is kept deleted with personal fields anonymized, so audit entries and IDs known
+// to other services still refer to it. Related data and cached responses are purged and personal values are scrubbed from the audit log.
+// Erasing the user that is already erased does nothing.
+func (api *API) EraseUser(actor Actor, id int) error {
+	var user model.User
+	if err := api.scopeUsers(api.DB.Unscoped()).First(&user, id).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return userNotFoundProblem
+		}
+		return err
+	}
+	if user.ErasedAt != nil {
+		return nil
+	}
+	before := user
+
+	// memberships are purged, but the message is still tagged with organizations
+	orgIDs, err := userOrgIDs(api.DB, user.ID)
+	if err != nil {
+		return err
+	}
+
+	// email is replaced with a tombstone, so the same email is recognized without being stored
+	email := strings.ToLower(user.Email)
+	if user.NormalizedEmail != nil {
+		email = *user.NormalizedEmail
+	}
+	tombstone := common.EmailTombstone(api.ErasureSecret, email)
+
+	now := gorm.NowFunc()
+	user.Email = tombstone
+	user.NormalizedEmail = &tombstone
+	user.NormalizedEmailRules = common.EmailRulesVersion()
+	user.Password = ""
+	user.FirstName = ""
+	user.LastName = ""
+	user.Nickname = ""
+	user.Phone = ""
+	user.PhoneVerifiedAt = nil
+	user.StatusReason = ""
+	user.AvatarKey = ""
+	user.AvatarURL = ""
+	user.AvatarThumbnails = nil
+	user.Attributes = model.UserAttributes{}
+	if user.DeletedAt == nil {
+		user.DeletedAt = &now
+	}
+	user.ErasedAt = &now
+
+	err = api.transaction(func(tx *gorm.DB) error {
+		if err := purgeUserData(tx, user.ID); err != nil {
+			return err
+		}
+		// invitations to the email that were never accepted are not linked to the user
+		if err := tx.Where("normalized_email = ?", email).Delete(&model.Invitation{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Save(&user).Error; err != nil {
+			return err
+		}
+		// responses replayed on retries of requests (see `api.IdempotencyMiddleware`) may contain personal data too
+		if err := deleteIdempotentResponses(tx, before.PublicID, before.Email); err != nil {
+			return err
+		}
+		if err := audit(tx, actor, model.UserAuditErase, &before, &user); err != nil {
+			return err
+		}
+		return scrubUserAudit(tx, user.ID)
+	})
+	if err != nil {
+		return err
+	}
+	api.deleteAvatar(before.AvatarKey)
+	api.deleteDataExport(dataExportPrefix(user.PublicID))
+
+	// try to publish message to the queue under "user.erased" topic (see `api.CreateUser` for more details)
+	if err = common.NSQPublish(api.NSQ, TopicUserErased, api.userMessage(&user, orgIDs[user.ID])); err != nil {
+		return err
+	}
+
+	// some meaningful logs to default logger
+	log.Printf("[users] user with ID %d was erased", user.ID)
+
+	return nil
+}
+
+// Scrubs values of personal fields from audit entries of the user (including the entry of erasure itself),
+// as well as addresses of anonymous actors, who are likely the user.
+func scrubUserAudit(tx *gorm.DB, id int) error {
+	var entries []model.UserAudit
+	if err := tx.Where("user_id = ?", id).Find(&entries).Error; err != nil {
+		return err
+	}
+	for i := range entries {
+		entries[i].Changes.Scrub()
+		if err := tx.Model(&entries[i]).UpdateColumn("changes", entries[i].Changes).Error; err != nil {
+			return err
+		}
+	}
+	return tx.Model(&model.UserAudit{}).Where("user_id = ? AND actor = ?", id, "anonymous").UpdateColumn("actor_ip", "").Error
+}
+
+// Lists audit log of the user (even if soft deleted) from the newest entries.
+// Keyset pagination: entries with ID less than `beforeID` (if set), at most `limit`.
+func (api *API) ListUserHistory(id, beforeID, limit int) ([]model.UserAudit, error) {
+	entries := make([]model.UserAudit, 0)
+
+	// audit log is not scoped by itself, so the user has to be a member of the organization
+	if api.org != nil {
+		var count int
+		if err := api.scopeUsers(api.DB.Unscoped().Model(&model.User{})).Where("id = ?", id).Count(&count).Error; err != nil {
+			return nil, err
+		}
+		if count == 0 {
+			return nil, userNotFoundProblem
+		}
+	}
+
+	db := api.DB.Where("user_id = ?", id)
+	if beforeID > 0 {
+		db = db.Where("id < ?", beforeID)
+	}
+	if err := db.Order("id DESC").Limit(limit).Find(&entries).Error; err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// Calls handler for every user change published to the queue until context is done or handler fails.
+// Scoped API watches only changes of members of the organization.
+// Each watcher consumes from its own ephemeral channel, so it receives changes made by all nodes,
+// but the order of changes between different topics is not guaranteed.
+func (api *API) WatchUsers(ctx context.Context, handler func(topic string, user model.User) error) error {
+	channel := fmt.Sprintf("watch-%d#ephemeral", rand.Int63())
+
+	var mu sync.Mutex
+	errc := make(chan error, 1)
+
+	topics := []string{TopicUserCreate, TopicUserUpdate, TopicUserDelete, TopicUserRestore, TopicUserPurge, TopicUserErased, TopicUserStatusChanged}
+	for _, topic := range topics {
+		consumer, err := nsq.NewConsumer(topic, channel, nsq.NewConfig())
+		if err != nil {
+			return err
+		}
+		defer consumer.Stop()
+
+		topic := topic
+		consumer.AddHandler(nsq.HandlerFunc(func(m *nsq.Message) error {
+			var message UserMessage
+			if err := json.Unmarshal(m.Body, &message); err != nil {
+				log.Printf("[users] malformed message in %s topic: %s", topic, err)
+				return nil
+			}
+			if !api.watches(message) {
+				return nil
+			}
+
+			// handlers of different topics run concurrently
+			mu.Lock()
+			defer mu.Unlock()
+
+			if err := handler(topic, message.User); err != nil {
+				select {
+				case errc <- err:
+				default:
+				}
+			}
+			return nil
+		}))
+
+		if err = consumer.ConnectToNSQD(api.NSQAddr); err != nil {
+			return err
+		}
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil
+	case err := <-errc:
+		return err
+	}
+}
+
+// Checks if the user change is visible in the scope of the API.
+func (api *API) watches(message UserMessage) bool {
+	if api.org == nil {
+		return true
+	}
+	for _, orgID := range message.OrgIDs {
+		if orgID == api.org.PublicID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,600 @@
+package api
+
+import (
+	"encoding/xml"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lokhman/example-users-microservice/model"
+)
+
+// Version 2 of the API exposes users through DTOs decoupled from `model.User`,
+// so the database model may change without breaking the clients.
+// Users are identified by public IDs only, internal integer IDs are never exposed.
+
+// User name (v2).
+type UserNameV2 struct {
+	First string `json:"first" xml:"first" example:"Alex"`
+	Last  string `json:"last" xml:"last" example:"Lokhman"`
+}
+
+// User representation (v2).
+type UserV2 struct {
+	XMLName  xml.Name   `json:"-" xml:"user"`
+	ID       string     `json:"id" xml:"id" example:"01890a5d-ac96-774b-bcce-b302099a8057"`
+	Email    string     `json:"email" xml:"email" example:"alex.lokhman@gmail.com"`
+	Name     UserNameV2 `json:"name" xml:"name"`
+	Nickname string     `json:"nickname" xml:"nickname" example:"VisioN"`
+	Country  string     `json:"country" xml:"country" example:"RU"`
+
+	// phone in E.164 format and ISO 8601 time it was verified, only present if set
+	Phone           string     `json:"phone,omitempty" xml:"phone,omitempty" example:"+79161234567"`
+	PhoneVerifiedAt *time.Time `json:"phone_verified_at,omitempty" xml:"phone_verified_at,omitempty" example:"2019-01-01T00:00:00Z"`
+
+	// status with the reason and ISO 8601 time it expires at (if any)
+	Status          string     `json:"status" xml:"status" example:"active"`
+	StatusReason    string     `json:"status_reason,omitempty" xml:"status_reason,omitempty" example:"Spam"`
+	StatusExpiresAt *time.Time `json:"status_expires_at,omitempty" xml:"status_expires_at,omitempty" example:"2019-01-01T00:00:00Z"`
+
+	// avatar and its square thumbnails, only present if uploaded
+	AvatarURL        string              `json:"avatar_url,omitempty" xml:"avatar_url,omitempty" example:"/blobs/avatars/01890a5d-ac96-774b-bcce-b302099a8057/original.jpg"`
+	AvatarThumbnails []AvatarThumbnailV2 `json:"avatar_thumbnails,omitempty" xml:"avatar_thumbnails>thumbnail,omitempty"`
+
+	// custom attributes by namespace
+	Attributes model.UserAttributes `json:"attributes" xml:"attributes" swaggertype:"object"`
+
+	// ISO 8601 times of creation, last update and last login (null if user never logged in)
+	CreatedAt   time.Time  `json:"created_at" xml:"created_at" example:"2019-01-01T00:00:00Z"`
+	UpdatedAt   time.Time  `json:"updated_at" xml:"updated_at" example:"2019-01-01T00:00:00Z"`
+	LastLoginAt *time.Time `json:"last_login_at" xml:"last_login_at,omitempty" example:"2019-01-01T00:00:00Z"`
+
+	// ISO 8601 times of soft deletion and erasure, only present for deleted (and erased) users
+	DeletedAt *time.Time `json:"deleted_at,omitempty" xml:"deleted_at,omitempty" example:"2019-01-01T00:00:00Z"`
+	ErasedAt  *time.Time `json:"erased_at,omitempty" xml:"erased_at,omitempty" example:"2019-01-01T00:00:00Z"`
+}
+
+// Square thumbnail of user avatar (v2).
+type AvatarThumbnailV2 struct {
+	Size int    `json:"size" xml:"size,attr" example:"64"`
+	URL  string `json:"url" xml:",chardata" example:"/blobs/avatars/01890a5d-ac96-774b-bcce-b302099a8057/64.jpg"`
+}
+
+// User name input (v2).
+type UserNameInputV2 struct {
+	First string `json:"first" form:"name.first" binding:"required,max=72" example:"Alex"`
+	Last  string `json:"last" form:"name.last" binding:"required,max=72" example:"Lokhman"`
+}
+
+// User input structure (v2).
+type UserInputV2 struct {
+	Email    string          `json:"email" form:"email" binding:"required,email" example:"alex.lokhman@gmail.com"`
+	Password string          `json:"password" form:"password" binding:"required,min=3,max=72" example:"MyPassword"`
+	Name     UserNameInputV2 `json:"name"`
+	Nickname string          `json:"nickname" form:"nickname" binding:"required,max=32" example:"VisioN"`
+	Country  string          `json:"country" form:"country" binding:"required,country" example:"RU"`
+	Phone    string          `json:"phone" form:"phone" binding:"max=32" example:"+79161234567"`
+}
+
+// Converts user model to v2 representation.
+func newUserV2(user *model.User) UserV2 {
+	var thumbnails []AvatarThumbnailV2
+	for _, t := range user.AvatarThumbnails {
+		thumbnails = append(thumbnails, AvatarThumbnailV2{Size: t.Size, URL: t.URL})
+	}
+
+	return UserV2{
+		ID:       user.PublicID,
+		Email:    user.Email,
+		Name:     UserNameV2{First: user.FirstName, Last: user.LastName},
+		Nickname: user.Nickname,
+		Country:  user.Country,
+
+		Phone:           user.Phone,
+		PhoneVerifiedAt: user.PhoneVerifiedAt,
+
+		Status:          user.Status,
+		StatusReason:    user.StatusReason,
+		StatusExpiresAt: user.StatusExpiresAt,
+
+		AvatarURL:        user.AvatarURL,
+		AvatarThumbnails: thumbnails,
+
+		Attributes: user.Attributes,
+
+		CreatedAt:   user.CreatedAt,
+		UpdatedAt:   user.UpdatedAt,
+		LastLoginAt: user.LastLoginAt,
+		DeletedAt:   user.DeletedAt,
+		ErasedAt:    user.ErasedAt,
+	}
+}
+
+// Converts v2 input to the input of business logic.
+func (in UserInputV2) toUserInput() UserInput {
+	return UserInput{
+		Email:     in.Email,
+		Password:  in.Password,
+		FirstName: in.Name.First,
+		LastName:  in.Name.Last,
+		Nickname:  in.Nickname,
+		Country:   in.Country,
+		Phone:     in.Phone,
+	}
+}
+
+// @Summary List users
+// @Accept  json
+// @Produce json,application/x-msgpack,xml
+// @Param   country query string false "User country" minlength(2) maxlength(2)
+// @Param   status query string false "User status" Enums(pending, active, suspended, banned)
+// @Param   organization query string false "Organization public ID" format(uuid)
+// @Param   include_deleted query bool false "Include soft deleted users (administrators only)"
+// @Param   created_after query string false "Users created at or after the time" format(date-time)
+// @Param   created_before query string false "Users created before the time" format(date-time)
+// @Param   updated_after query string false "Users updated at or after the time" format(date-time)
+// @Param   updated_before query string false "Users updated before the time" format(date-time)
+// @Param   last_login_after query string false "Users logged in at or after the time" format(date-time)
+// @Param   last_login_before query string false "Users logged in before the time" format(date-time)
+// @Param   sort query string false "Sort column, prefixed with minus for descending order" Enums(id, -id, created_at, -created_at, updated_at, -updated_at, last_login_at, -last_login_at)
+// @Param   attr.{namespace}.{key} query string false "Custom attribute value (parsed as JSON if possible)"
+// @Success 200 {array} api.UserV2
+// @Failure 400 {object} common.Problem
+// @Failure 403 {object} common.Problem
+// @Failure 404 {object} common.Problem
+// @Router  /v2/users [get]
+func (api *API) UserIndexV2Handler(c *gin.Context) {
+	api = api.scoped(c)
+
+	filter, ok := api.userFilterFromQuery(c)
+	if !ok {
+		return
+	}
+
+	users, err := api.ListUsers(filter)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	out := make([]UserV2, len(users))
+	for i := range users {
+		out[i] = newUserV2(&users[i])
+	}
+	respond(c, http.StatusOK, out)
+}
+
+// @Summary Create new user
+// @Accept  json,x-www-form-urlencoded,application/x-msgpack
+// @Produce json,application/x-msgpack,xml
+// @Param   user body api.UserInputV2 true "New user details"
+// @Param   Idempotency-Key header string false "Key to safely retry the request" maxlength(255)
+// @Success 201 {object} api.UserV2
+// @Failure 400 {object} common.Problem
+// @Failure 409 {object} common.Problem
+// @Failure 422 {object} common.Problem
+// @Router  /v2/users [post]
+func (api *API) UserCreateV2Handler(c *gin.Context) {
+	api = api.scoped(c)
+
+	var in UserInputV2
+	if !bind(c, &in) {
+		return
+	}
+
+	user, err := api.CreateUser(api.actor(c), in.toUserInput())
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.Header("Location", "/v2/users/"+user.PublicID)
+	respond(c, http.StatusCreated, newUserV2(user))
+}
+
+// @Summary View user details
+// @Accept  json
+// @Produce json,application/x-msgpack,xml
+// @Param   id path string true "User public ID" format(uuid)
+// @Success 200 {object} api.UserV2
+// @Failure 404 {object} common.Problem
+// @Router  /v2/users/{id} [get]
+func (api *API) UserViewV2Handler(c *gin.Context) {
+	api = api.scoped(c)
+
+	id, err := api.userIDFromParam(c)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	user, err := api.FindUser(id)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	respond(c, http.StatusOK, newUserV2(user))
+}
+
+// @Summary Update user by ID
+// @Accept  json,x-www-form-urlencoded,application/x-msgpack
+// @Produce json,application/x-msgpack,xml
+// @Param   id path string true "User public ID" format(uuid)
+// @Param   user body api.UserInputV2 true "New user details"
+// @Success 200 {object} api.UserV2
+// @Failure 400 {object} common.Problem
+// @Failure 404 {object} common.Problem
+// @Failure 422 {object} common.Problem
+// @Router  /v2/users/{id} [put]
+func (api *API) UserUpdateV2Handler(c *gin.Context) {
+	api = api.scoped(c)
+
+	id, err := api.userIDFromParam(c)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	if _, err = api.FindUser(id); err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	var in UserInputV2
+	if !bind(c, &in) {
+		return
+	}
+
+	user, err := api.UpdateUser(api.actor(c), id, in.toUserInput())
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	respond(c, http.StatusOK, newUserV2(user))
+}
+
+// @Summary Delete user by ID
+// @Accept  json
+// @Produce json,application/x-msgpack,xml
+// @Param   id path string true "User public ID" format(uuid)
+// @Param   purge query bool false "Delete permanently (administrators only)"
+// @Success 204 ""
+// @Failure 403 {object} common.Problem
+// @Failure 404 {object} common.Problem
+// @Router  /v2/users/{id} [delete]
+func (api *API) UserDeleteV2Handler(c *gin.Context) {
+	api = api.scoped(c)
+
+	if !api.deleteOrPurgeUser(c, false) {
+		return
+	}
+
+	respond(c, http.StatusNoContent, nil)
+}
+
+// @Summary Restore soft deleted user by ID
+// @Accept  json
+// @Produce json,application/x-msgpack,xml
+// @Param   id path string true "User public ID" format(uuid)
+// @Success 200 {object} api.UserV2
+// @Failure 404 {object} common.Problem
+// @Failure 409 {object} common.Problem
+// @Failure 422 {object} common.Problem
+// @Router  /v2/users/{id}/restore [post]
+func (api *API) UserRestoreV2Handler(c *gin.Context) {
+	api = api.scoped(c)
+
+	id, err := api.userIDFromParam(c)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	user, err := api.RestoreUser(api.actor(c), id)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	respond(c, http.StatusOK, newUserV2(user))
+}
+
+// @Summary Suspend user by ID (administrators only)
+// @Accept  json,x-www-form-urlencoded,application/x-msgpack
+// @Produce json,application/x-msgpack,xml
+// @Param   id path string true "User public ID" format(uuid)
+// @Param   status body api.UserStatusInput true "Reason (required) and optional expiry time"
+// @Success 200 {object} api.UserV2
+// @Failure 403 {object} common.Problem
+// @Failure 404 {object} common.Problem
+// @Failure 409 {object} common.Problem
+// @Failure 422 {object} common.Problem
+// @Router  /v2/users/{id}/suspend [post]
+func (api *API) UserSuspendV2Handler(c *gin.Context) {
+	api = api.scoped(c)
+
+	if user, ok := api.changeUserStatus(c, model.UserStatusSuspended); ok {
+		respond(c, http.StatusOK, newUserV2(user))
+	}
+}
+
+// @Summary Ban user by ID (administrators only)
+// @Accept  json,x-www-form-urlencoded,application/x-msgpack
+// @Produce json,application/x-msgpack,xml
+// @Param   id path string true "User public ID" format(uuid)
+// @Param   status body api.UserStatusInput true "Reason (required) and optional expiry time"
+// @Success 200 {object} api.UserV2
+// @Failure 403 {object} common.Problem
+// @Failure 404 {object} common.Problem
+// @Failure 409 {object} common.Problem
+// @Failure 422 {object} common.Problem
+// @Router  /v2/users/{id}/ban [post]
+func (api *API) UserBanV2Handler(c *gin.Context) {
+	api = api.scoped(c)
+
+	if user, ok := api.changeUserStatus(c, model.UserStatusBanned); ok {
+		respond(c, http.StatusOK, newUserV2(user))
+	}
+}
+
+// @Summary Activate user by ID (administrators only)
+// @Accept  json,x-www-form-urlencoded,application/x-msgpack
+// @Produce json,application/x-msgpack,xml
+// @Param   id path string true "User public ID" format(uuid)
+// @Param   status body api.UserStatusInput false "Optional reason"
+// @Success 200 {object} api.UserV2
+// @Failure 403 {object} common.Problem
+// @Failure 404 {object} common.Problem
+// @Failure 409 {object} common.Problem
+// @Failure 422 {object} common.Problem
+// @Router  /v2/users/{id}/activate [post]
+func (api *API) UserActivateV2Handler(c *gin.Context) {
+	api = api.scoped(c)
+
+	if user, ok := api.changeUserStatus(c, model.UserStatusActive); ok {
+		respond(c, http.StatusOK, newUserV2(user))
+	}
+}
+
+// @Summary Upload user avatar by ID
+// @Description Accepts JPEG, PNG or WebP image as raw request body or `avatar` field of multipart form.
+// @Description Image is re-encoded without metadata and square thumbnails are generated.
+// @Accept  image/jpeg,image/png,image/webp,multipart/form-data
+// @Produce json,application/x-msgpack,xml
+// @Param   id path string true "User public ID" format(uuid)
+// @Param   avatar formData file false "Avatar image"
+// @Success 200 {object} api.UserV2
+// @Failure 400 {object} common.Problem
+// @Failure 404 {object} common.Problem
+// @Failure 413 {object} common.Problem
+// @Failure 415 {object} common.Problem
+// @Failure 422 {object} common.Problem
+// @Router  /v2/users/{id}/avatar [put]
+func (api *API) UserAvatarV2Handler(c *gin.Context) {
+	api = api.scoped(c)
+
+	if user, ok := api.setUserAvatar(c); ok {
+		respond(c, http.StatusOK, newUserV2(user))
+	}
+}
+
+// @Summary Set custom attributes of user by ID and namespace
+// @Description Request body is any JSON value, which must match the schema of the namespace (null deletes attributes).
+// @Accept  json
+// @Produce json,application/x-msgpack,xml
+// @Param   id path string true "User public ID" format(uuid)
+// @Param   namespace path string true "Attributes namespace"
+// @Param   attributes body object true "Attributes value"
+// @Success 200 {object} api.UserV2
+// @Failure 400 {object} common.Problem
+// @Failure 404 {object} common.Problem
+// @Failure 422 {object} common.Problem
+// @Router  /v2/users/{id}/attributes/{namespace} [put]
+func (api *API) UserAttributesV2Handler(c *gin.Context) {
+	api = api.scoped(c)
+
+	if user, ok := api.setUserAttributes(c, false); ok {
+		respond(c, http.StatusOK, newUserV2(user))
+	}
+}
+
+// @Summary Delete custom attributes of user by ID and namespace
+// @Accept  json
+// @Produce json,application/x-msgpack,xml
+// @Param   id path string true "User public ID" format(uuid)
+// @Param   namespace path string true "Attributes namespace"
+// @Success 204 ""
+// @Failure 404 {object} common.Problem
+// @Router  /v2/users/{id}/attributes/{namespace} [delete]
+func (api *API) UserAttributesDeleteV2Handler(c *gin.Context) {
+	api = api.scoped(c)
+
+	if _, ok := api.setUserAttributes(c, true); ok {
+		respond(c, http.StatusNoContent, nil)
+	}
+}
+
+// @Summary View user preferences by ID
+// @Description Preferences that user never changed have default values.
+// @Accept  json
+// @Produce json,application/x-msgpack,xml
+// @Param   id path string true "User public ID" format(uuid)
+// @Success 200 {object} model.Preferences
+// @Failure 404 {object} common.Problem
+// @Router  /v2/users/{id}/preferences [get]
+func (api *API) UserPreferencesV2Handler(c *gin.Context) {
+	api = api.scoped(c)
+
+	if preferences, ok := api.userPreferences(c); ok {
+		respond(c, http.StatusOK, preferences)
+	}
+}
+
+// @Summary Update user preferences by ID
+// @Description Only given preferences are changed, the others are left as they are.
+// @Accept  json,x-www-form-urlencoded,application/x-msgpack
+// @Produce json,application/x-msgpack,xml
+// @Param   id path string true "User public ID" format(uuid)
+// @Param   preferences body api.PreferencesInput true "Changed preferences"
+// @Success 200 {object} model.Preferences
+// @Failure 400 {object} common.Problem
+// @Failure 404 {object} common.Problem
+// @Failure 422 {object} common.Problem
+// @Router  /v2/users/{id}/preferences [put]
+func (api *API) UserPreferencesUpdateV2Handler(c *gin.Context) {
+	api = api.scoped(c)
+
+	if preferences, ok := api.updateUserPreferences(c); ok {
+		respond(c, http.StatusOK, preferences)
+	}
+}
+
+// @Summary Send verification code to user phone by ID
+// @Description Code is sent by SMS and valid for 10 minutes, the next code can be sent in a minute.
+// @Accept  json
+// @Produce json,application/x-msgpack,xml
+// @Param   id path string true "User public ID" format(uuid)
+// @Success 204 ""
+// @Failure 404 {object} common.Problem
+// @Failure 409 {object} common.Problem
+// @Failure 429 {object} common.Problem
+// @Router  /v2/users/{id}/phone/verification [post]
+func (api *API) UserPhoneVerificationV2Handler(c *gin.Context) {
+	api = api.scoped(c)
+
+	if api.sendPhoneVerification(c) {
+		respond(c, http.StatusNoContent, nil)
+	}
+}
+
+// @Summary Verify user phone by ID with the code sent by SMS
+// @Accept  json,x-www-form-urlencoded,application/x-msgpack
+// @Produce json,application/x-msgpack,xml
+// @Param   id path string true "User public ID" format(uuid)
+// @Param   verification body api.PhoneVerificationInput true "Verification code"
+// @Success 200 {object} api.UserV2
+// @Failure 400 {object} common.Problem
+// @Failure 404 {object} common.Problem
+// @Failure 422 {object} common.Problem
+// @Router  /v2/users/{id}/phone/verification/confirm [post]
+func (api *API) UserPhoneVerifyV2Handler(c *gin.Context) {
+	api = api.scoped(c)
+
+	if user, ok := api.verifyUserPhone(c); ok {
+		respond(c, http.StatusOK, newUserV2(user))
+	}
+}
+
+// @Summary Log user in by email and password
+// @Accept  json,x-www-form-urlencoded,application/x-msgpack
+// @Produce json,application/x-msgpack,xml
+// @Param   credentials body api.LoginInput true "User credentials"
+// @Success 200 {object} api.UserV2
+// @Failure 401 {object} common.Problem
+// @Failure 403 {object} common.Problem
+// @Failure 422 {object} common.Problem
+// @Router  /v2/login [post]
+func (api *API) LoginV2Handler(c *gin.Context) {
+	api = api.scoped(c)
+
+	var in LoginInput
+	if !bind(c, &in) {
+		return
+	}
+
+	user, err := api.Login(api.actor(c), in)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	respond(c, http.StatusOK, newUserV2(user))
+}
+
+// @Summary List groups of user by ID
+// @Description Groups include parents of the groups user is member of, direct membership is flagged.
+// @Accept  json
+// @Produce json,application/x-msgpack,xml
+// @Param   id path string true "User public ID" format(uuid)
+// @Success 200 {array} api.UserGroup
+// @Failure 404 {object} common.Problem
+// @Router  /v2/users/{id}/groups [get]
+func (api *API) UserGroupsV2Handler(c *gin.Context) {
+	api = api.scoped(c)
+
+	if groups, ok := api.userGroups(c); ok {
+		respond(c, http.StatusOK, groups)
+	}
+}
+
+// @Summary Request export of all data of user by ID (administrators only)
+// @Description Archive is assembled in background, status of the export is available by URL from `Location` header.
+// @Accept  json
+// @Produce json,application/x-msgpack,xml
+// @Param   id path string true "User public ID" format(uuid)
+// @Success 202 {object} api.DataExport
+// @Failure 403 {object} common.Problem
+// @Failure 404 {object} common.Problem
+// @Router  /v2/users/{id}/data-export [post]
+func (api *API) UserDataExportV2Handler(c *gin.Context) {
+	api = api.scoped(c)
+
+	if export, ok := api.userDataExport(c); ok {
+		c.Header("Location", "/v2/exports/"+export.ID)
+		respond(c, http.StatusAccepted, export)
+	}
+}
+
+// @Summary Erase personal data of user by ID irreversibly (administrators only)
+// @Description User is deleted and anonymized, related data is purged. Unlike purged, erased user is kept for the audit log.
+// @Accept  json
+// @Produce json,application/x-msgpack,xml
+// @Param   id path string true "User public ID" format(uuid)
+// @Success 204 ""
+// @Failure 403 {object} common.Problem
+// @Failure 404 {object} common.Problem
+// @Router  /v2/users/{id}/erase [post]
+func (api *API) UserEraseV2Handler(c *gin.Context) {
+	api = api.scoped(c)
+
+	if api.eraseUser(c) {
+		respond(c, http.StatusNoContent, nil)
+	}
+}
+
+// @Summary List consents of user by ID
+// @Description Policies in force that user has not accepted are `required`.
+// @Accept  json
+// @Produce json,application/x-msgpack,xml
+// @Param   id path string true "User public ID" format(uuid)
+// @Success 200 {array} api.UserConsent
+// @Failure 404 {object} common.Problem
+// @Router  /v2/users/{id}/consents [get]
+func (api *API) UserConsentIndexV2Handler(c *gin.Context) {
+	api = api.scoped(c)
+
+	if consents, ok := api.listUserConsents(c); ok {
+		respond(c, http.StatusOK, consents)
+	}
+}
+
+// @Summary Give or withdraw consent of user by ID
+// @Description Policies are accepted in the version in force, marketing consents may be withdrawn with `granted` false.
+// @Accept  json,x-www-form-urlencoded,application/x-msgpack
+// @Produce json,application/x-msgpack,xml
+// @Param   id path string true "User public ID" format(uuid)
+// @Param   consent body api.ConsentInput true "Type of consent, version of policy"
+// @Success 200 {array} api.UserConsent
+// @Failure 400 {object} common.Problem
+// @Failure 404 {object} common.Problem
+// @Failure 422 {object} common.Problem
+// @Router  /v2/users/{id}/consents [post]
+func (api *API) UserConsentCreateV2Handler(c *gin.Context) {
+	api = api.scoped(c)
+
+	if consents, ok := api.giveUserConsent(c); ok {
+		respond(c, http.StatusOK, consents)
+	}
+}
@@ -0,0 +1,24 @@
+package api
+
+import (
+	"reflect"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/lokhman/example-users-microservice/common"
+	"gopkg.in/go-playground/validator.v8"
+)
+
+// Registers custom rules of request binding (see `validate`).
+func init() {
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		if err := v.RegisterValidation("country", countryValidation); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// Validates ISO 3166-1 alpha-2 country code or its alias in any case, the value is normalized by business logic.
+func countryValidation(v *validator.Validate, topStruct, currentStruct, field reflect.Value,
+	fieldType reflect.Type, fieldKind reflect.Kind, param string) bool {
+	return fieldKind == reflect.String && common.IsCountry(field.String())
+}
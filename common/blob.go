@@ -0,0 +1,101 @@
+package common
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Storage of binary objects (e.g. avatars) by slash-separated keys.
+// Local file system is enough for a single node, whereas multiple nodes need a shared storage (e.g. Amazon S3).
+// Some blobs are private (e.g. data exports), they are only read by the application and must not be served publicly.
+type BlobStore interface {
+	// Stores data under the key, replacing existing blob.
+	Put(key string, data []byte, contentType string) error
+
+	// Returns data of the blob.
+	Get(key string) ([]byte, error)
+
+	// Deletes all blobs with keys under the prefix (as a directory).
+	DeletePrefix(prefix string) error
+
+	// Returns URL the blob is publicly available at.
+	URL(key string) string
+}
+
+var errInvalidBlobKey = errors.New("invalid blob key")
+
+// Blob storage in the local directory, which is served by the application under `BaseURL`.
+type FileBlobStore struct {
+	Dir     string
+	BaseURL string
+}
+
+// Creates blob storage in the directory, which is created if it doesn't exist.
+func NewFileBlobStore(dir, baseURL string) (*FileBlobStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileBlobStore{Dir: dir, BaseURL: strings.TrimSuffix(baseURL, "/")}, nil
+}
+
+// Returns file path of the blob, keys must not escape the directory.
+func (s *FileBlobStore) path(key string) (string, error) {
+	key = path.Clean("/" + key)
+	if key == "/" {
+		return "", errInvalidBlobKey
+	}
+	return filepath.Join(s.Dir, filepath.FromSlash(key)), nil
+}
+
+func (s *FileBlobStore) Put(key string, data []byte, contentType string) error {
+	name, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+
+	// written to a temporary file first, so readers never see a partial blob
+	f, err := ioutil.TempFile(filepath.Dir(name), ".blob-")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(f.Name()) }()
+
+	if _, err = f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(f.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
+
+func (s *FileBlobStore) Get(key string) ([]byte, error) {
+	name, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(name)
+}
+
+func (s *FileBlobStore) DeletePrefix(prefix string) error {
+	name, err := s.path(prefix)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(name)
+}
+
+func (s *FileBlobStore) URL(key string) string {
+	return s.BaseURL + path.Clean("/"+key)
+}
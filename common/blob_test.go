@@ -0,0 +1,44 @@
+// +build !integration
+
+// Run this simple unit test with the following command:
+// $ docker-compose run app go test -v ./...
+
+package common
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileBlobStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blobs")
+	assert.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	s, err := NewFileBlobStore(dir, "/blobs/")
+	assert.NoError(t, err)
+
+	assert.NoError(t, s.Put("avatars/1/64.png", []byte("data"), "image/png"))
+	data, err := ioutil.ReadFile(filepath.Join(dir, "avatars", "1", "64.png"))
+	assert.NoError(t, err)
+	assert.Equal(t, "data", string(data))
+	assert.Equal(t, "/blobs/avatars/1/64.png", s.URL("avatars/1/64.png"))
+
+	data, err = s.Get("avatars/1/64.png")
+	assert.NoError(t, err)
+	assert.Equal(t, "data", string(data))
+
+	// keys cannot escape the directory
+	assert.NoError(t, s.Put("../outside", []byte("data"), "text/plain"))
+	_, err = os.Stat(filepath.Join(dir, "outside"))
+	assert.NoError(t, err)
+	assert.Error(t, s.Put("..", nil, "text/plain"))
+
+	assert.NoError(t, s.DeletePrefix("avatars/1"))
+	_, err = os.Stat(filepath.Join(dir, "avatars", "1"))
+	assert.True(t, os.IsNotExist(err))
+}
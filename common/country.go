@@ -0,0 +1,81 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ISO 3166-1 country.
+type Country struct {
+	Alpha2  string
+	Alpha3  string
+	Numeric string
+	Name    string
+}
+
+// Countries by alpha-2 code.
+var countries = make(map[string]Country, len(countryList))
+
+func init() {
+	for _, c := range countryList {
+		countries[c.Alpha2] = c
+	}
+}
+
+// Codes that are commonly used instead of ISO 3166-1 alpha-2 codes, e.g. "UK" for United Kingdom.
+// Aliases are replaced with the codes on normalization, the map can be changed on startup.
+var CountryAliases = map[string]string{
+	"UK": "GB",
+	"EL": "GR",
+}
+
+// Returns all ISO 3166-1 countries ordered by alpha-2 code.
+func Countries() []Country {
+	return countryList
+}
+
+// Finds country by alpha-2 code (case-sensitive).
+func LookupCountry(code string) (Country, bool) {
+	c, ok := countries[code]
+	return c, ok
+}
+
+// Normalizes country code to upper case and replaces alias with ISO 3166-1 alpha-2 code.
+// Code is returned as is (in upper case) if it is unknown.
+func NormalizeCountry(code string) string {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if alias, ok := CountryAliases[code]; ok {
+		return alias
+	}
+	return code
+}
+
+// Checks if code (or its alias) is a known country in any case.
+func IsCountry(code string) bool {
+	_, ok := countries[NormalizeCountry(code)]
+	return ok
+}
+
+// Parses aliases of country codes from "ALIAS=CODE" pairs separated by comma, e.g. "UK=GB,EL=GR".
+// Codes must be known and aliases must not be codes themselves.
+func ParseCountryAliases(s string) (map[string]string, error) {
+	aliases := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("country alias %q must be in ALIAS=CODE format", pair)
+		}
+		alias, code := strings.ToUpper(strings.TrimSpace(parts[0])), strings.ToUpper(strings.TrimSpace(parts[1]))
+		if _, ok := countries[code]; !ok {
+			return nil, fmt.Errorf("country alias %q refers to unknown code", pair)
+		}
+		if _, ok := countries[alias]; ok {
+			return nil, fmt.Errorf("country alias %q shadows existing code", pair)
+		}
+		aliases[alias] = code
+	}
+	return aliases, nil
+}
@@ -0,0 +1,48 @@
+// +build !integration
+
+// Run this simple unit test with the following command:
+// $ docker-compose run app go test -v ./...
+
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCountries(t *testing.T) {
+	assert.Len(t, Countries(), 249)
+
+	c, ok := LookupCountry("GB")
+	assert.True(t, ok)
+	assert.Equal(t, "GBR", c.Alpha3)
+	assert.Equal(t, "826", c.Numeric)
+
+	_, ok = LookupCountry("ZZ")
+	assert.False(t, ok)
+}
+
+func TestNormalizeCountry(t *testing.T) {
+	assert.Equal(t, "RU", NormalizeCountry("ru"))
+	assert.Equal(t, "GB", NormalizeCountry("uk"))
+	assert.Equal(t, "ZZ", NormalizeCountry(" zz "))
+
+	assert.True(t, IsCountry("ru"))
+	assert.True(t, IsCountry("UK"))
+	assert.False(t, IsCountry("ZZ"))
+	assert.False(t, IsCountry(""))
+}
+
+func TestParseCountryAliases(t *testing.T) {
+	aliases, err := ParseCountryAliases("uk=gb, EL=GR,")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"UK": "GB", "EL": "GR"}, aliases)
+
+	_, err = ParseCountryAliases("UK")
+	assert.Error(t, err)
+	_, err = ParseCountryAliases("UK=ZZ")
+	assert.Error(t, err)
+	_, err = ParseCountryAliases("RU=GB")
+	assert.Error(t, err)
+}
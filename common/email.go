@@ -0,0 +1,73 @@
+package common
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+var ErrInvalidEmail = errors.New("email is invalid")
+
+// Applies rules of Gmail to the local part (dots and "+tag" suffix are ignored), the flag can be changed on startup.
+// It is disabled by default, as such addresses are distinct for any other provider.
+var EmailProviderRules = false
+
+// Returns version of the rules applied by `NormalizeEmail`, it is stored along with normalized emails,
+// so they are normalized again when the rules are changed.
+func EmailRulesVersion() int {
+	if EmailProviderRules {
+		return 2
+	}
+	return 1
+}
+
+// Domains that are delivered to Gmail mailboxes, the first one is canonical.
+var gmailDomains = []string{"gmail.com", "googlemail.com"}
+
+// Domain of tombstone emails, ".invalid" is reserved (RFC 2606), so they are never delivered.
+const tombstoneDomain = "erased.invalid"
+
+// Returns tombstone of the normalized email of erased user: HMAC-SHA256 of the email with the secret key at the reserved domain.
+// The same email always gives the same tombstone (e.g. for suppression lists), but without the key emails can't be
+// guessed from tombstones by hashing candidates, so the key must be kept secret and never changed.
+func EmailTombstone(key []byte, email string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(email))
+	return hex.EncodeToString(mac.Sum(nil)) + "@" + tombstoneDomain
+}
+
+// Normalizes email for uniqueness: trimmed, in lower case and with internationalized domain in ASCII (punycode).
+// Local part is kept as is apart from the case, unless `EmailProviderRules` is enabled.
+func NormalizeEmail(email string) (string, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	at := strings.LastIndexByte(email, '@')
+	if at <= 0 || at == len(email)-1 {
+		return "", ErrInvalidEmail
+	}
+
+	local, domain := email[:at], strings.TrimSuffix(email[at+1:], ".")
+	domain, err := idna.Lookup.ToASCII(domain)
+	if err != nil {
+		return "", ErrInvalidEmail
+	}
+
+	if EmailProviderRules {
+		for _, d := range gmailDomains {
+			if domain == d {
+				if plus := strings.IndexByte(local, '+'); plus >= 0 {
+					local = local[:plus]
+				}
+				local, domain = strings.Replace(local, ".", "", -1), gmailDomains[0]
+				break
+			}
+		}
+		if local == "" {
+			return "", ErrInvalidEmail
+		}
+	}
+	return local + "@" + domain, nil
+}
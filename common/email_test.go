@@ -0,0 +1,75 @@
+// +build !integration
+
+// Run this simple unit test with the following command:
+// $ docker-compose run app go test -v ./...
+
+package common
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeEmail(t *testing.T) {
+	email, err := NormalizeEmail(" Alex@Gmail.COM ")
+	assert.NoError(t, err)
+	assert.Equal(t, "alex@gmail.com", email)
+
+	// internationalized domain is stored in punycode
+	email, err = NormalizeEmail("Info@Bücher.de")
+	assert.NoError(t, err)
+	assert.Equal(t, "info@xn--bcher-kva.de", email)
+
+	email, err = NormalizeEmail("a.lex+news@googlemail.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "a.lex+news@googlemail.com", email)
+
+	for _, in := range []string{"", "alex", "@gmail.com", "alex@", "alex@exa mple.com"} {
+		_, err = NormalizeEmail(in)
+		assert.Equal(t, ErrInvalidEmail, err, in)
+	}
+}
+
+func TestNormalizeEmailProviderRules(t *testing.T) {
+	version := EmailRulesVersion()
+	EmailProviderRules = true
+	defer func() { EmailProviderRules = false }()
+
+	// emails are normalized again when the rules are changed
+	assert.NotEqual(t, version, EmailRulesVersion())
+
+	email, err := NormalizeEmail("A.Lex+news@googlemail.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "alex@gmail.com", email)
+
+	// other providers are not affected
+	email, err = NormalizeEmail("a.lex+news@example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "a.lex+news@example.com", email)
+
+	_, err = NormalizeEmail("+news@gmail.com")
+	assert.Equal(t, ErrInvalidEmail, err)
+}
+
+func TestEmailTombstone(t *testing.T) {
+	key := []byte("secret")
+	tombstone := EmailTombstone(key, "alex@gmail.com")
+	assert.Equal(t, tombstone, EmailTombstone(key, "alex@gmail.com"))
+	assert.NotEqual(t, tombstone, EmailTombstone(key, "alex@googlemail.com"))
+	assert.NotEqual(t, tombstone, EmailTombstone([]byte("other"), "alex@gmail.com"))
+	assert.NotContains(t, tombstone, "alex")
+
+	// test if tombstone is not a plain hash of the email
+	sum := sha256.Sum256([]byte("alex@gmail.com"))
+	assert.NotContains(t, tombstone, hex.EncodeToString(sum[:]))
+	assert.True(t, strings.HasSuffix(tombstone, "@erased.invalid"))
+
+	// tombstone is a valid normalized email itself
+	email, err := NormalizeEmail(tombstone)
+	assert.NoError(t, err)
+	assert.Equal(t, tombstone, email)
+}
@@ -1,10 +1,190 @@
 package common
 
-// Error which nicely translates in the HTTP response.
-type HTTPError struct {
-	Err string `json:"error" example:"Error message"`
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/go-playground/validator.v8"
+)
+
+// Media types of RFC 7807 problem details.
+const (
+	ProblemContentType    = "application/problem+json"
+	ProblemXMLContentType = "application/problem+xml"
+)
+
+// Stable machine-readable error codes.
+const (
+	ErrCodeInternal                  = "internal_error"
+	ErrCodeNotFound                  = "not_found"
+	ErrCodeMethodNotAllowed          = "method_not_allowed"
+	ErrCodeForbidden                 = "forbidden"
+	ErrCodeNotAcceptable             = "not_acceptable"
+	ErrCodeUnsupportedMediaType      = "unsupported_media_type"
+	ErrCodeInvalidRequest            = "invalid_request"
+	ErrCodeInvalidQuery              = "invalid_query"
+	ErrCodeValidationFailed          = "validation_failed"
+	ErrCodeInvalidUserID             = "invalid_user_id"
+	ErrCodeUserNotFound              = "user_not_found"
+	ErrCodeUserErased                = "user_erased"
+	ErrCodeOrganizationNotFound      = "organization_not_found"
+	ErrCodeOrganizationRequired      = "organization_required"
+	ErrCodeGroupNotFound             = "group_not_found"
+	ErrCodeInvalidGroupParent        = "invalid_group_parent"
+	ErrCodeNotOrganizationMember     = "not_organization_member"
+	ErrCodeInvitationNotFound        = "invitation_not_found"
+	ErrCodeInvalidInvitation         = "invalid_invitation"
+	ErrCodeInvitationExists          = "invitation_exists"
+	ErrCodeInvitationNotPending      = "invitation_not_pending"
+	ErrCodeDataExportNotFound        = "data_export_not_found"
+	ErrCodeDataExportNotAvailable    = "data_export_not_available"
+	ErrCodePolicyVersionExists       = "policy_version_exists"
+	ErrCodeConsentRequired           = "consent_required"
+	ErrCodeEmailExists               = "email_exists"
+	ErrCodeNicknameExists            = "nickname_exists"
+	ErrCodePhoneNotSet               = "phone_not_set"
+	ErrCodePhoneVerified             = "phone_verified"
+	ErrCodeInvalidVerificationCode   = "invalid_verification_code"
+	ErrCodeVerificationTooSoon       = "verification_too_soon"
+	ErrCodeInvalidCredentials        = "invalid_credentials"
+	ErrCodeUserPending               = "user_pending"
+	ErrCodeUserSuspended             = "user_suspended"
+	ErrCodeUserBanned                = "user_banned"
+	ErrCodeStatusTransition          = "invalid_status_transition"
+	ErrCodeInvalidImage              = "invalid_image"
+	ErrCodeAvatarTooLarge            = "avatar_too_large"
+	ErrCodeAttributeSchemaNotFound   = "attribute_schema_not_found"
+	ErrCodeInvalidAttributeNamespace = "invalid_attribute_namespace"
+	ErrCodeInvalidAttributeSchema    = "invalid_attribute_schema"
+	ErrCodeInvalidCursor             = "invalid_cursor"
+	ErrCodeIdempotencyKey            = "invalid_idempotency_key"
+	ErrCodeIdempotencyReused         = "idempotency_key_reused"
+	ErrCodeIdempotencyConflict       = "idempotency_key_in_progress"
+)
+
+// Error of a single invalid input field.
+type FieldError struct {
+	Field   string `json:"field" xml:"field" example:"email"`
+	Rule    string `json:"rule" xml:"rule" example:"max"`
+	Param   string `json:"param,omitempty" xml:"param,omitempty" example:"128"`
+	Message string `json:"message" xml:"message" example:"Email must be at most 128 characters long"`
+}
+
+// Error which nicely translates in the HTTP response (RFC 7807 problem details).
+type Problem struct {
+	XMLName  xml.Name     `json:"-" xml:"urn:ietf:rfc:7807 problem"`
+	Type     string       `json:"type" xml:"type" example:"/problems/user_not_found"`
+	Title    string       `json:"title" xml:"title" example:"Not Found"`
+	Status   int          `json:"status" xml:"status" example:"404"`
+	Detail   string       `json:"detail,omitempty" xml:"detail,omitempty" example:"User cannot be found"`
+	Instance string       `json:"instance,omitempty" xml:"instance,omitempty" example:"/users/1"`
+	Code     string       `json:"code" xml:"code" example:"user_not_found"`
+	Errors   []FieldError `json:"errors,omitempty" xml:"errors>error,omitempty"`
+
+	// parameters of the detail message for localization
+	params map[string]string
+}
+
+// Creates new problem with HTTP status, error code and human-readable detail.
+func NewProblem(status int, code, detail string) Problem {
+	return Problem{
+		Type:   "/problems/" + code,
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+// Returns copy of the problem with detail message parameter.
+func (p Problem) WithParam(name, value string) Problem {
+	params := make(map[string]string, len(p.params)+1)
+	for k, v := range p.params {
+		params[k] = v
+	}
+	params[name] = value
+	p.params = params
+	return p
+}
+
+// Returns parameters of the detail message.
+func (p Problem) Params() map[string]string {
+	return p.params
+}
+
+func (p Problem) Error() string {
+	return p.Detail
+}
+
+// Checks if error is the problem with the error code.
+func IsProblem(err error, code string) bool {
+	p, ok := err.(Problem)
+	return ok && p.Code == code
+}
+
+// Converts request binding error to the problem.
+// Validation errors are reported per field using JSON names from the input structure.
+func BindingProblem(err error, in interface{}) Problem {
+	errs, ok := err.(validator.ValidationErrors)
+	if !ok {
+		return NewProblem(http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+	}
+
+	p := NewProblem(http.StatusUnprocessableEntity, ErrCodeValidationFailed, "Request contains invalid fields")
+	for _, fe := range errs {
+		field := jsonFieldName(reflect.TypeOf(in), fe.FieldNamespace)
+		p.Errors = append(p.Errors, FieldError{
+			Field:   field,
+			Rule:    fe.Tag,
+			Param:   fe.Param,
+			Message: fmt.Sprintf("%s failed on the '%s' rule", field, fe.Tag),
+		})
+	}
+
+	// validation errors come in a map, so order them for stable responses
+	sort.Slice(p.Errors, func(i, j int) bool {
+		return p.Errors[i].Field < p.Errors[j].Field
+	})
+	return p
 }
 
-func (e HTTPError) Error() string {
-	return e.Err
+// Creates validation problem of a single field, e.g. when the rule cannot be expressed by struct tags.
+func FieldProblem(field, rule, param string) Problem {
+	p := NewProblem(http.StatusUnprocessableEntity, ErrCodeValidationFailed, "Request contains invalid fields")
+	p.Errors = []FieldError{{
+		Field:   field,
+		Rule:    rule,
+		Param:   param,
+		Message: fmt.Sprintf("%s failed on the '%s' rule", field, rule),
+	}}
+	return p
+}
+
+// Resolves struct field namespace (e.g. "UserInput.Email") to JSON path (e.g. "email").
+func jsonFieldName(t reflect.Type, namespace string) string {
+	parts := strings.Split(namespace, ".")
+	names := make([]string, 0, len(parts))
+	for _, part := range parts[1:] {
+		for t != nil && (t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Map) {
+			t = t.Elem()
+		}
+
+		name := part
+		if t != nil && t.Kind() == reflect.Struct {
+			if f, ok := t.FieldByName(part); ok {
+				if tag := strings.Split(f.Tag.Get("json"), ",")[0]; tag != "" && tag != "-" {
+					name = tag
+				}
+				t = f.Type
+			} else {
+				t = nil
+			}
+		}
+		names = append(names, name)
+	}
+	return strings.Join(names, ".")
 }
@@ -0,0 +1,38 @@
+// +build !integration
+
+package common
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/go-playground/validator.v8"
+)
+
+type mockInput struct {
+	Email   string `json:"email"`
+	Address struct {
+		Country string `json:"country"`
+	} `json:"address"`
+}
+
+func TestBindingProblem(t *testing.T) {
+	p := BindingProblem(errors.New("unexpected EOF"), &mockInput{})
+	assert.Equal(t, http.StatusBadRequest, p.Status)
+	assert.Equal(t, ErrCodeInvalidRequest, p.Code)
+	assert.Empty(t, p.Errors)
+
+	p = BindingProblem(validator.ValidationErrors{
+		"mockInput.Email":           {FieldNamespace: "mockInput.Email", Field: "Email", Tag: "email"},
+		"mockInput.Address.Country": {FieldNamespace: "mockInput.Address.Country", Field: "Country", Tag: "len", Param: "2"},
+	}, &mockInput{})
+	assert.Equal(t, http.StatusUnprocessableEntity, p.Status)
+	assert.Equal(t, ErrCodeValidationFailed, p.Code)
+	assert.Equal(t, "/problems/"+ErrCodeValidationFailed, p.Type)
+	assert.Equal(t, []FieldError{
+		{Field: "address.country", Rule: "len", Param: "2", Message: "address.country failed on the 'len' rule"},
+		{Field: "email", Rule: "email", Message: "email failed on the 'email' rule"},
+	}, p.Errors)
+}
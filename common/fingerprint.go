@@ -0,0 +1,23 @@
+package common
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// Calculates SHA-256 fingerprint of the request by its method, path, content type, accepted media types and body.
+// Representations are negotiated by headers, so the same body sent or accepted as another type is another request.
+func RequestFingerprint(method, path, contentType, accept string, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(method + " " + path + "\n"))
+	h.Write([]byte(contentType + "\n" + accept + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Calculates SHA-256 of the key in scope of the caller, so keys chosen by different callers never collide.
+func ScopedKey(caller, key string) string {
+	h := sha256.New()
+	h.Write([]byte(caller + "\n" + key))
+	return hex.EncodeToString(h.Sum(nil))
+}
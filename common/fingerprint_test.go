@@ -0,0 +1,27 @@
+// +build !integration
+
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestFingerprint(t *testing.T) {
+	fp := RequestFingerprint("POST", "/users", "application/json", "", []byte(`{"email":"alex.lokhman@gmail.com"}`))
+	assert.Len(t, fp, 64)
+	assert.Equal(t, fp, RequestFingerprint("POST", "/users", "application/json", "", []byte(`{"email":"alex.lokhman@gmail.com"}`)))
+	assert.NotEqual(t, fp, RequestFingerprint("POST", "/users", "application/json", "", []byte(`{"email":"alex@gmail.com"}`)))
+	assert.NotEqual(t, fp, RequestFingerprint("PUT", "/users", "application/json", "", []byte(`{"email":"alex.lokhman@gmail.com"}`)))
+	assert.NotEqual(t, fp, RequestFingerprint("POST", "/users", "application/x-msgpack", "", []byte(`{"email":"alex.lokhman@gmail.com"}`)))
+	assert.NotEqual(t, fp, RequestFingerprint("POST", "/users", "application/json", "application/xml", []byte(`{"email":"alex.lokhman@gmail.com"}`)))
+}
+
+func TestScopedKey(t *testing.T) {
+	key := ScopedKey("ip:127.0.0.1", "key")
+	assert.Len(t, key, 64)
+	assert.Equal(t, key, ScopedKey("ip:127.0.0.1", "key"))
+	assert.NotEqual(t, key, ScopedKey("ip:127.0.0.2", "key"))
+	assert.NotEqual(t, key, ScopedKey("ip:127.0.0.1", "other"))
+}
@@ -13,3 +13,8 @@ func MustHashPassword(password string) string {
 	}
 	return string(hash)
 }
+
+// Checks if password matches the hash.
+func CheckPassword(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
@@ -0,0 +1,80 @@
+// +build !integration
+
+// Run this simple unit test with the following command:
+// $ docker-compose run app go test -v ./...
+
+package common
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Builds minimal JPEG header with EXIF orientation in the given byte order.
+func jpegWithOrientation(bigEndian bool, orientation byte) []byte {
+	tiff := []byte{'I', 'I', 42, 0, 8, 0, 0, 0, 1, 0, 0x12, 0x01, 3, 0, 1, 0, 0, 0, orientation, 0, 0, 0, 0, 0, 0, 0}
+	if bigEndian {
+		tiff = []byte{'M', 'M', 0, 42, 0, 0, 0, 8, 0, 1, 0x01, 0x12, 0, 3, 0, 0, 0, 1, 0, orientation, 0, 0, 0, 0, 0, 0}
+	}
+	seg := append([]byte("Exif\x00\x00"), tiff...)
+	n := len(seg) + 2
+
+	data := []byte{0xFF, 0xD8, 0xFF, 0xE1, byte(n >> 8), byte(n)}
+	data = append(data, seg...)
+	return append(data, 0xFF, 0xDA, 0, 2)
+}
+
+func TestJPEGOrientation(t *testing.T) {
+	assert.Equal(t, 6, JPEGOrientation(jpegWithOrientation(false, 6)))
+	assert.Equal(t, 8, JPEGOrientation(jpegWithOrientation(true, 8)))
+	assert.Equal(t, 1, JPEGOrientation(jpegWithOrientation(false, 9)))
+	assert.Equal(t, 1, JPEGOrientation([]byte{0xFF, 0xD8, 0xFF, 0xDA, 0, 2}))
+	assert.Equal(t, 1, JPEGOrientation([]byte("\x89PNG")))
+
+	// truncated segment
+	assert.Equal(t, 1, JPEGOrientation(jpegWithOrientation(false, 6)[:20]))
+}
+
+func TestOrientImage(t *testing.T) {
+	// 2x1 image: red, blue
+	img := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	red, blue := color.RGBA{255, 0, 0, 255}, color.RGBA{0, 0, 255, 255}
+	img.Set(0, 0, red)
+	img.Set(1, 0, blue)
+
+	assert.Equal(t, img, OrientImage(img, 1))
+
+	flipped := OrientImage(img, 2)
+	assert.Equal(t, blue, flipped.At(0, 0))
+	assert.Equal(t, red, flipped.At(1, 0))
+
+	// rotated clockwise: red on top
+	rotated := OrientImage(img, 6)
+	assert.Equal(t, image.Rect(0, 0, 1, 2), rotated.Bounds())
+	assert.Equal(t, red, rotated.At(0, 0))
+	assert.Equal(t, blue, rotated.At(0, 1))
+
+	// rotated counterclockwise: blue on top
+	rotated = OrientImage(img, 8)
+	assert.Equal(t, blue, rotated.At(0, 0))
+	assert.Equal(t, red, rotated.At(0, 1))
+}
+
+func TestSquareThumbnail(t *testing.T) {
+	// wide image with the red center
+	img := image.NewRGBA(image.Rect(0, 0, 300, 100))
+	for y := 0; y < 100; y++ {
+		for x := 100; x < 200; x++ {
+			img.Set(x, y, color.RGBA{255, 0, 0, 255})
+		}
+	}
+
+	thumb := SquareThumbnail(img, 32)
+	assert.Equal(t, image.Rect(0, 0, 32, 32), thumb.Bounds())
+	assert.Equal(t, color.RGBA{255, 0, 0, 255}, thumb.At(16, 16))
+	assert.True(t, IsOpaqueImage(thumb))
+	assert.False(t, IsOpaqueImage(image.NewRGBA(image.Rect(0, 0, 1, 1))))
+}
@@ -0,0 +1,78 @@
+package common
+
+import (
+	"log"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"sync"
+)
+
+// Sender of emails.
+// Implementations for mail services (e.g. Amazon SES) can be plugged in instead of `SMTPMailer` or `MemoryMailer`.
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// Email sent to the address.
+type Mail struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer that sends plain text emails via SMTP server, with PLAIN authentication if username is set.
+type SMTPMailer struct {
+	Addr     string
+	Username string
+	Password string
+	From     string
+}
+
+func (m *SMTPMailer) Send(to, subject, body string) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		host, _, _ := net.SplitHostPort(m.Addr)
+		auth = smtp.PlainAuth("", m.Username, m.Password, host)
+	}
+	return smtp.SendMail(m.Addr, auth, m.From, []string{to}, smtpMessage(m.From, to, subject, body))
+}
+
+// Formats plain text email message with UTF-8 subject and CRLF line endings.
+func smtpMessage(from, to, subject, body string) []byte {
+	header := "From: " + from + "\r\n" +
+		"To: " + to + "\r\n" +
+		"Subject: " + mime.QEncoding.Encode("utf-8", subject) + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n\r\n"
+	return []byte(header + strings.Replace(body, "\n", "\r\n", -1))
+}
+
+// Mailer that logs emails and keeps them in memory instead of sending, for development and tests.
+type MemoryMailer struct {
+	mu    sync.Mutex
+	mails []Mail
+}
+
+func (m *MemoryMailer) Send(to, subject, body string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.mails = append(m.mails, Mail{To: to, Subject: subject, Body: body})
+	log.Printf("[mail] email to %s: %s\n%s", to, subject, body)
+	return nil
+}
+
+// Returns the last email sent to the address.
+func (m *MemoryMailer) LastMail(to string) (Mail, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := len(m.mails) - 1; i >= 0; i-- {
+		if m.mails[i].To == to {
+			return m.mails[i], true
+		}
+	}
+	return Mail{}, false
+}
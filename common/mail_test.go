@@ -0,0 +1,32 @@
+// +build !integration
+
+// Run this simple unit test with the following command:
+// $ docker-compose run app go test -v ./...
+
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSMTPMessage(t *testing.T) {
+	msg := string(smtpMessage("noreply@example.com", "alex@example.com", "Привет", "Hello,\nworld"))
+	assert.Contains(t, msg, "From: noreply@example.com\r\nTo: alex@example.com\r\n")
+	assert.Contains(t, msg, "Subject: =?utf-8?q?")
+	assert.Contains(t, msg, "Content-Type: text/plain; charset=utf-8\r\n\r\nHello,\r\nworld")
+}
+
+func TestMemoryMailer(t *testing.T) {
+	m := &MemoryMailer{}
+	_ = m.Send("alex@example.com", "First", "1")
+	_ = m.Send("alex@example.com", "Second", "2")
+
+	mail, ok := m.LastMail("alex@example.com")
+	assert.True(t, ok)
+	assert.Equal(t, "Second", mail.Subject)
+
+	_, ok = m.LastMail("other@example.com")
+	assert.False(t, ok)
+}
@@ -0,0 +1,78 @@
+package common
+
+import (
+	"math/rand"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Nicknames (in lower case) that users cannot take, as they may be mistaken for the staff or the service.
+// The list can be replaced on startup.
+var ReservedNicknames = map[string]bool{
+	"admin":         true,
+	"administrator": true,
+	"root":          true,
+	"system":        true,
+	"support":       true,
+	"help":          true,
+	"staff":         true,
+	"moderator":     true,
+	"official":      true,
+	"security":      true,
+	"api":           true,
+	"null":          true,
+	"undefined":     true,
+}
+
+// Checks if nickname is reserved in any case.
+func IsReservedNickname(nickname string) bool {
+	return ReservedNicknames[strings.ToLower(strings.TrimSpace(nickname))]
+}
+
+// Parses reserved nicknames separated by comma, e.g. "admin,root,support".
+func ParseReservedNicknames(s string) map[string]bool {
+	reserved := make(map[string]bool)
+	for _, nickname := range strings.Split(s, ",") {
+		if nickname = strings.ToLower(strings.TrimSpace(nickname)); nickname != "" {
+			reserved[nickname] = true
+		}
+	}
+	return reserved
+}
+
+// Generates up to `n` distinct variants of the nickname with random numeric suffixes (e.g. "VisioN42", "VisioN_815").
+// Nickname is truncated, so variants are at most `maxLen` characters long.
+func NicknameVariants(nickname string, n, maxLen int) []string {
+	nickname = strings.TrimSpace(nickname)
+
+	seen := make(map[string]bool, n)
+	variants := make([]string, 0, n)
+	for i := 0; i < n*2 && len(variants) < n; i++ {
+		// suffixes get longer (2 to 4 digits), as short ones are more likely taken
+		digits := 2 + i*3/(n*2)
+		suffix := strconv.Itoa(pow10(digits-1) + rand.Intn(9*pow10(digits-1)))
+		if i%2 == 1 {
+			suffix = "_" + suffix
+		}
+
+		base := nickname
+		for utf8.RuneCountInString(base)+len(suffix) > maxLen {
+			_, size := utf8.DecodeLastRuneInString(base)
+			base = base[:len(base)-size]
+		}
+		if variant := base + suffix; !seen[variant] {
+			seen[variant] = true
+			variants = append(variants, variant)
+		}
+	}
+	return variants
+}
+
+func pow10(n int) int {
+	p := 1
+	for ; n > 0; n-- {
+		p *= 10
+	}
+	return p
+}
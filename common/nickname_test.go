@@ -0,0 +1,40 @@
+// +build !integration
+
+// Run this simple unit test with the following command:
+// $ docker-compose run app go test -v ./...
+
+package common
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsReservedNickname(t *testing.T) {
+	assert.True(t, IsReservedNickname("admin"))
+	assert.True(t, IsReservedNickname(" Admin "))
+	assert.False(t, IsReservedNickname("VisioN"))
+}
+
+func TestParseReservedNicknames(t *testing.T) {
+	assert.Equal(t, map[string]bool{"admin": true, "root": true}, ParseReservedNicknames(" Admin,,root "))
+	assert.Empty(t, ParseReservedNicknames(""))
+}
+
+func TestNicknameVariants(t *testing.T) {
+	variants := NicknameVariants("VisioN", 5, 32)
+	assert.Len(t, variants, 5)
+	for _, v := range variants {
+		assert.Regexp(t, regexp.MustCompile(`^VisioN_?\d{2,4}$`), v)
+	}
+
+	// long nickname is truncated by characters
+	for _, v := range NicknameVariants(strings.Repeat("Ж", 32), 3, 32) {
+		assert.True(t, utf8.RuneCountInString(v) <= 32, v)
+		assert.True(t, utf8.ValidString(v), v)
+	}
+}
@@ -0,0 +1,89 @@
+package common
+
+import (
+	"errors"
+	"strings"
+)
+
+var ErrInvalidPhone = errors.New("phone number is invalid")
+
+// Limits of E.164 number length in digits (including country calling code).
+const (
+	phoneMinDigits = 7
+	phoneMaxDigits = 15
+)
+
+// National (trunk) prefixes that are dialled before national numbers, "0" is used if the country is not listed.
+// Numbers in countries without the prefix (e.g. Italy) may start with zero, so it is kept.
+var trunkPrefixes = map[string]string{
+	"BY": "8",
+	"HU": "06",
+	"IT": "",
+	"KZ": "8",
+	"LT": "8",
+	"RU": "8",
+	"SM": "",
+	"VA": "",
+}
+
+// Known country calling codes, used to check international numbers.
+var callingCodes = make(map[string]bool)
+
+func init() {
+	for _, code := range countryCallingCodes {
+		callingCodes[code] = true
+	}
+}
+
+// Normalizes phone number to E.164 format (e.g. "+79161234567").
+// International numbers start with "+" or "00", national ones are resolved with the default region (ISO 3166-1
+// alpha-2 country code). Spaces, dashes, dots and parentheses are ignored. Length and country calling code are
+// checked, but not the numbering plan of the country, so the number may still not exist.
+func NormalizePhone(phone, defaultRegion string) (string, error) {
+	phone = strings.TrimSpace(phone)
+	international := strings.HasPrefix(phone, "+")
+	phone = strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '-', '.', '(', ')', '/':
+			return -1
+		}
+		return r
+	}, strings.TrimPrefix(phone, "+"))
+	if phone == "" || strings.Trim(phone, "0123456789") != "" {
+		return "", ErrInvalidPhone
+	}
+
+	if !international && strings.HasPrefix(phone, "00") {
+		phone, international = phone[2:], true
+	}
+	if !international {
+		region := NormalizeCountry(defaultRegion)
+		code, ok := countryCallingCodes[region]
+		if !ok {
+			return "", ErrInvalidPhone
+		}
+		trunk, ok := trunkPrefixes[region]
+		if !ok {
+			trunk = "0"
+		}
+		if code == "1" {
+			trunk = "1"
+		}
+		phone = code + strings.TrimPrefix(phone, trunk)
+	}
+
+	if len(phone) < phoneMinDigits || len(phone) > phoneMaxDigits || !hasCallingCode(phone) {
+		return "", ErrInvalidPhone
+	}
+	return "+" + phone, nil
+}
+
+// Checks if digits of international number start with a known country calling code (1 to 3 digits).
+func hasCallingCode(digits string) bool {
+	for n := 1; n <= 3 && n < len(digits); n++ {
+		if callingCodes[digits[:n]] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+// +build !integration
+
+// Run this simple unit test with the following command:
+// $ docker-compose run app go test -v ./...
+
+package common
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizePhone(t *testing.T) {
+	for _, tc := range []struct{ in, region, out string }{
+		{"+7 (916) 123-45-67", "", "+79161234567"},
+		{"0044 20 7946 0958", "RU", "+442079460958"},
+		{"8 916 123 45 67", "RU", "+79161234567"},
+		{"020 7946 0958", "gb", "+442079460958"},
+		{"07700 900123", "UK", "+447700900123"},
+		{"(202) 555-0143", "US", "+12025550143"},
+		{"1-202-555-0143", "CA", "+12025550143"},
+		{"06 30 123 4567", "HU", "+36301234567"},
+		{"06 1234 5678", "IT", "+390612345678"},
+		{"030 123456", "DE", "+4930123456"},
+	} {
+		phone, err := NormalizePhone(tc.in, tc.region)
+		assert.NoError(t, err, tc.in)
+		assert.Equal(t, tc.out, phone, tc.in)
+	}
+
+	for _, tc := range []struct{ in, region string }{
+		{"", "RU"},
+		{"+", ""},
+		{"916 123 45 67", ""},
+		{"916 123 45 67", "ZZ"},
+		{"+7 916 CALL ME", ""},
+		{"+7 916+123", ""},
+		{"+1234", ""},
+		{"+7916123456789012", ""},
+		{"+0916123456", ""},
+	} {
+		_, err := NormalizePhone(tc.in, tc.region)
+		assert.Equal(t, ErrInvalidPhone, err, tc.in)
+	}
+}
+
+func TestMemorySMSSender(t *testing.T) {
+	s := &MemorySMSSender{}
+	assert.NoError(t, s.Send("+79161234567", "first"))
+	assert.NoError(t, s.Send("+442079460958", "other"))
+	assert.NoError(t, s.Send("+79161234567", "second"))
+
+	m, ok := s.LastMessage("+79161234567")
+	assert.True(t, ok)
+	assert.Equal(t, "second", m.Text)
+
+	_, ok = s.LastMessage("+12025550143")
+	assert.False(t, ok)
+
+	// test if only the last messages are kept
+	for i := 0; i < memorySMSLimit; i++ {
+		assert.NoError(t, s.Send(fmt.Sprintf("+1202555%04d", i), "text"))
+	}
+	assert.Len(t, s.messages, memorySMSLimit)
+	_, ok = s.LastMessage("+79161234567")
+	assert.False(t, ok)
+}
+
+func TestTwilioSMSSender(t *testing.T) {
+	var form map[string][]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, _ := r.BasicAuth()
+		if r.URL.Path != "/2010-04-01/Accounts/AC123/Messages.json" || user != "AC123" || pass != "secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		_ = r.ParseForm()
+		form = r.PostForm
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	s := &TwilioSMSSender{AccountSID: "AC123", AuthToken: "secret", From: "+15005550006", BaseURL: srv.URL}
+	assert.NoError(t, s.Send("+79161234567", "Your verification code is 123456"))
+	assert.Equal(t, []string{"+79161234567"}, form["To"])
+	assert.Equal(t, []string{"+15005550006"}, form["From"])
+	assert.Equal(t, []string{"Your verification code is 123456"}, form["Body"])
+
+	s.AuthToken = "wrong"
+	assert.Error(t, s.Send("+79161234567", "text"))
+}
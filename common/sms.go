@@ -0,0 +1,102 @@
+package common
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Sender of text messages to phone numbers in E.164 format.
+// Implementations for other SMS gateways can be plugged in instead of `TwilioSMSSender` or `MemorySMSSender`.
+type SMSSender interface {
+	Send(to, text string) error
+}
+
+// Text message sent to the phone number.
+type SMSMessage struct {
+	To   string
+	Text string
+}
+
+// Sender that sends messages via Twilio REST API from the phone number (or messaging service SID).
+type TwilioSMSSender struct {
+	AccountSID string
+	AuthToken  string
+	From       string
+
+	// base URL of the API, Twilio itself if empty
+	BaseURL string
+	Client  *http.Client
+}
+
+func (s *TwilioSMSSender) Send(to, text string) error {
+	baseURL := s.BaseURL
+	if baseURL == "" {
+		baseURL = "https://api.twilio.com"
+	}
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	form := url.Values{"To": {to}, "From": {s.From}, "Body": {text}}
+	req, err := http.NewRequest("POST", baseURL+"/2010-04-01/Accounts/"+url.PathEscape(s.AccountSID)+"/Messages.json",
+		strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(s.AccountSID, s.AuthToken)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("twilio: %s: %s", resp.Status, body)
+	}
+	return nil
+}
+
+// number of the last messages `MemorySMSSender` keeps
+const memorySMSLimit = 100
+
+// Sender that keeps the last messages in memory instead of sending, for development and tests.
+// Texts are not logged, as they contain verification codes.
+type MemorySMSSender struct {
+	mu       sync.Mutex
+	messages []SMSMessage
+}
+
+func (s *MemorySMSSender) Send(to, text string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.messages) == memorySMSLimit {
+		s.messages = append(s.messages[:0], s.messages[1:]...)
+	}
+	s.messages = append(s.messages, SMSMessage{To: to, Text: text})
+	log.Printf("[sms] message was kept in memory and not sent")
+	return nil
+}
+
+// Returns the last message sent to the phone number.
+func (s *MemorySMSSender) LastMessage(to string) (SMSMessage, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := len(s.messages) - 1; i >= 0; i-- {
+		if s.messages[i].To == to {
+			return s.messages[i], true
+		}
+	}
+	return SMSMessage{}, false
+}
@@ -0,0 +1,51 @@
+package common
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Token is malformed, its signature doesn't match or it is expired.
+var ErrInvalidToken = errors.New("invalid token")
+
+// Calculates signature of the token payload.
+func tokenSignature(secret []byte, payload string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Creates token of the subject (which must not contain dots) that expires at the time,
+// signed with HMAC-SHA256, e.g. "<subject>.<unix time>.<signature>".
+func SignToken(secret []byte, subject string, expiresAt time.Time) string {
+	payload := subject + "." + strconv.FormatInt(expiresAt.Unix(), 10)
+	return payload + "." + tokenSignature(secret, payload)
+}
+
+// Verifies signature of the token and returns its subject and expiry time.
+// Expired tokens are reported as invalid.
+func VerifyToken(secret []byte, token string, now time.Time) (string, time.Time, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", time.Time{}, ErrInvalidToken
+	}
+	payload := parts[0] + "." + parts[1]
+	if !hmac.Equal([]byte(parts[2]), []byte(tokenSignature(secret, payload))) {
+		return "", time.Time{}, ErrInvalidToken
+	}
+
+	unix, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return "", time.Time{}, ErrInvalidToken
+	}
+	expiresAt := time.Unix(unix, 0)
+	if !expiresAt.After(now) {
+		return "", time.Time{}, ErrInvalidToken
+	}
+	return parts[0], expiresAt, nil
+}
@@ -0,0 +1,41 @@
+// +build !integration
+
+// Run this simple unit test with the following command:
+// $ docker-compose run app go test -v ./...
+
+package common
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyToken(t *testing.T) {
+	secret := []byte("MockSecret")
+	now := time.Now()
+	expiresAt := now.Add(time.Hour)
+	token := SignToken(secret, "subject", expiresAt)
+
+	subject, exp, err := VerifyToken(secret, token, now)
+	assert.Nil(t, err)
+	assert.Equal(t, "subject", subject)
+	assert.Equal(t, expiresAt.Unix(), exp.Unix())
+
+	// expired, tampered and malformed tokens, and tokens signed with another secret are invalid
+	for _, tc := range []struct {
+		secret []byte
+		token  string
+		now    time.Time
+	}{
+		{secret, token, expiresAt.Add(time.Second)},
+		{secret, token[:len(token)-1], now},
+		{secret, "another" + token[len("subject"):], now},
+		{secret, "subject", now},
+		{[]byte("AnotherSecret"), token, now},
+	} {
+		_, _, err = VerifyToken(tc.secret, tc.token, tc.now)
+		assert.Equal(t, ErrInvalidToken, err)
+	}
+}
@@ -0,0 +1,32 @@
+// +build !integration
+
+// Run this simple unit test with the following command:
+// $ docker-compose run app go test -v ./...
+
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewUUIDv7(t *testing.T) {
+	a, b := NewUUIDv7(), NewUUIDv7()
+	assert.True(t, IsUUID(a))
+	assert.NotEqual(t, a, b)
+
+	// version and variant
+	assert.Equal(t, byte('7'), a[14])
+	assert.Contains(t, "89ab", string(a[19]))
+
+	// IDs generated later are not less
+	assert.True(t, a[:13] <= b[:13])
+}
+
+func TestIsUUID(t *testing.T) {
+	assert.True(t, IsUUID("01890a5d-ac96-774b-bcce-b302099a8057"))
+	assert.False(t, IsUUID("01890A5D-AC96-774B-BCCE-B302099A8057"))
+	assert.False(t, IsUUID("1"))
+	assert.False(t, IsUUID(""))
+}
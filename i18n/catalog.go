@@ -0,0 +1,143 @@
+package i18n
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Message catalogs by language.
+// Catalogs are loaded from JSON files named by language (e.g. `en.json`, `de.json`),
+// so new locales can be added without recompiling the application.
+type Catalog struct {
+	defaultLang string
+	messages    map[string]map[string]string
+}
+
+// Loads message catalogs from JSON files in the directory.
+// Default language is used as a fallback for unsupported languages and missing messages, so its file is required.
+func LoadCatalog(dir, defaultLang string) (*Catalog, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	catalog := &Catalog{defaultLang: defaultLang, messages: make(map[string]map[string]string)}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		var messages map[string]string
+		if err = json.Unmarshal(data, &messages); err != nil {
+			return nil, &os.PathError{Op: "parse", Path: file, Err: err}
+		}
+
+		lang := strings.ToLower(strings.TrimSuffix(filepath.Base(file), ".json"))
+		catalog.messages[lang] = messages
+	}
+	if _, ok := catalog.messages[defaultLang]; !ok {
+		return nil, &os.PathError{Op: "load", Path: filepath.Join(dir, defaultLang+".json"), Err: os.ErrNotExist}
+	}
+	return catalog, nil
+}
+
+// Returns list of languages supported by the catalog.
+func (c *Catalog) Languages() []string {
+	langs := make([]string, 0, len(c.messages))
+	for lang := range c.messages {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	return langs
+}
+
+// Resolves the best supported language by `Accept-Language` header value.
+// Regional tags fall back to the base language (e.g. "de-CH" to "de"), then to the default language.
+func (c *Catalog) Match(acceptLanguage string) string {
+	for _, tag := range ParseAcceptLanguage(acceptLanguage) {
+		if _, ok := c.messages[tag]; ok {
+			return tag
+		}
+		if i := strings.Index(tag, "-"); i > 0 {
+			if _, ok := c.messages[tag[:i]]; ok {
+				return tag[:i]
+			}
+		}
+	}
+	return c.defaultLang
+}
+
+// Creates localizer for the language.
+func (c *Catalog) Localizer(lang string) *Localizer {
+	return &Localizer{catalog: c, Lang: lang}
+}
+
+// Translates messages to a single language.
+type Localizer struct {
+	catalog *Catalog
+
+	// resolved language
+	Lang string
+}
+
+// Translates message by key and replaces `{name}` placeholders with parameters.
+// Falls back to the default language, returns false if message is not found.
+func (l *Localizer) Translate(key string, params map[string]string) (string, bool) {
+	message, ok := l.catalog.messages[l.Lang][key]
+	if !ok {
+		if message, ok = l.catalog.messages[l.catalog.defaultLang][key]; !ok {
+			return "", false
+		}
+	}
+
+	for name, value := range params {
+		message = strings.Replace(message, "{"+name+"}", value, -1)
+	}
+	return message, true
+}
+
+// Parses `Accept-Language` header value into lowercase language tags ordered by quality.
+func ParseAcceptLanguage(header string) []string {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+
+	var tags []weighted
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		tag := strings.ToLower(strings.TrimSpace(fields[0]))
+		if tag == "" || tag == "*" {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if q > 0 {
+			tags = append(tags, weighted{tag, q})
+		}
+	}
+
+	sort.SliceStable(tags, func(i, j int) bool {
+		return tags[i].q > tags[j].q
+	})
+
+	result := make([]string, len(tags))
+	for i, t := range tags {
+		result[i] = t.tag
+	}
+	return result
+}
@@ -0,0 +1,60 @@
+// +build !integration
+
+package i18n
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAcceptLanguage(t *testing.T) {
+	assert.Equal(t, []string{"de-ch", "de", "en"}, ParseAcceptLanguage("en;q=0.5, de-CH, de;q=0.9, fr;q=0"))
+	assert.Empty(t, ParseAcceptLanguage(""))
+	assert.Empty(t, ParseAcceptLanguage("*"))
+}
+
+func TestCatalog(t *testing.T) {
+	catalog, err := LoadCatalog("../locales", "en")
+	assert.Nil(t, err)
+	assert.Subset(t, catalog.Languages(), []string{"de", "en", "ru"})
+
+	assert.Equal(t, "de", catalog.Match("de-CH, en;q=0.8"))
+	assert.Equal(t, "ru", catalog.Match("xx, ru"))
+	assert.Equal(t, "en", catalog.Match("xx"))
+	assert.Equal(t, "en", catalog.Match(""))
+
+	message, ok := catalog.Localizer("de").Translate("validation.required", map[string]string{"field": "E-Mail"})
+	assert.True(t, ok)
+	assert.Equal(t, "E-Mail ist erforderlich", message)
+
+	_, ok = catalog.Localizer("de").Translate("unknown", nil)
+	assert.False(t, ok)
+
+	// every locale must provide the same messages as the default one
+	for _, lang := range catalog.Languages() {
+		for key := range catalog.messages["en"] {
+			_, ok := catalog.messages[lang][key]
+			assert.True(t, ok, "%s: missing %s", lang, key)
+		}
+	}
+}
+
+func TestCatalogWithoutDefaultLanguage(t *testing.T) {
+	_, err := LoadCatalog("missing", "en")
+	assert.True(t, os.IsNotExist(err))
+
+	dir, err := ioutil.TempDir("", "locales")
+	assert.Nil(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	err = ioutil.WriteFile(filepath.Join(dir, "de.json"), []byte(`{"unknown": "Unbekannt"}`), 0644)
+	assert.Nil(t, err)
+
+	_, err = LoadCatalog(dir, "en")
+	assert.True(t, os.IsNotExist(err))
+	assert.Contains(t, err.Error(), "en.json")
+}
@@ -1,24 +1,49 @@
 package main
 
 import (
+	"crypto/rand"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/postgres"
 	"github.com/lokhman/example-users-microservice/api"
+	"github.com/lokhman/example-users-microservice/common"
 	_ "github.com/lokhman/example-users-microservice/docs"
+	"github.com/lokhman/example-users-microservice/i18n"
 	"github.com/lokhman/example-users-microservice/model"
 	"github.com/nsqio/go-nsq"
 	"github.com/swaggo/gin-swagger"
 	"github.com/swaggo/gin-swagger/swaggerFiles"
 )
 
+// Replaces default aliases of country codes if environment variable is set (e.g. "UK=GB,EL=GR").
+func configureCountryAliases(key string) {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return
+	}
+	aliases, err := common.ParseCountryAliases(value)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	common.CountryAliases = aliases
+}
+
 // Connects to database by DSN and sets logging mode.
 func connectDatabase(driver, dsn string) *gorm.DB {
+	// PostgreSQL stores timestamps with microsecond precision, so returned entities match the stored ones
+	gorm.NowFunc = func() time.Time {
+		return time.Now().UTC().Truncate(time.Microsecond)
+	}
+
 	db, err := gorm.Open(driver, dsn)
 	if err != nil {
 		log.Fatalln(err)
@@ -26,7 +51,9 @@ func connectDatabase(driver, dsn string) *gorm.DB {
 	if gin.Mode() == gin.DebugMode {
 		db.LogMode(true)
 	}
-	db.AutoMigrate(&model.User{})
+	if err = model.Migrate(db); err != nil {
+		log.Fatalln(err)
+	}
 	return db
 }
 
@@ -39,23 +66,409 @@ func connectNSQ(addr string) *nsq.Producer {
 	return p
 }
 
+// Reads duration from environment variable or returns default value.
+func getenvDuration(key string, def time.Duration) time.Duration {
+	if value, ok := os.LookupEnv(key); ok {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		return d
+	}
+	return def
+}
+
+// Reads string from environment variable or returns default value.
+func getenv(key, def string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return def
+}
+
+// Reads integer from environment variable or returns default value.
+func getenvInt(key string, def int64) int64 {
+	if value, ok := os.LookupEnv(key); ok {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		return n
+	}
+	return def
+}
+
+// Reads boolean from environment variable or returns default value.
+func getenvBool(key string, def bool) bool {
+	if value, ok := os.LookupEnv(key); ok {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		return b
+	}
+	return def
+}
+
+// Reads secret key from environment variable, it is required unless GIN_MODE is "debug" or "test".
+// Otherwise random key is generated, it doesn't survive restarts and isn't shared between instances,
+// so it's only good for development.
+func getenvSecret(key string) []byte {
+	if value := os.Getenv(key); value != "" {
+		return []byte(value)
+	}
+	if mode := os.Getenv("GIN_MODE"); mode != gin.DebugMode && mode != gin.TestMode {
+		log.Fatalf("[main] %s is required unless GIN_MODE is \"debug\" or \"test\"", key)
+	}
+	log.Printf("[main] WARNING: %s is not set, random key is generated", key)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		log.Fatalln(err)
+	}
+	return secret
+}
+
+// Reads date (YYYY-MM-DD) from environment variable or returns default value.
+func getenvDate(key string, def time.Time) time.Time {
+	if value, ok := os.LookupEnv(key); ok {
+		t, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		return t
+	}
+	return def
+}
+
+// Loads message catalogs for localized errors.
+func loadCatalog(dir string) *i18n.Catalog {
+	catalog, err := i18n.LoadCatalog(dir, "en")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	log.Printf("[i18n] loaded languages: %v", catalog.Languages())
+	return catalog
+}
+
+// Creates blob storage in the local directory.
+// Can be replaced with a shared storage (e.g. Amazon S3) implementing `common.BlobStore` for multiple nodes.
+func createBlobStore(dir, baseURL string) *common.FileBlobStore {
+	s, err := common.NewFileBlobStore(dir, baseURL)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	return s
+}
+
+// Creates mailer of the kind: "smtp" (configured from environment variables) or "log" (emails are only logged).
+func createMailer(kind string) common.Mailer {
+	switch kind {
+	case "smtp":
+		m := &common.SMTPMailer{
+			Addr:     os.Getenv("SMTP_ADDR"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("MAIL_FROM"),
+		}
+		if m.Addr == "" || m.From == "" {
+			log.Fatalln("[main] SMTP_ADDR and MAIL_FROM are required by SMTP mailer")
+		}
+		return m
+	case "":
+		log.Printf("[main] WARNING: MAILER is not set, emails are only logged and never sent")
+		return &common.MemoryMailer{}
+	case "log":
+		return &common.MemoryMailer{}
+	}
+	log.Fatalf("[main] unknown MAILER %q, expected \"smtp\" or \"log\"", kind)
+	return nil
+}
+
+// Reads URL of invitation links from environment variable, it is required, as it is the page of the frontend.
+func getenvInvitationURL(key string) string {
+	value := os.Getenv(key)
+	if u, err := url.Parse(value); err != nil || !u.IsAbs() {
+		log.Fatalf("[main] %s is required to be an absolute URL of the page where invitations are accepted", key)
+	}
+	return value
+}
+
+// Creates SMS sender of the kind: "twilio" (configured from environment variables) or "memory" (messages are not sent).
+func createSMSSender(kind string) common.SMSSender {
+	switch kind {
+	case "twilio":
+		s := &common.TwilioSMSSender{
+			AccountSID: os.Getenv("TWILIO_ACCOUNT_SID"),
+			AuthToken:  os.Getenv("TWILIO_AUTH_TOKEN"),
+			From:       os.Getenv("SMS_FROM"),
+		}
+		if s.AccountSID == "" || s.AuthToken == "" || s.From == "" {
+			log.Fatalln("[main] TWILIO_ACCOUNT_SID, TWILIO_AUTH_TOKEN and SMS_FROM are required by Twilio SMS sender")
+		}
+		return s
+	case "memory":
+		log.Printf("[main] WARNING: SMS is \"memory\", text messages are never sent")
+		return &common.MemorySMSSender{}
+	}
+	log.Fatalf("[main] unknown SMS %q, expected \"twilio\" or \"memory\"", kind)
+	return nil
+}
+
+// Creates API "controller" configured from environment variables.
+func createAPI(db *gorm.DB, p *nsq.Producer) *api.API {
+	return &api.API{
+		DB:                db,
+		NSQ:               p,
+		NSQAddr:           os.Getenv("NSQ_ADDR"),
+		AdminToken:        os.Getenv("ADMIN_TOKEN"),
+		Catalog:           loadCatalog(getenv("LOCALES_DIR", "locales")),
+		UnversionedSunset: getenvDate("UNVERSIONED_SUNSET", time.Date(2027, time.June, 30, 0, 0, 0, 0, time.UTC)),
+		IntegerIDSunset:   getenvDate("INTEGER_ID_SUNSET", time.Date(2027, time.March, 31, 0, 0, 0, 0, time.UTC)),
+		IdempotencyTTL:    getenvDuration("IDEMPOTENCY_TTL", 24*time.Hour),
+		Blobs:             createBlobStore(getenv("BLOB_DIR", "blobs"), getenv("BLOB_URL", "/blobs")),
+		AvatarMaxSize:     getenvInt("AVATAR_MAX_SIZE", 5<<20),
+		SMS:               createSMSSender(os.Getenv("SMS")),
+		Mailer:            createMailer(os.Getenv("MAILER")),
+		InvitationSecret:  getenvSecret("INVITATION_SECRET"),
+		InvitationTTL:     getenvDuration("INVITATION_TTL", 7*24*time.Hour),
+		InvitationURL:     getenvInvitationURL("INVITATION_URL"),
+		DataExportTTL:     getenvDuration("DATA_EXPORT_TTL", 7*24*time.Hour),
+		ErasureSecret:     getenvSecret("ERASURE_SECRET"),
+	}
+}
+
+// Permanently deletes soft deleted users after retention period in background.
+func startPurger(api *api.API, retention, interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			for {
+				n, err := api.PurgeDeletedUsers(time.Now().Add(-retention), 100)
+				if err != nil {
+					log.Printf("[purger] %s", err)
+				}
+				if err != nil || n == 0 {
+					break
+				}
+			}
+		}
+	}()
+}
+
+// Activates users whose suspension or ban expired in background.
+func startActivator(api *api.API, interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			for {
+				n, err := api.ActivateExpiredUsers(time.Now(), 100)
+				if err != nil {
+					log.Printf("[activator] %s", err)
+				}
+				if err != nil || n == 0 {
+					break
+				}
+			}
+		}
+	}()
+}
+
+// Assembles requested data exports and deletes expired archives in background.
+func startExporter(api *api.API, interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			for {
+				n, err := api.ProcessDataExports(10)
+				if err != nil {
+					log.Printf("[exporter] %s", err)
+				}
+				if err != nil || n == 0 {
+					break
+				}
+			}
+			for {
+				n, err := api.ExpireDataExports(time.Now(), 100)
+				if err != nil {
+					log.Printf("[exporter] %s", err)
+				}
+				if err != nil || n == 0 {
+					break
+				}
+			}
+		}
+	}()
+}
+
+// Registers v1 user routes.
+func registerUserRoutesV1(g *gin.RouterGroup, api *api.API) {
+	g.GET("", api.UserIndexHandler)
+	g.POST("", api.UserCreateHandler)
+	g.GET("/:id", api.UserViewHandler)
+	g.PUT("/:id", api.UserUpdateHandler)
+	g.DELETE("/:id", api.UserDeleteHandler)
+	g.POST("/:id/restore", api.UserRestoreHandler)
+	g.POST("/:id/erase", api.UserEraseHandler)
+	g.POST("/:id/suspend", api.UserSuspendHandler)
+	g.POST("/:id/ban", api.UserBanHandler)
+	g.POST("/:id/activate", api.UserActivateHandler)
+	g.GET("/:id/history", api.UserHistoryHandler)
+	g.PUT("/:id/avatar", api.UserAvatarHandler)
+	g.PUT("/:id/attributes/:namespace", api.UserAttributesHandler)
+	g.DELETE("/:id/attributes/:namespace", api.UserAttributesDeleteHandler)
+	g.GET("/:id/preferences", api.UserPreferencesHandler)
+	g.PUT("/:id/preferences", api.UserPreferencesUpdateHandler)
+	g.POST("/:id/phone/verification", api.UserPhoneVerificationHandler)
+	g.POST("/:id/phone/verification/confirm", api.UserPhoneVerifyHandler)
+	g.GET("/:id/groups", api.UserGroupsHandler)
+	g.POST("/:id/data-export", api.UserDataExportHandler)
+	g.GET("/:id/consents", api.UserConsentIndexHandler)
+	g.POST("/:id/consents", api.UserConsentCreateHandler)
+}
+
+// Registers v2 user routes.
+func registerUserRoutesV2(g *gin.RouterGroup, api *api.API) {
+	g.GET("", api.UserIndexV2Handler)
+	g.POST("", api.UserCreateV2Handler)
+	g.GET("/:id", api.UserViewV2Handler)
+	g.PUT("/:id", api.UserUpdateV2Handler)
+	g.DELETE("/:id", api.UserDeleteV2Handler)
+	g.POST("/:id/restore", api.UserRestoreV2Handler)
+	g.POST("/:id/erase", api.UserEraseV2Handler)
+	g.POST("/:id/suspend", api.UserSuspendV2Handler)
+	g.POST("/:id/ban", api.UserBanV2Handler)
+	g.POST("/:id/activate", api.UserActivateV2Handler)
+	g.GET("/:id/history", api.UserHistoryV2Handler)
+	g.PUT("/:id/avatar", api.UserAvatarV2Handler)
+	g.PUT("/:id/attributes/:namespace", api.UserAttributesV2Handler)
+	g.DELETE("/:id/attributes/:namespace", api.UserAttributesDeleteV2Handler)
+	g.GET("/:id/preferences", api.UserPreferencesV2Handler)
+	g.PUT("/:id/preferences", api.UserPreferencesUpdateV2Handler)
+	g.POST("/:id/phone/verification", api.UserPhoneVerificationV2Handler)
+	g.POST("/:id/phone/verification/confirm", api.UserPhoneVerifyV2Handler)
+	g.GET("/:id/groups", api.UserGroupsV2Handler)
+	g.POST("/:id/data-export", api.UserDataExportV2Handler)
+	g.GET("/:id/consents", api.UserConsentIndexV2Handler)
+	g.POST("/:id/consents", api.UserConsentCreateV2Handler)
+}
+
+// Registers routes of custom attribute schemas.
+func registerAttributeSchemaRoutes(g *gin.RouterGroup, api *api.API) {
+	g.GET("", api.AttributeSchemaIndexHandler)
+	g.GET("/:namespace", api.AttributeSchemaViewHandler)
+	g.PUT("/:namespace", api.AttributeSchemaSaveHandler)
+}
+
+// Registers organization routes.
+func registerOrganizationRoutes(g *gin.RouterGroup, api *api.API) {
+	g.GET("", api.OrganizationIndexHandler)
+	g.POST("", api.OrganizationCreateHandler)
+	g.GET("/:id", api.OrganizationViewHandler)
+	g.PUT("/:id", api.OrganizationUpdateHandler)
+	g.DELETE("/:id", api.OrganizationDeleteHandler)
+	g.GET("/:id/members", api.OrganizationMemberIndexHandler)
+	g.PUT("/:id/members/:user_id", api.OrganizationMemberSaveHandler)
+	g.DELETE("/:id/members/:user_id", api.OrganizationMemberDeleteHandler)
+}
+
+// Registers group routes.
+func registerGroupRoutes(g *gin.RouterGroup, api *api.API) {
+	g.GET("", api.GroupIndexHandler)
+	g.POST("", api.GroupCreateHandler)
+	g.GET("/:id", api.GroupViewHandler)
+	g.PUT("/:id", api.GroupUpdateHandler)
+	g.DELETE("/:id", api.GroupDeleteHandler)
+	g.GET("/:id/members", api.GroupMemberIndexHandler)
+	g.PUT("/:id/members/:user_id", api.GroupMemberSaveHandler)
+	g.DELETE("/:id/members/:user_id", api.GroupMemberDeleteHandler)
+}
+
+// Registers invitation routes, acceptance creates user of the version.
+func registerInvitationRoutes(g *gin.RouterGroup, api *api.API, accept gin.HandlerFunc) {
+	g.GET("", api.InvitationIndexHandler)
+	g.POST("", api.InvitationCreateHandler)
+	g.POST("/:id/resend", api.InvitationResendHandler)
+	g.POST("/:id/revoke", api.InvitationRevokeHandler)
+	g.POST("/:id/accept", accept)
+}
+
 // Creates GIN router.
 func createRouter(api *api.API) *gin.Engine {
 	r := gin.Default()
 	r.Use(gin.Logger())
 	r.Use(gin.Recovery())
 
+	// errors are reported as RFC 7807 problem details
+	r.HandleMethodNotAllowed = true
+	r.NoRoute(api.NotFoundHandler)
+	r.NoMethod(api.MethodNotAllowedHandler)
+	r.Use(api.RequestIDMiddleware)
+	r.Use(api.LocaleMiddleware)
+	r.Use(api.RecoveryMiddleware)
+
+	// requests with `X-Organization-ID` header see only the organization and its members,
+	// routes of users require it from anyone but administrators
+	r.Use(api.TenantMiddleware)
+
+	// retries of POST requests with `Idempotency-Key` header replay the original response
+	r.Use(api.IdempotencyMiddleware)
+
 	// simple endpoint for health check
 	r.GET("/", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"now": time.Now()})
 	})
 
-	// users routing
-	r.GET("/users", api.UserIndexHandler)
-	r.POST("/users", api.UserCreateHandler)
-	r.GET("/users/:id", api.UserViewHandler)
-	r.PUT("/users/:id", api.UserUpdateHandler)
-	r.DELETE("/users/:id", api.UserDeleteHandler)
+	// users and login routing (with content negotiation), users are accessed only within organization
+	registerUserRoutesV1(r.Group("/v1/users", api.RequireTenantMiddleware, api.NegotiationMiddleware), api)
+	registerUserRoutesV2(r.Group("/v2/users", api.RequireTenantMiddleware, api.NegotiationMiddleware), api)
+
+	// schemas of custom user attributes are the same in all versions
+	registerAttributeSchemaRoutes(r.Group("/v1/attribute-schemas", api.NegotiationMiddleware), api)
+	registerAttributeSchemaRoutes(r.Group("/v2/attribute-schemas", api.NegotiationMiddleware), api)
+
+	// organizations and groups are the same in all versions, they are visible only within organization
+	registerOrganizationRoutes(r.Group("/v1/organizations", api.RequireTenantMiddleware, api.NegotiationMiddleware), api)
+	registerOrganizationRoutes(r.Group("/v2/organizations", api.RequireTenantMiddleware, api.NegotiationMiddleware), api)
+	registerGroupRoutes(r.Group("/v1/groups", api.RequireTenantMiddleware, api.NegotiationMiddleware), api)
+	registerGroupRoutes(r.Group("/v2/groups", api.RequireTenantMiddleware, api.NegotiationMiddleware), api)
+
+	registerInvitationRoutes(r.Group("/v1/invitations", api.NegotiationMiddleware), api, api.InvitationAcceptHandler)
+	registerInvitationRoutes(r.Group("/v2/invitations", api.NegotiationMiddleware), api, api.InvitationAcceptV2Handler)
+
+	r.GET("/v1/countries", api.NegotiationMiddleware, api.CountryIndexHandler)
+	r.GET("/v2/countries", api.NegotiationMiddleware, api.CountryIndexHandler)
+	r.GET("/v1/email-duplicates", api.NegotiationMiddleware, api.EmailDuplicateIndexHandler)
+	r.GET("/v2/email-duplicates", api.NegotiationMiddleware, api.EmailDuplicateIndexHandler)
+	r.GET("/v1/nicknames/availability", api.NegotiationMiddleware, api.NicknameAvailabilityHandler)
+	r.GET("/v2/nicknames/availability", api.NegotiationMiddleware, api.NicknameAvailabilityHandler)
+	r.GET("/v1/exports/:id", api.NegotiationMiddleware, api.DataExportViewHandler)
+	r.GET("/v2/exports/:id", api.NegotiationMiddleware, api.DataExportViewHandler)
+	r.GET("/v1/exports/:id/download", api.DataExportDownloadHandler)
+	r.GET("/v2/exports/:id/download", api.DataExportDownloadHandler)
+	r.GET("/v1/policies", api.NegotiationMiddleware, api.PolicyIndexHandler)
+	r.GET("/v2/policies", api.NegotiationMiddleware, api.PolicyIndexHandler)
+	r.POST("/v1/policies", api.NegotiationMiddleware, api.PolicyCreateHandler)
+	r.POST("/v2/policies", api.NegotiationMiddleware, api.PolicyCreateHandler)
+
+	r.POST("/v1/login", api.RequireTenantMiddleware, api.NegotiationMiddleware, api.LoginHandler)
+	r.POST("/v2/login", api.RequireTenantMiddleware, api.NegotiationMiddleware, api.LoginV2Handler)
+
+	// unversioned routes are deprecated aliases of v1
+	registerUserRoutesV1(r.Group("/users", api.DeprecationMiddleware("/v1"), api.RequireTenantMiddleware, api.NegotiationMiddleware), api)
+
+	// blobs in the local directory are served by the application itself, except the private ones
+	if s, ok := api.Blobs.(*common.FileBlobStore); ok && strings.HasPrefix(s.BaseURL, "/") {
+		files := http.StripPrefix(s.BaseURL, http.FileServer(gin.Dir(s.Dir, false)))
+		serveBlob := func(c *gin.Context) {
+			if api.IsPrivateBlob(c.Param("key")) {
+				api.NotFoundHandler(c)
+				return
+			}
+			files.ServeHTTP(c.Writer, c.Request)
+		}
+		r.GET(s.BaseURL+"/*key", serveBlob)
+		r.HEAD(s.BaseURL+"/*key", serveBlob)
+	}
+
+	// GraphQL API over the same business logic
+	r.POST("/graphql", api.RequireTenantMiddleware, api.GraphQLHandler())
 
 	// autogenerated documentation
 	r.GET("/docs/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
@@ -70,6 +483,18 @@ func createRouter(api *api.API) *gin.Engine {
 // @contact.url   https://github.com/lokhman
 // @contact.email alex.lokhman@gmail.com
 func main() {
+	// aliases are used by validation and migration of country codes
+	configureCountryAliases("COUNTRY_ALIASES")
+
+	// emails are normalized by migration, so provider rules have to be known before connecting to database
+	common.EmailProviderRules = getenvBool("EMAIL_PROVIDER_RULES", false)
+
+	// unique index of nicknames is created or dropped by migration
+	model.UniqueNicknames = getenvBool("UNIQUE_NICKNAMES", false)
+	if value, ok := os.LookupEnv("RESERVED_NICKNAMES"); ok {
+		common.ReservedNicknames = common.ParseReservedNicknames(value)
+	}
+
 	// connect to database server (using PostgreSQL, but can be abstracted to other driver with ORM)
 	db := connectDatabase("postgres", os.Getenv("DATABASE_URL"))
 	defer func() { _ = db.Close() }()
@@ -78,8 +503,28 @@ func main() {
 	p := connectNSQ(os.Getenv("NSQ_ADDR"))
 	defer p.Stop()
 
+	api := createAPI(db, p)
+
+	// purge soft deleted users after retention period
+	startPurger(api, getenvDuration("USER_RETENTION", 30*24*time.Hour), getenvDuration("PURGE_INTERVAL", time.Hour))
+
+	// lift expired suspensions and bans
+	startActivator(api, getenvDuration("ACTIVATE_INTERVAL", time.Minute))
+
+	// assemble data exports of users
+	startExporter(api, getenvDuration("EXPORT_INTERVAL", 10*time.Second))
+
+	// start gRPC server on a separate port
+	go func() {
+		lis, err := net.Listen("tcp", getenv("GRPC_ADDR", ":9000"))
+		if err != nil {
+			log.Fatalln(err)
+		}
+		log.Fatalln(api.NewGRPCServer().Serve(lis))
+	}()
+
 	// create GIN router
-	r := createRouter(&api.API{DB: db, NSQ: p})
+	r := createRouter(api)
 
 	// address parameter may come from CLI flags for scalability if designed to balance multiple nodes
 	// but in containerised environments can be achieved by port mapping
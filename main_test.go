@@ -6,24 +6,52 @@
 package main
 
 import (
+	"archive/zip"
 	"bytes"
+	"context"
 	"encoding/json"
+	"encoding/xml"
 	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
 	"math/rand"
+	"mime/multipart"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/lokhman/example-users-microservice/api"
+	"github.com/lokhman/example-users-microservice/common"
 	"github.com/lokhman/example-users-microservice/model"
+	usersv1 "github.com/lokhman/example-users-microservice/proto/users/v1"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
 var API *api.API
-var Router *gin.Engine
+var Router http.Handler
+
+const (
+	MockAdminToken = "MockAdminToken"
+	MockRequestID  = "MockRequestID"
+)
+
+var MockAdminHeaders = map[string]string{"Authorization": "Bearer " + MockAdminToken}
 
 var (
 	MockUserInput = api.UserInput{
@@ -31,7 +59,7 @@ var (
 		Password:  fmt.Sprintf("MyPassword%d", rand.Uint32()),
 		FirstName: "Alex",
 		LastName:  "Lokhman",
-		Nickname:  "VisioN",
+		Nickname:  fmt.Sprintf("VisioN%d", rand.Uint32()),
 		Country:   "RU",
 	}
 	MockUser = model.User{
@@ -41,14 +69,43 @@ var (
 		Nickname:  MockUserInput.Nickname,
 		Country:   MockUserInput.Country,
 	}
+
+	// internal ID of mock user, it is not exposed by API
+	MockUserID int
+
+	// public ID of organization requests are scoped to (see `mockTenantRouter`)
+	MockOrgID string
 )
 
+// Scopes requests to the mock organization, as users are accessed only within organization,
+// unless they are made by administrator or set `X-Organization-ID` header themselves (empty for no scope).
+type mockTenantRouter struct {
+	*gin.Engine
+}
+
+func (r mockTenantRouter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if _, ok := req.Header[http.CanonicalHeaderKey(api.OrganizationHeader)]; !ok && req.Header.Get("Authorization") == "" {
+		req.Header.Set(api.OrganizationHeader, MockOrgID)
+	}
+	r.Engine.ServeHTTP(w, req)
+}
+
 func startup() {
+	model.UniqueNicknames = true
 	db := connectDatabase("postgres", os.Getenv("DATABASE_URL"))
 	p := connectNSQ(os.Getenv("NSQ_ADDR"))
 
-	API = &api.API{DB: db, NSQ: p}
-	Router = createRouter(API)
+	API = createAPI(db, p)
+	API.AdminToken = MockAdminToken
+	Router = mockTenantRouter{createRouter(API)}
+
+	if MockOrgID == "" {
+		org, err := API.CreateOrganization(api.OrganizationInput{Name: "Mock Organization"})
+		if err != nil {
+			panic(err)
+		}
+		MockOrgID = org.PublicID
+	}
 }
 
 func cleanup() {
@@ -56,6 +113,19 @@ func cleanup() {
 	API.NSQ.Stop()
 }
 
+// Makes request to the router with the headers, e.g. `MockAdminHeaders`.
+func request(t *testing.T, method, url, body string, headers map[string]string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	assert.Nil(t, err)
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	w := httptest.NewRecorder()
+	Router.ServeHTTP(w, req)
+	return w
+}
+
 func TestHealthCheck(t *testing.T) {
 	startup()
 	defer cleanup()
@@ -85,7 +155,7 @@ func TestUserCreate(t *testing.T) {
 	assert.Nil(t, err)
 
 	// test for success
-	req, err := http.NewRequest("POST", "/users", bytes.NewReader(data))
+	req, err := http.NewRequest("POST", "/v1/users", bytes.NewReader(data))
 	assert.Nil(t, err)
 
 	w := httptest.NewRecorder()
@@ -96,115 +166,1882 @@ func TestUserCreate(t *testing.T) {
 	dec := json.NewDecoder(w.Body)
 	err = dec.Decode(&out)
 	assert.Nil(t, err)
-	assert.NotZero(t, out.ID)
+	assert.Zero(t, out.ID)
 	assert.Equal(t, MockUser.Email, out.Email)
 	assert.Empty(t, out.Password)
 	assert.Equal(t, MockUser.FirstName, out.FirstName)
 	assert.Equal(t, MockUser.LastName, out.LastName)
 	assert.Equal(t, MockUser.Nickname, out.Nickname)
 	assert.Equal(t, MockUser.Country, out.Country)
-	MockUser.ID = out.ID
+	assert.NotZero(t, out.CreatedAt)
+	assert.Equal(t, out.CreatedAt, out.UpdatedAt)
+	assert.Nil(t, out.LastLoginAt)
+	assert.True(t, common.IsUUID(out.PublicID))
+	assert.Equal(t, model.UserStatusActive, out.Status)
+	MockUser.PublicID = out.PublicID
+	MockUserID, err = API.ResolveUserID(out.PublicID)
+	assert.Nil(t, err)
+	MockUser.CreatedAt = out.CreatedAt
+	MockUser.UpdatedAt = out.UpdatedAt
 
 	// test for failure
-	req, err = http.NewRequest("POST", "/users", bytes.NewReader(data))
+	req, err = http.NewRequest("POST", "/v1/users", bytes.NewReader(data))
+	assert.Nil(t, err)
+
+	w = httptest.NewRecorder()
+	Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Equal(t, common.ProblemContentType, w.Header().Get("Content-Type"))
+
+	var problem common.Problem
+	err = json.NewDecoder(w.Body).Decode(&problem)
+	assert.Nil(t, err)
+	assert.Equal(t, common.ErrCodeEmailExists, problem.Code)
+	assert.Equal(t, "/v1/users", problem.Instance)
+
+	// test for failure with email in another case
+	in.Email = " " + strings.ToUpper(MockUserInput.Email)
+	data, err = json.Marshal(in)
+	assert.Nil(t, err)
+
+	req, err = http.NewRequest("POST", "/v1/users", bytes.NewReader(data))
+	assert.Nil(t, err)
+
+	w = httptest.NewRecorder()
+	Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), common.ErrCodeEmailExists)
+
+	// test for failure with nickname in another case
+	in.Email = fmt.Sprintf("alex.lokhman.%d@gmail.com", rand.Uint32())
+	in.Nickname = strings.ToLower(MockUserInput.Nickname)
+	data, err = json.Marshal(in)
+	assert.Nil(t, err)
+
+	req, err = http.NewRequest("POST", "/v1/users", bytes.NewReader(data))
+	assert.Nil(t, err)
+
+	w = httptest.NewRecorder()
+	Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), common.ErrCodeNicknameExists)
+
+	// test for failure with reserved nickname
+	in.Nickname = "Admin"
+	data, err = json.Marshal(in)
+	assert.Nil(t, err)
+
+	req, err = http.NewRequest("POST", "/v1/users", bytes.NewReader(data))
+	assert.Nil(t, err)
+
+	w = httptest.NewRecorder()
+	Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), `"rule":"reserved"`)
+	in.Nickname = MockUserInput.Nickname
+
+	// test for validation failure
+	in.Email = "invalid"
+	in.Country = "Russia"
+	data, err = json.Marshal(in)
+	assert.Nil(t, err)
+
+	req, err = http.NewRequest("POST", "/v1/users", bytes.NewReader(data))
 	assert.Nil(t, err)
+	req.Header.Set("Accept-Language", "de-DE, en;q=0.5")
 
 	w = httptest.NewRecorder()
 	Router.ServeHTTP(w, req)
 	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Equal(t, "de", w.Header().Get("Content-Language"))
+
+	problem = common.Problem{}
+	err = json.NewDecoder(w.Body).Decode(&problem)
+	assert.Nil(t, err)
+	assert.Equal(t, common.ErrCodeValidationFailed, problem.Code)
+	assert.Equal(t, []common.FieldError{
+		{Field: "country", Rule: "country", Message: "Land muss ein gültiger Ländercode nach ISO 3166-1 alpha-2 sein"},
+		{Field: "email", Rule: "email", Message: "E-Mail muss eine gültige E-Mail-Adresse sein"},
+	}, problem.Errors)
 
 	// here we can write more test cases for various scenarios + NSQ publish...
 }
 
+func TestNicknameAvailability(t *testing.T) {
+	startup()
+	defer cleanup()
+
+	check := func(nickname string) (out api.NicknameAvailability) {
+		req, err := http.NewRequest("GET", "/v1/nicknames/availability?nickname="+url.QueryEscape(nickname), nil)
+		assert.Nil(t, err)
+
+		w := httptest.NewRecorder()
+		Router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		err = json.NewDecoder(w.Body).Decode(&out)
+		assert.Nil(t, err)
+		return out
+	}
+
+	// test if nickname is taken in any case and free variants are suggested
+	out := check(strings.ToUpper(MockUser.Nickname))
+	assert.False(t, out.Available)
+	assert.Equal(t, api.NicknameTaken, out.Reason)
+	assert.NotEmpty(t, out.Suggestions)
+	for _, s := range out.Suggestions {
+		assert.True(t, strings.HasPrefix(s, strings.ToUpper(MockUser.Nickname)), s)
+		assert.True(t, check(s).Available, s)
+	}
+
+	out = check("admin")
+	assert.False(t, out.Available)
+	assert.Equal(t, api.NicknameReserved, out.Reason)
+
+	out = check(fmt.Sprintf("Free%d", rand.Uint32()))
+	assert.True(t, out.Available)
+	assert.Empty(t, out.Suggestions)
+
+	// test for failure
+	req, err := http.NewRequest("GET", "/v1/nicknames/availability", nil)
+	assert.Nil(t, err)
+
+	w := httptest.NewRecorder()
+	Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestUserCreateIdempotent(t *testing.T) {
+	startup()
+	defer cleanup()
+
+	var in = MockUserInput
+	in.Email = fmt.Sprintf("alex.lokhman.%d@gmail.com", rand.Uint32())
+	in.Nickname = fmt.Sprintf("VisioN%d", rand.Uint32())
+	data, err := json.Marshal(in)
+	assert.Nil(t, err)
+
+	key := fmt.Sprintf("idempotency-key-%d", rand.Uint32())
+
+	// first request creates user
+	req, err := http.NewRequest("POST", "/v1/users", bytes.NewReader(data))
+	assert.Nil(t, err)
+	req.Header.Set("Idempotency-Key", key)
+
+	w := httptest.NewRecorder()
+	Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var out model.User
+	err = json.NewDecoder(w.Body).Decode(&out)
+	assert.Nil(t, err)
+	assert.True(t, common.IsUUID(out.PublicID))
+
+	// retry replays the original response instead of failing with 422
+	req, err = http.NewRequest("POST", "/v1/users", bytes.NewReader(data))
+	assert.Nil(t, err)
+	req.Header.Set("Idempotency-Key", key)
+
+	w = httptest.NewRecorder()
+	Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "true", w.Header().Get("Idempotent-Replayed"))
+
+	var replayed model.User
+	err = json.NewDecoder(w.Body).Decode(&replayed)
+	assert.Nil(t, err)
+	assert.Equal(t, out, replayed)
+
+	// same key with another representation is a conflict
+	req, err = http.NewRequest("POST", "/v1/users", bytes.NewReader(data))
+	assert.Nil(t, err)
+	req.Header.Set("Idempotency-Key", key)
+	req.Header.Set("Accept", "application/xml")
+
+	w = httptest.NewRecorder()
+	Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	// same key with a different body is a conflict
+	in.Nickname = "Other"
+	data, err = json.Marshal(in)
+	assert.Nil(t, err)
+
+	req, err = http.NewRequest("POST", "/v1/users", bytes.NewReader(data))
+	assert.Nil(t, err)
+	req.Header.Set("Idempotency-Key", key)
+
+	w = httptest.NewRecorder()
+	Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	// clean up created user
+	req, err = http.NewRequest("DELETE", "/v1/users/"+out.PublicID, nil)
+	assert.Nil(t, err)
+
+	w = httptest.NewRecorder()
+	Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+}
+
 func TestUserView(t *testing.T) {
 	startup()
 	defer cleanup()
 
-	req, err := http.NewRequest("GET", fmt.Sprintf("/users/%d", MockUser.ID), nil)
+	req, err := http.NewRequest("GET", "/v1/users/"+MockUser.PublicID, nil)
 	assert.Nil(t, err)
 
 	w := httptest.NewRecorder()
 	Router.ServeHTTP(w, req)
 	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("Deprecation"))
+	assert.NotContains(t, w.Body.String(), `"id"`)
 
 	var out model.User
 	dec := json.NewDecoder(w.Body)
 	err = dec.Decode(&out)
 	assert.Nil(t, err)
 	assert.Equal(t, MockUser, out)
+
+	// test if deprecated integer ID is still accepted until sunset
+	req, err = http.NewRequest("GET", fmt.Sprintf("/v1/users/%d", MockUserID), nil)
+	assert.Nil(t, err)
+
+	w = httptest.NewRecorder()
+	Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "true", w.Header().Get("Deprecation"))
+	assert.NotEmpty(t, w.Header().Get("Sunset"))
+
+	out = model.User{}
+	err = json.NewDecoder(w.Body).Decode(&out)
+	assert.Nil(t, err)
+	assert.Equal(t, MockUser, out)
+
+	// test if integer ID is not accepted after sunset and by new routes
+	sunset := API.IntegerIDSunset
+	API.IntegerIDSunset = time.Now()
+	req, err = http.NewRequest("GET", fmt.Sprintf("/v1/users/%d", MockUserID), nil)
+	assert.Nil(t, err)
+
+	w = httptest.NewRecorder()
+	Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	API.IntegerIDSunset = sunset
+
+	req, err = http.NewRequest("GET", fmt.Sprintf("/v1/users/%d/preferences", MockUserID), nil)
+	assert.Nil(t, err)
+
+	w = httptest.NewRecorder()
+	Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
 }
 
-func TestUserIndex(t *testing.T) {
+func TestUserViewNegotiation(t *testing.T) {
 	startup()
 	defer cleanup()
 
-	req, err := http.NewRequest("GET", "/users?country=RU", nil)
+	// test XML response
+	req, err := http.NewRequest("GET", "/v1/users/"+MockUser.PublicID, nil)
 	assert.Nil(t, err)
+	req.Header.Set("Accept", "application/xml")
 
 	w := httptest.NewRecorder()
 	Router.ServeHTTP(w, req)
 	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "application/xml")
 
-	var out []model.User
-	dec := json.NewDecoder(w.Body)
-	err = dec.Decode(&out)
+	var out model.User
+	err = xml.NewDecoder(w.Body).Decode(&out)
+	assert.Nil(t, err)
+	assert.Equal(t, MockUser.PublicID, out.PublicID)
+	assert.Equal(t, MockUser.Email, out.Email)
+
+	// test unsupported response type
+	req, err = http.NewRequest("GET", "/v1/users/"+MockUser.PublicID, nil)
 	assert.Nil(t, err)
+	req.Header.Set("Accept", "text/html")
 
-	var userFound model.User
-	for _, user := range out {
-		assert.Equal(t, "RU", user.Country)
+	w = httptest.NewRecorder()
+	Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotAcceptable, w.Code)
 
-		if user.ID == MockUser.ID {
-			userFound = user
-		}
-	}
-	assert.Equal(t, MockUser, userFound)
+	// test unsupported request type
+	req, err = http.NewRequest("PUT", "/v1/users/"+MockUser.PublicID, strings.NewReader("<user/>"))
+	assert.Nil(t, err)
+	req.Header.Set("Content-Type", "text/plain")
+
+	w = httptest.NewRecorder()
+	Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
 }
 
-func TestUserUpdate(t *testing.T) {
+func TestUserViewUnversioned(t *testing.T) {
 	startup()
 	defer cleanup()
 
-	// test if updates
-	var in = MockUserInput
-	in.Country = "UK"
-	data, err := json.Marshal(in)
+	req, err := http.NewRequest("GET", "/users/"+MockUser.PublicID, nil)
+	assert.Nil(t, err)
+
+	w := httptest.NewRecorder()
+	Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "true", w.Header().Get("Deprecation"))
+	assert.NotEmpty(t, w.Header().Get("Sunset"))
+	assert.Equal(t, fmt.Sprintf(`</v1/users/%s>; rel="successor-version"`, MockUser.PublicID), w.Header().Get("Link"))
+
+	var out model.User
+	err = json.NewDecoder(w.Body).Decode(&out)
 	assert.Nil(t, err)
+	assert.Equal(t, MockUser, out)
+}
+
+func TestUserViewV2(t *testing.T) {
+	startup()
+	defer cleanup()
 
-	req, err := http.NewRequest("PUT", fmt.Sprintf("/users/%d", MockUser.ID), bytes.NewReader(data))
+	req, err := http.NewRequest("GET", "/v2/users/"+MockUser.PublicID, nil)
 	assert.Nil(t, err)
 
 	w := httptest.NewRecorder()
 	Router.ServeHTTP(w, req)
-	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("Deprecation"))
 
-	// test if is updated
-	req, err = http.NewRequest("GET", fmt.Sprintf("/users/%d", MockUser.ID), nil)
+	var out api.UserV2
+	err = json.NewDecoder(w.Body).Decode(&out)
+	assert.Nil(t, err)
+	assert.Equal(t, MockUser.PublicID, out.ID)
+	assert.Equal(t, MockUser.FirstName, out.Name.First)
+	assert.Equal(t, MockUser.LastName, out.Name.Last)
+
+	// test if integer IDs are not accepted
+	req, err = http.NewRequest("GET", fmt.Sprintf("/v2/users/%d", MockUserID), nil)
+	assert.Nil(t, err)
+
+	w = httptest.NewRecorder()
+	Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestGRPCUserService(t *testing.T) {
+	startup()
+	defer cleanup()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+
+	s := API.NewGRPCServer()
+	go func() { _ = s.Serve(lis) }()
+	defer s.Stop()
+
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithInsecure())
+	assert.Nil(t, err)
+	defer func() { _ = conn.Close() }()
+
+	ctx := context.Background()
+
+	// test health check
+	health, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	assert.Nil(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, health.Status)
+
+	// test if organization is required by anyone but administrators
+	client := usersv1.NewUserServiceClient(conn)
+	_, err = client.GetUser(ctx, &usersv1.GetUserRequest{PublicId: MockUser.PublicID})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	adminCtx := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+MockAdminToken)
+	_, err = client.GetUser(adminCtx, &usersv1.GetUserRequest{PublicId: MockUser.PublicID})
+	assert.Nil(t, err)
+
+	// test if returns the same user as RESTful API
+	ctx = metadata.AppendToOutgoingContext(ctx, "x-organization-id", MockOrgID)
+	user, err := client.GetUser(ctx, &usersv1.GetUserRequest{PublicId: MockUser.PublicID})
+	assert.Nil(t, err)
+	assert.Equal(t, MockUser.Email, user.Email)
+	assert.Equal(t, MockUser.Country, user.Country)
+	assert.Equal(t, MockUser.PublicID, user.PublicId)
+
+	// test for failure
+	_, err = client.GetUser(ctx, &usersv1.GetUserRequest{PublicId: strconv.Itoa(MockUserID)})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = client.CreateUser(ctx, &usersv1.CreateUserRequest{User: &usersv1.UserInput{Email: "invalid"}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestGraphQL(t *testing.T) {
+	startup()
+	defer cleanup()
+
+	query := fmt.Sprintf(`{
+		a: user(id: "%s") { id email country }
+		b: user(id: "-1") { id }
+		users(filter: {country: "RU"}, first: 1) {
+			edges { cursor node { id country } }
+			pageInfo { hasNextPage endCursor }
+		}
+	}`, MockUser.PublicID)
+	data, err := json.Marshal(map[string]interface{}{"query": query})
+	assert.Nil(t, err)
+
+	req, err := http.NewRequest("POST", "/graphql", bytes.NewReader(data))
+	assert.Nil(t, err)
+
+	w := httptest.NewRecorder()
+	Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var out struct {
+		Data struct {
+			A *struct {
+				ID      string `json:"id"`
+				Email   string `json:"email"`
+				Country string `json:"country"`
+			} `json:"a"`
+			B     *struct{} `json:"b"`
+			Users struct {
+				Edges []struct {
+					Cursor string `json:"cursor"`
+					Node   struct {
+						Country string `json:"country"`
+					} `json:"node"`
+				} `json:"edges"`
+			} `json:"users"`
+		} `json:"data"`
+		Errors []interface{} `json:"errors"`
+	}
+	err = json.NewDecoder(w.Body).Decode(&out)
+	assert.Nil(t, err)
+	assert.Empty(t, out.Errors)
+	assert.Equal(t, MockUser.PublicID, out.Data.A.ID)
+	assert.Equal(t, MockUser.Email, out.Data.A.Email)
+	assert.Nil(t, out.Data.B)
+	assert.Len(t, out.Data.Users.Edges, 1)
+	assert.Equal(t, "RU", out.Data.Users.Edges[0].Node.Country)
+	assert.NotEmpty(t, out.Data.Users.Edges[0].Cursor)
+
+	// zero returns no edges, but tells if there are any
+	query = `{ users(first: 0) { edges { cursor } pageInfo { hasNextPage } } }`
+	data, err = json.Marshal(map[string]interface{}{"query": query})
+	assert.Nil(t, err)
+
+	req, err = http.NewRequest("POST", "/graphql", bytes.NewReader(data))
 	assert.Nil(t, err)
 
 	w = httptest.NewRecorder()
 	Router.ServeHTTP(w, req)
 	assert.Equal(t, http.StatusOK, w.Code)
 
-	var out model.User
-	dec := json.NewDecoder(w.Body)
-	err = dec.Decode(&out)
+	var page struct {
+		Data *struct {
+			Users struct {
+				Edges    []interface{} `json:"edges"`
+				PageInfo struct {
+					HasNextPage bool `json:"hasNextPage"`
+				} `json:"pageInfo"`
+			} `json:"users"`
+		} `json:"data"`
+		Errors []interface{} `json:"errors"`
+	}
+	err = json.NewDecoder(w.Body).Decode(&page)
 	assert.Nil(t, err)
-	assert.Equal(t, in.Country, out.Country)
+	assert.Empty(t, page.Errors)
+	if assert.NotNil(t, page.Data) {
+		assert.Empty(t, page.Data.Users.Edges)
+		assert.True(t, page.Data.Users.PageInfo.HasNextPage)
+	}
 
-	// here we can write more test cases for various scenarios + NSQ publish...
+	// negative is rejected
+	data, err = json.Marshal(map[string]interface{}{"query": `{ users(first: -1) { edges { cursor } } }`})
+	assert.Nil(t, err)
+
+	req, err = http.NewRequest("POST", "/graphql", bytes.NewReader(data))
+	assert.Nil(t, err)
+
+	w = httptest.NewRecorder()
+	Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	page.Data, page.Errors = nil, nil
+	err = json.NewDecoder(w.Body).Decode(&page)
+	assert.Nil(t, err)
+	assert.Nil(t, page.Data)
+	assert.Len(t, page.Errors, 1)
 }
 
-func TestUserDelete(t *testing.T) {
+func TestUserLogin(t *testing.T) {
 	startup()
 	defer cleanup()
 
-	req, err := http.NewRequest("DELETE", fmt.Sprintf("/users/%d", MockUser.ID), nil)
+	data, err := json.Marshal(api.LoginInput{Email: MockUserInput.Email, Password: MockUserInput.Password})
+	assert.Nil(t, err)
+
+	// test for success
+	req, err := http.NewRequest("POST", "/v1/login", bytes.NewReader(data))
 	assert.Nil(t, err)
 
 	w := httptest.NewRecorder()
 	Router.ServeHTTP(w, req)
-	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var out model.User
+	err = json.NewDecoder(w.Body).Decode(&out)
+	assert.Nil(t, err)
+	assert.Equal(t, MockUser.PublicID, out.PublicID)
+	assert.NotNil(t, out.LastLoginAt)
+	assert.Equal(t, MockUser.UpdatedAt, out.UpdatedAt)
+	MockUser.LastLoginAt = out.LastLoginAt
+
+	// test for success with email in another case
+	data, err = json.Marshal(api.LoginInput{Email: strings.ToUpper(MockUserInput.Email), Password: MockUserInput.Password})
+	assert.Nil(t, err)
+
+	req, err = http.NewRequest("POST", "/v1/login", bytes.NewReader(data))
+	assert.Nil(t, err)
+
+	w = httptest.NewRecorder()
+	Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	err = json.NewDecoder(w.Body).Decode(&out)
+	assert.Nil(t, err)
+	MockUser.LastLoginAt = out.LastLoginAt
+
+	// test for failure
+	data, err = json.Marshal(api.LoginInput{Email: MockUserInput.Email, Password: "WrongPassword"})
+	assert.Nil(t, err)
+
+	req, err = http.NewRequest("POST", "/v1/login", bytes.NewReader(data))
+	assert.Nil(t, err)
+
+	w = httptest.NewRecorder()
+	Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	var problem common.Problem
+	err = json.NewDecoder(w.Body).Decode(&problem)
+	assert.Nil(t, err)
+	assert.Equal(t, common.ErrCodeInvalidCredentials, problem.Code)
+}
+
+func TestUserIndex(t *testing.T) {
+	startup()
+	defer cleanup()
+
+	req, err := http.NewRequest("GET", "/v1/users?country=RU", nil)
+	assert.Nil(t, err)
+
+	w := httptest.NewRecorder()
+	Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var out []model.User
+	dec := json.NewDecoder(w.Body)
+	err = dec.Decode(&out)
+	assert.Nil(t, err)
+
+	var userFound model.User
+	for _, user := range out {
+		assert.Equal(t, "RU", user.Country)
+
+		if user.PublicID == MockUser.PublicID {
+			userFound = user
+		}
+	}
+	assert.Equal(t, MockUser, userFound)
+
+	// test filtering and sorting by timestamps
+	query := "created_after=" + url.QueryEscape(MockUser.CreatedAt.Format(time.RFC3339Nano)) + "&sort=-last_login_at"
+	req, err = http.NewRequest("GET", "/v1/users?"+query, nil)
+	assert.Nil(t, err)
+
+	w = httptest.NewRecorder()
+	Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	out = nil
+	err = json.NewDecoder(w.Body).Decode(&out)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, out)
+	for i, user := range out {
+		assert.False(t, user.CreatedAt.Before(MockUser.CreatedAt))
+		if i > 0 && user.LastLoginAt != nil {
+			assert.NotNil(t, out[i-1].LastLoginAt)
+			assert.False(t, user.LastLoginAt.After(*out[i-1].LastLoginAt))
+		}
+	}
+
+	// test for invalid query
+	for _, query := range []string{"created_before=yesterday", "sort=password"} {
+		req, err = http.NewRequest("GET", "/v1/users?"+query, nil)
+		assert.Nil(t, err)
+
+		w = httptest.NewRecorder()
+		Router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		var problem common.Problem
+		err = json.NewDecoder(w.Body).Decode(&problem)
+		assert.Nil(t, err)
+		assert.Equal(t, common.ErrCodeInvalidQuery, problem.Code)
+	}
+}
+
+func TestEmailDuplicates(t *testing.T) {
+	startup()
+	defer cleanup()
+
+	// test for failure
+	req, err := http.NewRequest("GET", "/v1/email-duplicates", nil)
+	assert.Nil(t, err)
+
+	w := httptest.NewRecorder()
+	Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// test for success (new users can't collide, so the user is not reported)
+	req, err = http.NewRequest("GET", "/v1/email-duplicates", nil)
+	assert.Nil(t, err)
+	req.Header.Set("Authorization", "Bearer "+MockAdminToken)
+
+	w = httptest.NewRecorder()
+	Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var out []api.EmailDuplicate
+	err = json.NewDecoder(w.Body).Decode(&out)
+	assert.Nil(t, err)
+	assert.NotNil(t, out)
+	for _, d := range out {
+		assert.True(t, len(d.Users) > 1)
+		for _, u := range d.Users {
+			assert.NotEqual(t, MockUser.PublicID, u.PublicID)
+		}
+	}
+}
+
+func TestCountryIndex(t *testing.T) {
+	startup()
+	defer cleanup()
+
+	req, err := http.NewRequest("GET", "/v1/countries", nil)
+	assert.Nil(t, err)
+	req.Header.Set("Accept-Language", "ru")
+
+	w := httptest.NewRecorder()
+	Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "ru", w.Header().Get("Content-Language"))
+
+	var out []api.Country
+	err = json.NewDecoder(w.Body).Decode(&out)
+	assert.Nil(t, err)
+	assert.Len(t, out, len(common.Countries()))
+
+	// test if names are localized and user is counted
+	for _, c := range out {
+		if c.Code == MockUser.Country {
+			assert.Equal(t, "Россия", c.Name)
+			assert.True(t, c.Users > 0)
+		}
+	}
+}
+
+func TestUserStatus(t *testing.T) {
+	startup()
+	defer cleanup()
+
+	changeStatus := func(action string, in api.UserStatusInput, admin bool) *httptest.ResponseRecorder {
+		data, err := json.Marshal(in)
+		assert.Nil(t, err)
+
+		req, err := http.NewRequest("POST", fmt.Sprintf("/v1/users/%s/%s", MockUser.PublicID, action), bytes.NewReader(data))
+		assert.Nil(t, err)
+		if admin {
+			req.Header.Set("Authorization", "Bearer "+MockAdminToken)
+		}
+
+		w := httptest.NewRecorder()
+		Router.ServeHTTP(w, req)
+		return w
+	}
+	login := func() *httptest.ResponseRecorder {
+		data, err := json.Marshal(api.LoginInput{Email: MockUserInput.Email, Password: MockUserInput.Password})
+		assert.Nil(t, err)
+
+		req, err := http.NewRequest("POST", "/v1/login", bytes.NewReader(data))
+		assert.Nil(t, err)
+
+		w := httptest.NewRecorder()
+		Router.ServeHTTP(w, req)
+		return w
+	}
+	expiresAt := time.Now().Add(time.Hour)
+
+	// test for administrators only
+	w := changeStatus("suspend", api.UserStatusInput{Reason: "Spam"}, false)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// test for validation failure
+	w = changeStatus("suspend", api.UserStatusInput{}, true)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	var problem common.Problem
+	err := json.NewDecoder(w.Body).Decode(&problem)
+	assert.Nil(t, err)
+	assert.Equal(t, "reason", problem.Errors[0].Field)
+
+	// test suspension
+	w = changeStatus("suspend", api.UserStatusInput{Reason: "Spam", ExpiresAt: &expiresAt}, true)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var out model.User
+	err = json.NewDecoder(w.Body).Decode(&out)
+	assert.Nil(t, err)
+	assert.Equal(t, model.UserStatusSuspended, out.Status)
+	assert.Equal(t, "Spam", out.StatusReason)
+	assert.NotNil(t, out.StatusExpiresAt)
+
+	w = login()
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	problem = common.Problem{}
+	err = json.NewDecoder(w.Body).Decode(&problem)
+	assert.Nil(t, err)
+	assert.Equal(t, common.ErrCodeUserSuspended, problem.Code)
+
+	// test filtering by status
+	req, err := http.NewRequest("GET", "/v1/users?status=suspended", nil)
+	assert.Nil(t, err)
+
+	w = httptest.NewRecorder()
+	Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var users []model.User
+	err = json.NewDecoder(w.Body).Decode(&users)
+	assert.Nil(t, err)
+
+	var userFound model.User
+	for _, user := range users {
+		assert.Equal(t, model.UserStatusSuspended, user.Status)
+
+		if user.PublicID == MockUser.PublicID {
+			userFound = user
+		}
+	}
+	assert.Equal(t, MockUser.PublicID, userFound.PublicID)
+
+	// test ban and invalid transition
+	w = changeStatus("ban", api.UserStatusInput{Reason: "Spam again"}, true)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = changeStatus("suspend", api.UserStatusInput{Reason: "Spam"}, true)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	problem = common.Problem{}
+	err = json.NewDecoder(w.Body).Decode(&problem)
+	assert.Nil(t, err)
+	assert.Equal(t, common.ErrCodeStatusTransition, problem.Code)
+
+	// test activation
+	w = changeStatus("activate", api.UserStatusInput{}, true)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	out = model.User{}
+	err = json.NewDecoder(w.Body).Decode(&out)
+	assert.Nil(t, err)
+	assert.Equal(t, model.UserStatusActive, out.Status)
+	assert.Nil(t, out.StatusExpiresAt)
+
+	w = login()
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestUserUpdate(t *testing.T) {
+	startup()
+	defer cleanup()
+
+	// test if updates
+	var in = MockUserInput
+	in.Country = "UK"
+	data, err := json.Marshal(in)
+	assert.Nil(t, err)
+
+	req, err := http.NewRequest("PUT", "/v1/users/"+MockUser.PublicID, bytes.NewReader(data))
+	assert.Nil(t, err)
+	req.Header.Set("X-Request-ID", MockRequestID)
+
+	w := httptest.NewRecorder()
+	Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, MockRequestID, w.Header().Get("X-Request-ID"))
+
+	// test if is updated
+	req, err = http.NewRequest("GET", "/v1/users/"+MockUser.PublicID, nil)
+	assert.Nil(t, err)
+
+	w = httptest.NewRecorder()
+	Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var out model.User
+	dec := json.NewDecoder(w.Body)
+	err = dec.Decode(&out)
+	assert.Nil(t, err)
+
+	// alias is stored as ISO 3166-1 code
+	assert.Equal(t, "GB", out.Country)
+
+	// test if unknown country is rejected
+	in.Country = "ZZ"
+	data, err = json.Marshal(in)
+	assert.Nil(t, err)
+
+	req, err = http.NewRequest("PUT", "/v1/users/"+MockUser.PublicID, bytes.NewReader(data))
+	assert.Nil(t, err)
+
+	w = httptest.NewRecorder()
+	Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), `"rule":"country"`)
+
+	// here we can write more test cases for various scenarios + NSQ publish...
+}
+
+func TestUserHistory(t *testing.T) {
+	startup()
+	defer cleanup()
+
+	// test for administrators only
+	req, err := http.NewRequest("GET", fmt.Sprintf("/v1/users/%s/history?limit=1", MockUser.PublicID), nil)
+	assert.Nil(t, err)
+
+	w := httptest.NewRecorder()
+	Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// test if the latest change is the update
+	req.Header.Set("Authorization", "Bearer "+MockAdminToken)
+	w = httptest.NewRecorder()
+	Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var out []model.UserAudit
+	err = json.NewDecoder(w.Body).Decode(&out)
+	assert.Nil(t, err)
+	assert.Len(t, out, 1)
+	assert.Equal(t, model.UserAuditUpdate, out[0].Action)
+	assert.Equal(t, "anonymous", out[0].Actor)
+	assert.Equal(t, MockRequestID, out[0].RequestID)
+	assert.Equal(t, model.UserAuditChanges{"country": {Before: "RU", After: "GB"}}, out[0].Changes)
+
+	// test pagination to the first change
+	link := w.Header().Get("Link")
+	assert.Contains(t, link, `rel="next"`)
+
+	req, err = http.NewRequest("GET", fmt.Sprintf("/v1/users/%s/history?before=%d", MockUser.PublicID, out[0].ID), nil)
+	assert.Nil(t, err)
+	req.Header.Set("Authorization", "Bearer "+MockAdminToken)
+
+	w = httptest.NewRecorder()
+	Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("Link"))
+
+	out = nil
+	err = json.NewDecoder(w.Body).Decode(&out)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, out)
+
+	first := out[len(out)-1]
+	assert.Equal(t, model.UserAuditCreate, first.Action)
+	assert.Equal(t, MockUser.Email, first.Changes["email"].After)
+	assert.NotContains(t, first.Changes, "password")
+}
+
+func TestUserAvatar(t *testing.T) {
+	startup()
+	defer cleanup()
+
+	// opaque PNG image of 300x200 pixels
+	img := image.NewRGBA(image.Rect(0, 0, 300, 200))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.RGBA{255, 0, 0, 255}), image.Point{}, draw.Src)
+	var buf bytes.Buffer
+	assert.Nil(t, png.Encode(&buf, img))
+
+	// test if uploads raw image
+	req, err := http.NewRequest("PUT", fmt.Sprintf("/v1/users/%s/avatar", MockUser.PublicID), bytes.NewReader(buf.Bytes()))
+	assert.Nil(t, err)
+	req.Header.Set("Content-Type", "image/png")
+
+	w := httptest.NewRecorder()
+	Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var out model.User
+	err = json.NewDecoder(w.Body).Decode(&out)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, out.AvatarURL)
+	assert.Len(t, out.AvatarThumbnails, 4)
+
+	// test if thumbnail is square and served
+	thumbnail := out.AvatarThumbnails[1]
+	req, err = http.NewRequest("GET", thumbnail.URL, nil)
+	assert.Nil(t, err)
+
+	w = httptest.NewRecorder()
+	Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	cfg, format, err := image.DecodeConfig(w.Body)
+	assert.Nil(t, err)
+	assert.Equal(t, "jpeg", format)
+	assert.Equal(t, thumbnail.Size, cfg.Width)
+	assert.Equal(t, thumbnail.Size, cfg.Height)
+
+	// test if uploads multipart form (v2)
+	body := new(bytes.Buffer)
+	mw := multipart.NewWriter(body)
+	fw, err := mw.CreateFormFile("avatar", "avatar.png")
+	assert.Nil(t, err)
+	_, err = fw.Write(buf.Bytes())
+	assert.Nil(t, err)
+	assert.Nil(t, mw.Close())
+
+	req, err = http.NewRequest("PUT", fmt.Sprintf("/v2/users/%s/avatar", MockUser.PublicID), body)
+	assert.Nil(t, err)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	w = httptest.NewRecorder()
+	Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var outV2 api.UserV2
+	err = json.NewDecoder(w.Body).Decode(&outV2)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, outV2.AvatarURL)
+	assert.NotEqual(t, out.AvatarURL, outV2.AvatarURL)
+
+	// test if the replaced avatar is deleted
+	req, err = http.NewRequest("GET", thumbnail.URL, nil)
+	assert.Nil(t, err)
+
+	w = httptest.NewRecorder()
+	Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// test if content is validated regardless of declared type
+	req, err = http.NewRequest("PUT", fmt.Sprintf("/v1/users/%s/avatar", MockUser.PublicID), strings.NewReader("not an image"))
+	assert.Nil(t, err)
+	req.Header.Set("Content-Type", "image/png")
+
+	w = httptest.NewRecorder()
+	Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), common.ErrCodeInvalidImage)
+
+	// test size limit
+	API.AvatarMaxSize = 100
+	req, err = http.NewRequest("PUT", fmt.Sprintf("/v1/users/%s/avatar", MockUser.PublicID), bytes.NewReader(buf.Bytes()))
+	assert.Nil(t, err)
+	req.Header.Set("Content-Type", "image/png")
+
+	w = httptest.NewRecorder()
+	Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Contains(t, w.Body.String(), common.ErrCodeAvatarTooLarge)
+}
+
+func TestUserAttributes(t *testing.T) {
+	startup()
+	defer cleanup()
+
+	namespace := fmt.Sprintf("test_%d", rand.Uint32())
+	schema := `{
+		"type": "object",
+		"properties": {"tier": {"enum": ["free", "gold"]}},
+		"required": ["tier"],
+		"additionalProperties": false
+	}`
+
+	// test if schema is registered by administrators only
+	req, err := http.NewRequest("PUT", "/v1/attribute-schemas/"+namespace, strings.NewReader(schema))
+	assert.Nil(t, err)
+
+	w := httptest.NewRecorder()
+	Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	req, err = http.NewRequest("PUT", "/v1/attribute-schemas/"+namespace, strings.NewReader(schema))
+	assert.Nil(t, err)
+	req.Header.Set("Authorization", "Bearer "+MockAdminToken)
+
+	w = httptest.NewRecorder()
+	Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// test if invalid schema is rejected
+	req, err = http.NewRequest("PUT", "/v1/attribute-schemas/"+namespace, strings.NewReader(`{"type": 42}`))
+	assert.Nil(t, err)
+	req.Header.Set("Authorization", "Bearer "+MockAdminToken)
+
+	w = httptest.NewRecorder()
+	Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), common.ErrCodeInvalidAttributeSchema)
+
+	// test if value is validated
+	url := fmt.Sprintf("/v1/users/%s/attributes/%s", MockUser.PublicID, namespace)
+	req, err = http.NewRequest("PUT", url, strings.NewReader(`{"tier": "platinum"}`))
+	assert.Nil(t, err)
+
+	w = httptest.NewRecorder()
+	Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), "attributes."+namespace+".tier")
+
+	// test if value is set
+	req, err = http.NewRequest("PUT", url, strings.NewReader(`{"tier": "gold"}`))
+	assert.Nil(t, err)
+
+	w = httptest.NewRecorder()
+	Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var out model.User
+	err = json.NewDecoder(w.Body).Decode(&out)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"tier": "gold"}, out.Attributes[namespace])
+
+	// test if users are filtered by attribute
+	for value, found := range map[string]bool{"gold": true, "free": false} {
+		req, err = http.NewRequest("GET", fmt.Sprintf("/v1/users?attr.%s.tier=%s", namespace, value), nil)
+		assert.Nil(t, err)
+
+		w = httptest.NewRecorder()
+		Router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var users []model.User
+		err = json.NewDecoder(w.Body).Decode(&users)
+		assert.Nil(t, err)
+		if found {
+			assert.Len(t, users, 1)
+		} else {
+			assert.Empty(t, users)
+		}
+	}
+
+	// test unregistered namespace
+	req, err = http.NewRequest("PUT", fmt.Sprintf("/v1/users/%s/attributes/unknown", MockUser.PublicID), strings.NewReader(`{}`))
+	assert.Nil(t, err)
+
+	w = httptest.NewRecorder()
+	Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// test if value is deleted
+	req, err = http.NewRequest("DELETE", url, nil)
+	assert.Nil(t, err)
+
+	w = httptest.NewRecorder()
+	Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	user, err := API.FindUser(MockUserID)
+	assert.Nil(t, err)
+	assert.NotContains(t, user.Attributes, namespace)
+}
+
+func TestUserPreferences(t *testing.T) {
+	startup()
+	defer cleanup()
+
+	url := fmt.Sprintf("/v1/users/%s/preferences", MockUser.PublicID)
+
+	// test if defaults are returned
+	req, err := http.NewRequest("GET", url, nil)
+	assert.Nil(t, err)
+
+	w := httptest.NewRecorder()
+	Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var out model.Preferences
+	err = json.NewDecoder(w.Body).Decode(&out)
+	assert.Nil(t, err)
+	assert.Equal(t, model.DefaultPreferences, out)
+
+	// test partial update
+	req, err = http.NewRequest("PUT", url, strings.NewReader(`{"locale": "en-gb", "notifications": {"push": false}}`))
+	assert.Nil(t, err)
+
+	w = httptest.NewRecorder()
+	Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	req, err = http.NewRequest("PUT", url, strings.NewReader(`{"theme": "dark"}`))
+	assert.Nil(t, err)
+
+	w = httptest.NewRecorder()
+	Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	out = model.Preferences{}
+	err = json.NewDecoder(w.Body).Decode(&out)
+	assert.Nil(t, err)
+	assert.Equal(t, "en-GB", out.Locale)
+	assert.Equal(t, model.DefaultPreferences.Timezone, out.Timezone)
+	assert.Equal(t, model.ThemeDark, out.Theme)
+	assert.False(t, out.Notifications.Push)
+	assert.Equal(t, model.DefaultPreferences.Notifications.Email, out.Notifications.Email)
+
+	// test validation
+	for _, body := range []string{`{"timezone": "Mars/Olympus"}`, `{"theme": "pink"}`, `{"locale": "not a locale"}`} {
+		req, err = http.NewRequest("PUT", url, strings.NewReader(body))
+		assert.Nil(t, err)
+
+		w = httptest.NewRecorder()
+		Router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	}
+}
+
+func TestUserPhone(t *testing.T) {
+	startup()
+	defer cleanup()
+
+	post := func(path, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", fmt.Sprintf("/v1/users/%s/phone/%s", MockUser.PublicID, path), strings.NewReader(body))
+		assert.Nil(t, err)
+
+		w := httptest.NewRecorder()
+		Router.ServeHTTP(w, req)
+		return w
+	}
+
+	// test if national number is normalized with user country
+	var in = MockUserInput
+	in.Country = "GB"
+	in.Phone = "07700 900123"
+	data, err := json.Marshal(in)
+	assert.Nil(t, err)
+
+	req, err := http.NewRequest("PUT", "/v1/users/"+MockUser.PublicID, bytes.NewReader(data))
+	assert.Nil(t, err)
+
+	w := httptest.NewRecorder()
+	Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	user, err := API.FindUser(MockUserID)
+	assert.Nil(t, err)
+	assert.Equal(t, "+447700900123", user.Phone)
+	assert.Nil(t, user.PhoneVerifiedAt)
+
+	// test for failure without code sent
+	w = post("verification/confirm", `{"code":"123456"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), common.ErrCodeInvalidVerificationCode)
+
+	// test if code is sent and can't be sent again right away
+	w = post("verification", "")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	message, ok := API.SMS.(*common.MemorySMSSender).LastMessage(user.Phone)
+	assert.True(t, ok)
+	code := message.Text[len(message.Text)-6:]
+
+	w = post("verification", "")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Contains(t, w.Body.String(), common.ErrCodeVerificationTooSoon)
+
+	// test for failure with wrong code
+	w = post("verification/confirm", `{"code":"wrong"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	// test if concurrent wrong codes can't exceed the limit of attempts
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			post("verification/confirm", `{"code":"wrong"}`)
+		}()
+	}
+	wg.Wait()
+
+	w = post("verification/confirm", fmt.Sprintf(`{"code":"%s"}`, code))
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), common.ErrCodeInvalidVerificationCode)
+
+	// test if only one of concurrent requests sends the next code
+	err = API.DB.Model(&model.PhoneVerification{}).Where("user_id = ?", MockUserID).
+		UpdateColumn("created_at", time.Now().Add(-time.Minute)).Error
+	assert.Nil(t, err)
+
+	statuses := make(chan int, 5)
+	for i := 0; i < cap(statuses); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			statuses <- post("verification", "").Code
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+
+	sent := 0
+	for status := range statuses {
+		if status == http.StatusNoContent {
+			sent++
+		} else {
+			assert.Equal(t, http.StatusTooManyRequests, status)
+		}
+	}
+	assert.Equal(t, 1, sent)
+
+	message, ok = API.SMS.(*common.MemorySMSSender).LastMessage(user.Phone)
+	assert.True(t, ok)
+	code = message.Text[len(message.Text)-6:]
+
+	// test for success
+	w = post("verification/confirm", fmt.Sprintf(`{"code":"%s"}`, code))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var out model.User
+	err = json.NewDecoder(w.Body).Decode(&out)
+	assert.Nil(t, err)
+	assert.Equal(t, user.Phone, out.Phone)
+	assert.NotNil(t, out.PhoneVerifiedAt)
+
+	w = post("verification", "")
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), common.ErrCodePhoneVerified)
+
+	// test for failure with invalid phone
+	in.Phone = "12"
+	data, err = json.Marshal(in)
+	assert.Nil(t, err)
+
+	req, err = http.NewRequest("PUT", "/v1/users/"+MockUser.PublicID, bytes.NewReader(data))
+	assert.Nil(t, err)
+
+	w = httptest.NewRecorder()
+	Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), `"rule":"phone"`)
+}
+
+func TestOrganizations(t *testing.T) {
+	startup()
+	defer cleanup()
+
+	// test if only administrators create organizations
+	w := request(t, "POST", "/v1/organizations", `{"name": "Acme Corporation"}`, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = request(t, "POST", "/v1/organizations", `{"name": "Acme Corporation"}`, MockAdminHeaders)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var org model.Organization
+	err := json.NewDecoder(w.Body).Decode(&org)
+	assert.Nil(t, err)
+	assert.True(t, common.IsUUID(org.PublicID))
+	assert.Equal(t, "Acme Corporation", org.Name)
+	orgHeaders := map[string]string{api.OrganizationHeader: org.PublicID}
+
+	// test if unknown organization is not found
+	w = request(t, "GET", "/v1/users", "", map[string]string{api.OrganizationHeader: common.NewUUIDv7()})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// test if organization is required by anyone but administrators
+	w = request(t, "GET", "/v1/users", "", map[string]string{api.OrganizationHeader: ""})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), common.ErrCodeOrganizationRequired)
+
+	w = request(t, "GET", "/v1/users", "", MockAdminHeaders)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// test if users who are not members are isolated
+	userURL := fmt.Sprintf("/v1/users/%s", MockUser.PublicID)
+	w = request(t, "GET", userURL, "", orgHeaders)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	var users []model.User
+	w = request(t, "GET", "/v1/users", "", orgHeaders)
+	assert.Equal(t, http.StatusOK, w.Code)
+	err = json.NewDecoder(w.Body).Decode(&users)
+	assert.Nil(t, err)
+	assert.Empty(t, users)
+
+	// test membership
+	memberURL := fmt.Sprintf("/v1/organizations/%s/members/%s", org.PublicID, MockUser.PublicID)
+	w = request(t, "PUT", memberURL, `{"role": "root"}`, MockAdminHeaders)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	w = request(t, "PUT", memberURL, `{"role": "admin"}`, MockAdminHeaders)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var member api.Member
+	err = json.NewDecoder(w.Body).Decode(&member)
+	assert.Nil(t, err)
+	assert.Equal(t, MockUser.PublicID, member.UserID)
+	assert.Equal(t, model.OrgRoleAdmin, member.Role)
+
+	var members []api.Member
+	w = request(t, "GET", fmt.Sprintf("/v1/organizations/%s/members", org.PublicID), "", orgHeaders)
+	assert.Equal(t, http.StatusOK, w.Code)
+	err = json.NewDecoder(w.Body).Decode(&members)
+	assert.Nil(t, err)
+	if assert.Len(t, members, 1) {
+		assert.Equal(t, MockUser.PublicID, members[0].UserID)
+	}
+
+	// test if members are visible in scope of the organization and by filter
+	w = request(t, "GET", userURL, "", orgHeaders)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	users = nil
+	w = request(t, "GET", "/v1/users?organization="+org.PublicID, "", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	err = json.NewDecoder(w.Body).Decode(&users)
+	assert.Nil(t, err)
+	assert.Len(t, users, 1)
+
+	// test if organizations and their members are hidden from anonymous requests and other organizations
+	membersURL := fmt.Sprintf("/v1/organizations/%s/members", org.PublicID)
+	for _, url := range []string{"/v1/organizations", membersURL} {
+		w = request(t, "GET", url, "", map[string]string{api.OrganizationHeader: ""})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), common.ErrCodeOrganizationRequired)
+	}
+
+	w = request(t, "GET", membersURL, "", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.NotContains(t, w.Body.String(), MockUser.Email)
+
+	// test if scoped request sees only its own organization
+	var orgs []model.Organization
+	w = request(t, "GET", "/v1/organizations", "", orgHeaders)
+	assert.Equal(t, http.StatusOK, w.Code)
+	err = json.NewDecoder(w.Body).Decode(&orgs)
+	assert.Nil(t, err)
+	assert.Len(t, orgs, 1)
+
+	// test removal of the member and the organization
+	w = request(t, "DELETE", memberURL, "", MockAdminHeaders)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	w = request(t, "GET", userURL, "", orgHeaders)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	orgURL := fmt.Sprintf("/v1/organizations/%s", org.PublicID)
+	w = request(t, "DELETE", orgURL, "", MockAdminHeaders)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	w = request(t, "GET", orgURL, "", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestGroups(t *testing.T) {
+	startup()
+	defer cleanup()
+
+	// test nested groups
+	var parent, child api.Group
+	w := request(t, "POST", "/v1/groups", `{"name": "Engineering"}`, MockAdminHeaders)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	err := json.NewDecoder(w.Body).Decode(&parent)
+	assert.Nil(t, err)
+	assert.Nil(t, parent.ParentID)
+
+	w = request(t, "POST", "/v1/groups", fmt.Sprintf(`{"name": "Backend", "parent_id": "%s"}`, parent.ID), MockAdminHeaders)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	err = json.NewDecoder(w.Body).Decode(&child)
+	assert.Nil(t, err)
+	if assert.NotNil(t, child.ParentID) {
+		assert.Equal(t, parent.ID, *child.ParentID)
+	}
+
+	// test if group can't be nested into itself
+	w = request(t, "PUT", "/v1/groups/"+parent.ID, fmt.Sprintf(`{"name": "Engineering", "parent_id": "%s"}`, child.ID), MockAdminHeaders)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	w = request(t, "PUT", "/v1/groups/"+parent.ID, fmt.Sprintf(`{"name": "Engineering", "parent_id": "%s"}`, common.NewUUIDv7()), MockAdminHeaders)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	// test transitive membership
+	w = request(t, "PUT", fmt.Sprintf("/v1/groups/%s/members/%s", child.ID, MockUser.PublicID), "", MockAdminHeaders)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	var groups []api.UserGroup
+	w = request(t, "GET", fmt.Sprintf("/v1/users/%s/groups", MockUser.PublicID), "", MockAdminHeaders)
+	assert.Equal(t, http.StatusOK, w.Code)
+	err = json.NewDecoder(w.Body).Decode(&groups)
+	assert.Nil(t, err)
+	if assert.Len(t, groups, 2) {
+		assert.Equal(t, parent.ID, groups[0].ID)
+		assert.False(t, groups[0].Direct)
+		assert.Equal(t, child.ID, groups[1].ID)
+		assert.True(t, groups[1].Direct)
+	}
+
+	for query, n := range map[string]int{"": 0, "?transitive=true": 1} {
+		var members []api.GroupMember
+		w = request(t, "GET", fmt.Sprintf("/v1/groups/%s/members%s", parent.ID, query), "", MockAdminHeaders)
+		assert.Equal(t, http.StatusOK, w.Code)
+		err = json.NewDecoder(w.Body).Decode(&members)
+		assert.Nil(t, err)
+		assert.Len(t, members, n)
+	}
+
+	// test if membership is gone with the group
+	w = request(t, "DELETE", "/v1/groups/"+child.ID, "", MockAdminHeaders)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	groups = nil
+	w = request(t, "GET", fmt.Sprintf("/v1/users/%s/groups", MockUser.PublicID), "", MockAdminHeaders)
+	assert.Equal(t, http.StatusOK, w.Code)
+	err = json.NewDecoder(w.Body).Decode(&groups)
+	assert.Nil(t, err)
+	assert.Empty(t, groups)
+
+	w = request(t, "DELETE", "/v1/groups/"+parent.ID, "", MockAdminHeaders)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	w = request(t, "GET", "/v1/groups/"+parent.ID, "", MockAdminHeaders)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// test if groups of organization take only its members
+	org, err := API.CreateOrganization(api.OrganizationInput{Name: "Acme Corporation"})
+	assert.Nil(t, err)
+	defer func() { _ = API.DeleteOrganization(org.PublicID) }()
+	orgHeaders := map[string]string{api.OrganizationHeader: org.PublicID, "Authorization": MockAdminHeaders["Authorization"]}
+
+	var group api.Group
+	w = request(t, "POST", "/v1/groups", `{"name": "Sales"}`, orgHeaders)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	err = json.NewDecoder(w.Body).Decode(&group)
+	assert.Nil(t, err)
+
+	memberURL := fmt.Sprintf("/v1/groups/%s/members/%s", group.ID, MockUser.PublicID)
+	w = request(t, "PUT", memberURL, "", MockAdminHeaders)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), common.ErrCodeNotOrganizationMember)
+
+	w = request(t, "PUT", fmt.Sprintf("/v1/organizations/%s/members/%s", org.PublicID, MockUser.PublicID), `{"role": "member"}`, MockAdminHeaders)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = request(t, "PUT", memberURL, "", MockAdminHeaders)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	// test if groups and their members are hidden from anonymous requests and other organizations
+	membersURL := fmt.Sprintf("/v1/groups/%s/members?transitive=true", group.ID)
+	for _, url := range []string{"/v1/groups", membersURL} {
+		w = request(t, "GET", url, "", map[string]string{api.OrganizationHeader: ""})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), common.ErrCodeOrganizationRequired)
+	}
+
+	for _, url := range []string{"/v1/groups/" + group.ID, membersURL} {
+		w = request(t, "GET", url, "", nil)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.NotContains(t, w.Body.String(), MockUser.Email)
+	}
+
+	var groupList []api.Group
+	w = request(t, "GET", "/v1/groups", "", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	err = json.NewDecoder(w.Body).Decode(&groupList)
+	assert.Nil(t, err)
+	for _, g := range groupList {
+		assert.NotEqual(t, group.ID, g.ID)
+	}
+
+	var members []api.GroupMember
+	w = request(t, "GET", membersURL, "", map[string]string{api.OrganizationHeader: org.PublicID})
+	assert.Equal(t, http.StatusOK, w.Code)
+	err = json.NewDecoder(w.Body).Decode(&members)
+	assert.Nil(t, err)
+	assert.Len(t, members, 1)
+
+	// test if user leaves groups of organization with it
+	w = request(t, "DELETE", fmt.Sprintf("/v1/organizations/%s/members/%s", org.PublicID, MockUser.PublicID), "", MockAdminHeaders)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	members = nil
+	w = request(t, "GET", membersURL, "", MockAdminHeaders)
+	assert.Equal(t, http.StatusOK, w.Code)
+	err = json.NewDecoder(w.Body).Decode(&members)
+	assert.Nil(t, err)
+	assert.Empty(t, members)
+}
+
+func TestInvitations(t *testing.T) {
+	startup()
+	defer cleanup()
+
+	token := func(email string) string {
+		mail, ok := API.Mailer.(*common.MemoryMailer).LastMail(email)
+		assert.True(t, ok)
+		prefix := API.InvitationURL[:strings.Index(API.InvitationURL, "{token}")]
+		token := mail.Body[strings.Index(mail.Body, prefix)+len(prefix):]
+		return token[:strings.IndexByte(token, '/')]
+	}
+
+	// test for failure with email of existing user
+	w := request(t, "POST", "/v1/invitations", fmt.Sprintf(`{"email": "%s"}`, MockUser.Email), MockAdminHeaders)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	// test if invitation is sent only once
+	var invitation api.Invitation
+	email := fmt.Sprintf("invitee.%d@gmail.com", rand.Uint32())
+	w = request(t, "POST", "/v1/invitations", fmt.Sprintf(`{"email": "%s"}`, email), MockAdminHeaders)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	err := json.NewDecoder(w.Body).Decode(&invitation)
+	assert.Nil(t, err)
+	assert.Equal(t, model.InvitationStatusPending, invitation.Status)
+	oldToken := token(email)
+
+	w = request(t, "POST", "/v1/invitations", fmt.Sprintf(`{"email": "%s"}`, email), MockAdminHeaders)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), common.ErrCodeInvitationExists)
+
+	// test if resent invitation invalidates the old token
+	time.Sleep(time.Second)
+	w = request(t, "POST", fmt.Sprintf("/v1/invitations/%s/resend", invitation.ID), "", MockAdminHeaders)
+	assert.Equal(t, http.StatusOK, w.Code)
+	newToken := token(email)
+	assert.NotEqual(t, oldToken, newToken)
+
+	accept := fmt.Sprintf(`{"password": "MyPassword", "first_name": "Alex", "last_name": "Lokhman", "nickname": "Invitee%d", "country": "GB"}`, rand.Uint32())
+	w = request(t, "POST", fmt.Sprintf("/v1/invitations/%s/accept", oldToken), accept, MockAdminHeaders)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), common.ErrCodeInvalidInvitation)
+
+	// test if user is created with the invited email
+	var user model.User
+	w = request(t, "POST", fmt.Sprintf("/v1/invitations/%s/accept", newToken), accept, MockAdminHeaders)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	err = json.NewDecoder(w.Body).Decode(&user)
+	assert.Nil(t, err)
+	assert.Equal(t, email, user.Email)
+
+	// test if accepted invitation can be neither accepted again nor revoked
+	w = request(t, "POST", fmt.Sprintf("/v1/invitations/%s/accept", newToken), accept, MockAdminHeaders)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = request(t, "POST", fmt.Sprintf("/v1/invitations/%s/revoke", invitation.ID), "", MockAdminHeaders)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), common.ErrCodeInvitationNotPending)
+
+	w = request(t, "GET", "/v1/invitations?status=accepted", "", MockAdminHeaders)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), invitation.ID)
+
+	w = request(t, "DELETE", "/v1/users/"+user.PublicID+"?purge=true", "", MockAdminHeaders)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+}
+
+func TestUserDataExport(t *testing.T) {
+	startup()
+	defer cleanup()
+
+	// test if export is pending until processed in background
+	var export api.DataExport
+	w := request(t, "POST", fmt.Sprintf("/v2/users/%s/data-export", MockUser.PublicID), "", MockAdminHeaders)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	err := json.NewDecoder(w.Body).Decode(&export)
+	assert.Nil(t, err)
+	assert.Equal(t, model.DataExportStatusPending, export.Status)
+	assert.Equal(t, MockUser.PublicID, export.UserID)
+	assert.Equal(t, "/v2/exports/"+export.ID, w.Header().Get("Location"))
+
+	w = request(t, "POST", fmt.Sprintf("/v2/users/%s/data-export", MockUser.PublicID), "", MockAdminHeaders)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Contains(t, w.Body.String(), export.ID)
+
+	n, err := API.ProcessDataExports(10)
+	assert.Nil(t, err)
+	assert.True(t, n > 0)
+
+	w = request(t, "GET", "/v2/exports/"+export.ID, "", MockAdminHeaders)
+	assert.Equal(t, http.StatusOK, w.Code)
+	err = json.NewDecoder(w.Body).Decode(&export)
+	assert.Nil(t, err)
+	assert.Equal(t, model.DataExportStatusCompleted, export.Status)
+	assert.NotNil(t, export.ExpiresAt)
+
+	assert.Equal(t, "/v2/exports/"+export.ID+"/download", export.DownloadURL)
+
+	// test if archive is downloaded by administrators only and is not served publicly
+	w = request(t, "GET", export.DownloadURL, "", nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	s := API.Blobs.(*common.FileBlobStore)
+	keys, err := filepath.Glob(filepath.Join(s.Dir, "exports", MockUser.PublicID, "*.zip"))
+	assert.Nil(t, err)
+	for _, key := range keys {
+		w = request(t, "GET", s.BaseURL+"/exports/"+MockUser.PublicID+"/"+filepath.Base(key), "", MockAdminHeaders)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	}
+
+	// test if archive has profile of the user and the manifest
+	w = request(t, "GET", export.DownloadURL, "", MockAdminHeaders)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/zip", w.Header().Get("Content-Type"))
+	data := w.Body.Bytes()
+	assert.Equal(t, export.Size, int64(len(data)))
+
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if assert.Nil(t, err) {
+		files := make(map[string]*zip.File)
+		for _, f := range zr.File {
+			files[f.Name] = f
+		}
+		for _, name := range []string{"profile.json", "preferences.json", "audit.json", "manifest.json"} {
+			assert.Contains(t, files, name)
+		}
+
+		var profile model.User
+		if f, ok := files["profile.json"]; ok {
+			r, err := f.Open()
+			assert.Nil(t, err)
+			err = json.NewDecoder(r).Decode(&profile)
+			assert.Nil(t, err)
+			assert.Equal(t, MockUser.Email, profile.Email)
+		}
+	}
+
+	// test if expired archive is deleted
+	n, err = API.ExpireDataExports(export.ExpiresAt.Add(time.Second), 100)
+	assert.Nil(t, err)
+	assert.True(t, n > 0)
+
+	w = request(t, "GET", "/v2/exports/"+export.ID, "", MockAdminHeaders)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), model.DataExportStatusExpired)
+
+	w = request(t, "GET", "/v2/exports/"+export.ID+"/download", "", MockAdminHeaders)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	w = request(t, "GET", "/v2/exports/"+common.NewUUIDv7(), "", MockAdminHeaders)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestUserErase(t *testing.T) {
+	startup()
+	defer cleanup()
+
+	var in = MockUserInput
+	in.Email = fmt.Sprintf("Alex.Lokhman.%d@gmail.com", rand.Uint32())
+	in.Nickname = fmt.Sprintf("VisioN%d", rand.Uint32())
+	in.Phone = "+447700900123"
+	data, err := json.Marshal(in)
+	assert.Nil(t, err)
+
+	var user model.User
+	w := request(t, "POST", "/v1/users", string(data), MockAdminHeaders)
+	assert.Equal(t, http.StatusOK, w.Code)
+	err = json.NewDecoder(w.Body).Decode(&user)
+	assert.Nil(t, err)
+
+	// test for failure without administrator privileges
+	w = request(t, "POST", fmt.Sprintf("/v2/users/%s/erase", user.PublicID), "", nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// test if personal data is anonymized and the user is deleted
+	w = request(t, "POST", fmt.Sprintf("/v2/users/%s/erase", user.PublicID), "", MockAdminHeaders)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	var erased model.User
+	err = API.DB.Unscoped().Where("public_id = ?", user.PublicID).First(&erased).Error
+	assert.Nil(t, err)
+	assert.NotNil(t, erased.DeletedAt)
+	assert.NotNil(t, erased.ErasedAt)
+	assert.Equal(t, common.EmailTombstone(API.ErasureSecret, strings.ToLower(in.Email)), erased.Email)
+	assert.Empty(t, erased.FirstName)
+	assert.Empty(t, erased.LastName)
+	assert.Empty(t, erased.Nickname)
+	assert.Empty(t, erased.Phone)
+
+	// test if audit entries are kept without personal values
+	w = request(t, "GET", fmt.Sprintf("/v1/users/%s/history", user.PublicID), "", MockAdminHeaders)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), model.UserAuditErase)
+	assert.NotContains(t, strings.ToLower(w.Body.String()), strings.ToLower(in.Email))
+	assert.NotContains(t, w.Body.String(), in.Nickname)
+
+	// test if erased user can't be restored, but can be erased again
+	w = request(t, "POST", fmt.Sprintf("/v2/users/%s/restore", user.PublicID), "", MockAdminHeaders)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), common.ErrCodeUserErased)
+
+	w = request(t, "POST", fmt.Sprintf("/v2/users/%s/erase", user.PublicID), "", MockAdminHeaders)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	// clean up erased user
+	w = request(t, "DELETE", "/v1/users/"+user.PublicID+"?purge=true", "", MockAdminHeaders)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+}
+
+func TestConsents(t *testing.T) {
+	startup()
+	defer cleanup()
+
+	consents := func(user model.User) map[string]api.UserConsent {
+		var list []api.UserConsent
+		w := request(t, "GET", fmt.Sprintf("/v2/users/%s/consents", user.PublicID), "", MockAdminHeaders)
+		assert.Equal(t, http.StatusOK, w.Code)
+		err := json.NewDecoder(w.Body).Decode(&list)
+		assert.Nil(t, err)
+
+		byType := make(map[string]api.UserConsent)
+		for _, consent := range list {
+			byType[consent.Type] = consent
+		}
+		return byType
+	}
+
+	var in = MockUserInput
+	in.Email = fmt.Sprintf("alex.lokhman.%d@gmail.com", rand.Uint32())
+	in.Nickname = fmt.Sprintf("VisioN%d", rand.Uint32())
+	data, err := json.Marshal(in)
+	assert.Nil(t, err)
+
+	var user model.User
+	w := request(t, "POST", "/v1/users", string(data), MockAdminHeaders)
+	assert.Equal(t, http.StatusOK, w.Code)
+	err = json.NewDecoder(w.Body).Decode(&user)
+	assert.Nil(t, err)
+
+	// test if new version of terms is required
+	version := fmt.Sprintf("v%d", rand.Uint32())
+	w = request(t, "POST", "/v1/policies", fmt.Sprintf(`{"type": "terms", "version": "%s"}`, version), MockAdminHeaders)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	defer API.DB.Where("type = ? AND version = ?", model.ConsentTerms, version).Delete(&model.PolicyVersion{})
+
+	w = request(t, "POST", "/v1/policies", fmt.Sprintf(`{"type": "terms", "version": "%s"}`, version), MockAdminHeaders)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	terms := consents(user)[model.ConsentTerms]
+	assert.Equal(t, version, terms.InForce)
+	assert.True(t, terms.Required)
+
+	// test if login is not allowed until terms are accepted
+	login := func(accept ...string) *httptest.ResponseRecorder {
+		data, err := json.Marshal(api.LoginInput{Email: in.Email, Password: in.Password, Accept: accept})
+		assert.Nil(t, err)
+		return request(t, "POST", "/v1/login", string(data), MockAdminHeaders)
+	}
+	w = login()
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), common.ErrCodeConsentRequired)
+
+	w = request(t, "POST", fmt.Sprintf("/v2/users/%s/consents", user.PublicID), `{"type": "terms", "version": "outdated"}`, MockAdminHeaders)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	w = login(model.ConsentTerms)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	terms = consents(user)[model.ConsentTerms]
+	assert.Equal(t, version, terms.Version)
+	assert.True(t, terms.Granted)
+	assert.False(t, terms.Required)
+	assert.NotNil(t, terms.GivenAt)
+
+	w = login()
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// test if marketing consent may be withdrawn
+	w = request(t, "POST", fmt.Sprintf("/v2/users/%s/consents", user.PublicID), `{"type": "marketing_email", "granted": true}`, MockAdminHeaders)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, consents(user)[model.ConsentMarketingEmail].Granted)
+
+	w = request(t, "POST", fmt.Sprintf("/v2/users/%s/consents", user.PublicID), `{"type": "marketing_email", "granted": false}`, MockAdminHeaders)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.False(t, consents(user)[model.ConsentMarketingEmail].Granted)
+
+	// clean up created user
+	w = request(t, "DELETE", "/v1/users/"+user.PublicID+"?purge=true", "", MockAdminHeaders)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+}
+
+func TestUserDelete(t *testing.T) {
+	startup()
+	defer cleanup()
+
+	req, err := http.NewRequest("DELETE", "/v1/users/"+MockUser.PublicID, nil)
+	assert.Nil(t, err)
+
+	w := httptest.NewRecorder()
+	Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	// test if is soft deleted
+	req, err = http.NewRequest("GET", "/v1/users/"+MockUser.PublicID, nil)
+	assert.Nil(t, err)
+
+	w = httptest.NewRecorder()
+	Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// test if deleted users are listed only for administrators
+	req, err = http.NewRequest("GET", "/v1/users?include_deleted=true", nil)
+	assert.Nil(t, err)
+
+	w = httptest.NewRecorder()
+	Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	req.Header.Set("Authorization", "Bearer "+MockAdminToken)
+	w = httptest.NewRecorder()
+	Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var users []model.User
+	err = json.NewDecoder(w.Body).Decode(&users)
+	assert.Nil(t, err)
+
+	var userFound model.User
+	for _, user := range users {
+		if user.PublicID == MockUser.PublicID {
+			userFound = user
+		}
+	}
+	assert.NotNil(t, userFound.DeletedAt)
+
+	// test restore
+	req, err = http.NewRequest("POST", "/v1/users/"+MockUser.PublicID+"/restore", nil)
+	assert.Nil(t, err)
+
+	w = httptest.NewRecorder()
+	Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var out model.User
+	err = json.NewDecoder(w.Body).Decode(&out)
+	assert.Nil(t, err)
+	assert.Nil(t, out.DeletedAt)
+
+	// test purge
+	req, err = http.NewRequest("DELETE", "/v1/users/"+MockUser.PublicID+"?purge=true", nil)
+	assert.Nil(t, err)
+	req.Header.Set("Authorization", "Bearer "+MockAdminToken)
+
+	w = httptest.NewRecorder()
+	Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	req, err = http.NewRequest("POST", "/v1/users/"+MockUser.PublicID+"/restore", nil)
+	assert.Nil(t, err)
+
+	w = httptest.NewRecorder()
+	Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
 
 	// here we can write more test cases for NSQ publish...
 }
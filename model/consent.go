@@ -0,0 +1,58 @@
+package model
+
+import (
+	"encoding/xml"
+	"time"
+)
+
+const PolicyVersionUniqueConstraintName = "uix_policy_versions_type_version"
+
+// Types of consents: acceptance of legal documents (versioned policies) and marketing consents.
+const (
+	ConsentTerms          = "terms"
+	ConsentPrivacy        = "privacy"
+	ConsentMarketingEmail = "marketing_email"
+	ConsentMarketingSMS   = "marketing_sms"
+)
+
+// All types of consents in the order they are listed.
+var ConsentTypes = []string{ConsentTerms, ConsentPrivacy, ConsentMarketingEmail, ConsentMarketingSMS}
+
+// Checks if the name is known type of consent.
+func IsConsentType(name string) bool {
+	for _, t := range ConsentTypes {
+		if t == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Checks if consent of the type is acceptance of a versioned policy (terms of service or privacy policy).
+func IsPolicyType(name string) bool {
+	return name == ConsentTerms || name == ConsentPrivacy
+}
+
+// Published version of terms of service or privacy policy.
+// The latest published version of each type is in force, users have to accept it on their next login.
+type PolicyVersion struct {
+	XMLName     xml.Name  `gorm:"-" json:"-" xml:"policy"`
+	ID          int       `gorm:"primary_key" json:"-" xml:"-"`
+	Type        string    `gorm:"type:varchar(32); not null; unique_index:uix_policy_versions_type_version" json:"type" xml:"type" example:"terms"`
+	Version     string    `gorm:"type:varchar(32); not null; unique_index:uix_policy_versions_type_version" json:"version" xml:"version" example:"2019-01"`
+	URL         string    `gorm:"type:varchar(512); not null; default:''" json:"url,omitempty" xml:"url,omitempty" example:"https://example.com/terms/2019-01"`
+	PublishedAt time.Time `gorm:"not null; index" json:"published_at" xml:"published_at" example:"2019-01-01T00:00:00Z"`
+}
+
+// Consent given or withdrawn by the user, the version is only set for policies.
+// Records are only appended, so they stay as evidence of when and from which address consents were given,
+// the latest record of each type is in effect.
+type Consent struct {
+	ID        int       `gorm:"primary_key"`
+	UserID    int       `gorm:"not null; index"`
+	Type      string    `gorm:"type:varchar(32); not null"`
+	Version   string    `gorm:"type:varchar(32); not null; default:''"`
+	Granted   bool      `gorm:"not null"`
+	IP        string    `gorm:"type:varchar(45); not null; default:''"`
+	CreatedAt time.Time `gorm:"not null"`
+}
@@ -0,0 +1,28 @@
+package model
+
+import "time"
+
+// Statuses of data export.
+const (
+	DataExportStatusPending   = "pending"
+	DataExportStatusCompleted = "completed"
+	DataExportStatusFailed    = "failed"
+	DataExportStatusExpired   = "expired"
+)
+
+// Export of everything stored about the user (e.g. on a GDPR request), assembled in background into a ZIP blob.
+// Like the audit log, exports have no foreign key, they are deleted with the user on purge.
+type DataExport struct {
+	ID          int    `gorm:"primary_key"`
+	PublicID    string `gorm:"type:uuid; not null; unique_index"`
+	UserID      int    `gorm:"not null; index"`
+	Status      string `gorm:"type:varchar(16); not null; default:'pending'; index"`
+	RequestedBy string `gorm:"type:varchar(128); not null"`
+	BlobKey     string `gorm:"type:varchar(255); not null; default:''"`
+	Size        int64  `gorm:"not null; default:0"`
+	Error       string `gorm:"type:text; not null; default:''"`
+	CompletedAt *time.Time
+	ExpiresAt   *time.Time
+	CreatedAt   time.Time `gorm:"not null"`
+	UpdatedAt   time.Time `gorm:"not null"`
+}
@@ -0,0 +1,23 @@
+package model
+
+import "time"
+
+// Group of users, groups may be nested into a parent group of the same organization.
+// Members of nested groups are members of all parent groups.
+type Group struct {
+	ID             int       `gorm:"primary_key"`
+	PublicID       string    `gorm:"type:uuid; not null; unique_index"`
+	OrganizationID *int      `gorm:"index"`
+	ParentID       *int      `gorm:"index"`
+	Name           string    `gorm:"type:varchar(128); not null"`
+	Description    string    `gorm:"type:text; not null; default:''"`
+	CreatedAt      time.Time `gorm:"not null"`
+	UpdatedAt      time.Time `gorm:"not null"`
+}
+
+// Direct membership of user in the group.
+type GroupMember struct {
+	GroupID   int       `gorm:"primary_key; auto_increment:false"`
+	UserID    int       `gorm:"primary_key; auto_increment:false; index"`
+	CreatedAt time.Time `gorm:"not null"`
+}
@@ -0,0 +1,22 @@
+package model
+
+import "time"
+
+const IdempotencyKeyPrimaryKeyConstraintName = "idempotency_keys_pkey"
+
+// Idempotency key model structure.
+// Stores request fingerprint and cached response to replay it on retries, the key is hashed with its caller.
+// Body is binary, as responses may be encoded with MessagePack.
+type IdempotencyKey struct {
+	Key         string    `gorm:"type:varchar(255); primary_key"`
+	Fingerprint string    `gorm:"type:char(64); not null"`
+	StatusCode  int       `gorm:"not null; default:0"`
+	ContentType string    `gorm:"type:varchar(255); not null; default:''"`
+	Body        []byte    `gorm:"type:bytea; not null; default:''"`
+	ExpiresAt   time.Time `gorm:"not null; index"`
+}
+
+// Checks if the original request is still being processed.
+func (k *IdempotencyKey) IsPending() bool {
+	return k.StatusCode == 0
+}
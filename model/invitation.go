@@ -0,0 +1,28 @@
+package model
+
+import "time"
+
+// Statuses of invitation.
+const (
+	InvitationStatusPending  = "pending"
+	InvitationStatusAccepted = "accepted"
+	InvitationStatusRevoked  = "revoked"
+)
+
+// Invitation of a new user by email, optionally into organization with the role.
+// Only the time of expiry is stored, the token itself is signed (see `common.SignToken`),
+// so a new token (with a new expiry time) makes the previous one invalid.
+type Invitation struct {
+	ID              int       `gorm:"primary_key"`
+	PublicID        string    `gorm:"type:uuid; not null; unique_index"`
+	Email           string    `gorm:"type:varchar(128); not null"`
+	NormalizedEmail string    `gorm:"type:varchar(255); not null; index"`
+	OrganizationID  *int      `gorm:"index"`
+	Role            string    `gorm:"type:varchar(16); not null; default:''"`
+	Status          string    `gorm:"type:varchar(16); not null; default:'pending'"`
+	ExpiresAt       time.Time `gorm:"not null"`
+	UserID          *int      `gorm:"index"`
+	AcceptedAt      *time.Time
+	CreatedAt       time.Time `gorm:"not null"`
+	UpdatedAt       time.Time `gorm:"not null"`
+}
@@ -0,0 +1,183 @@
+package model
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/jinzhu/gorm"
+	"github.com/lokhman/example-users-microservice/common"
+)
+
+// Migrates database schema.
+// GORM takes care of tables and simple indexes, anything else is done with plain SQL.
+func Migrate(db *gorm.DB) error {
+	tables := []interface{}{
+		&User{}, &UserAudit{}, &IdempotencyKey{}, &AttributeSchema{}, &UserPreferences{}, &PhoneVerification{},
+		&Organization{}, &Membership{}, &Group{}, &GroupMember{}, &Invitation{}, &DataExport{},
+		&PolicyVersion{}, &Consent{},
+	}
+	if err := db.AutoMigrate(tables...).Error; err != nil {
+		return err
+	}
+
+	// normalized email must be unique only among users that are not (soft) deleted,
+	// custom attributes are filtered by containment (see `api.ListUsers`)
+	err := db.Exec(`
+		DROP INDEX IF EXISTS uix_users_email;
+		CREATE UNIQUE INDEX IF NOT EXISTS ` + UserEmailUniqueConstraintName + ` ON users (normalized_email) WHERE deleted_at IS NULL;
+		CREATE INDEX IF NOT EXISTS idx_users_attributes ON users USING GIN (attributes jsonb_path_ops);
+	`).Error
+	if err != nil {
+		return err
+	}
+
+	// index of raw emails is replaced by the one of normalized emails once they are filled
+	if err = normalizeUserEmails(db); err != nil {
+		return err
+	}
+	if err = db.Exec("DROP INDEX IF EXISTS uix_users_active_email").Error; err != nil {
+		return err
+	}
+
+	if err = migrateNicknameConstraint(db); err != nil {
+		return err
+	}
+
+	if err = normalizeUserCountries(db); err != nil {
+		return err
+	}
+	if err = migrateIdempotencyKeyBody(db); err != nil {
+		return err
+	}
+
+	// archives of data exports are private, so their public URLs are not stored
+	if err = db.Exec("ALTER TABLE data_exports DROP COLUMN IF EXISTS url").Error; err != nil {
+		return err
+	}
+	return backfillUserPublicIDs(db)
+}
+
+// Normalizes emails of users registered before they were compared by normalized value or normalized with
+// other rules (see `common.EmailRulesVersion`). Rows are updated one by one, so the ones that collide with other
+// users are left empty and logged to be resolved by administrators (see `api.FindEmailDuplicates`).
+// A collision may be with a row that is not normalized yet, so the rows that are left empty are retried
+// while it makes progress, and again on the next startup.
+func normalizeUserEmails(db *gorm.DB) error {
+	for {
+		updated, collisions, err := normalizeUserEmailsPass(db)
+		if err != nil || updated == 0 || collisions == 0 {
+			return err
+		}
+	}
+}
+
+// Normalizes emails of users once, returns the numbers of rows that are updated and that collide with others.
+func normalizeUserEmailsPass(db *gorm.DB) (updated, collisions int, err error) {
+	version := common.EmailRulesVersion()
+	lastID := 0
+	for {
+		var users []User
+		err = db.Unscoped().Select("id, email, normalized_email").
+			Where("(normalized_email IS NULL OR normalized_email_rules <> ?) AND id > ?", version, lastID).
+			Order("id").Limit(1000).Find(&users).Error
+		if err != nil || len(users) == 0 {
+			return
+		}
+
+		for _, user := range users {
+			lastID = user.ID
+			email, err := common.NormalizeEmail(user.Email)
+			if err != nil {
+				log.Printf("[migrate] email of user with ID %d cannot be normalized: %s", user.ID, err)
+				continue
+			}
+			err = db.Exec("UPDATE users SET normalized_email = ?, normalized_email_rules = ? WHERE id = ?", email, version, user.ID).Error
+			if common.IsUniqueConstraintError(err, UserEmailUniqueConstraintName) {
+				log.Printf("[migrate] email of user with ID %d is a duplicate of %s", user.ID, email)
+				collisions++
+
+				// email normalized with other rules must not block the one it collides with now
+				if user.NormalizedEmail != nil {
+					if err = db.Exec("UPDATE users SET normalized_email = NULL WHERE id = ?", user.ID).Error; err != nil {
+						return updated, collisions, err
+					}
+				}
+				continue
+			}
+			if err != nil {
+				return updated, collisions, err
+			}
+			updated++
+		}
+	}
+}
+
+// Creates or drops unique index of nicknames according to `UniqueNicknames` flag.
+// Index cannot be created while nicknames collide, such users have to be renamed before the flag is enabled.
+func migrateNicknameConstraint(db *gorm.DB) error {
+	if !UniqueNicknames {
+		return db.Exec("DROP INDEX IF EXISTS " + UserNicknameUniqueConstraintName).Error
+	}
+	err := db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS " + UserNicknameUniqueConstraintName +
+		" ON users (lower(nickname)) WHERE deleted_at IS NULL").Error
+	if common.IsUniqueConstraintError(err, UserNicknameUniqueConstraintName) {
+		return fmt.Errorf("nicknames of users are not unique: %s", err)
+	}
+	return err
+}
+
+// Normalizes country codes stored before they were validated: upper case with aliases replaced by ISO codes.
+func normalizeUserCountries(db *gorm.DB) error {
+	if err := db.Exec("UPDATE users SET country = upper(country) WHERE country <> upper(country)").Error; err != nil {
+		return err
+	}
+	for alias, code := range common.CountryAliases {
+		if err := db.Exec("UPDATE users SET country = ? WHERE country = ?", code, alias).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Converts cached responses of idempotent requests stored as text before binary bodies were supported.
+func migrateIdempotencyKeyBody(db *gorm.DB) error {
+	var dataType string
+	err := db.Raw("SELECT data_type FROM information_schema.columns WHERE table_name = 'idempotency_keys' AND column_name = 'body'").
+		Row().Scan(&dataType)
+	if err != nil || dataType != "text" {
+		return err
+	}
+	return db.Exec(`
+		ALTER TABLE idempotency_keys ALTER COLUMN body DROP DEFAULT;
+		ALTER TABLE idempotency_keys ALTER COLUMN body TYPE bytea USING convert_to(body, 'UTF8');
+		ALTER TABLE idempotency_keys ALTER COLUMN body SET DEFAULT '';
+	`).Error
+}
+
+// Generates public IDs of users created before they were introduced and makes the column required.
+// PostgreSQL has no UUIDv7 function, so IDs are generated in batches by the application.
+func backfillUserPublicIDs(db *gorm.DB) error {
+	for {
+		var ids []int
+		err := db.Unscoped().Model(&User{}).Where("public_id IS NULL").Limit(1000).Pluck("id", &ids).Error
+		if err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			break
+		}
+
+		tx := db.Begin()
+		for _, id := range ids {
+			if err = tx.Exec("UPDATE users SET public_id = ? WHERE id = ?", common.NewUUIDv7(), id).Error; err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		if err = tx.Commit().Error; err != nil {
+			return err
+		}
+	}
+
+	return db.Exec("ALTER TABLE users ALTER COLUMN public_id SET NOT NULL").Error
+}
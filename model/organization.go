@@ -0,0 +1,38 @@
+package model
+
+import (
+	"encoding/xml"
+	"time"
+)
+
+// Roles of members in organization.
+const (
+	OrgRoleOwner  = "owner"
+	OrgRoleAdmin  = "admin"
+	OrgRoleMember = "member"
+)
+
+// Checks if the name is known role in organization.
+func IsOrgRole(role string) bool {
+	return role == OrgRoleOwner || role == OrgRoleAdmin || role == OrgRoleMember
+}
+
+// Organization (tenant) model structure.
+// Like users, organizations are referred by opaque public IDs, internal IDs are never exposed.
+type Organization struct {
+	XMLName   xml.Name  `gorm:"-" json:"-" xml:"organization"`
+	ID        int       `gorm:"primary_key" json:"-" xml:"-"`
+	PublicID  string    `gorm:"type:uuid; not null; unique_index" json:"id" xml:"id" example:"01890a5d-ac96-774b-bcce-b302099a8057"`
+	Name      string    `gorm:"type:varchar(128); not null" json:"name" xml:"name" example:"Acme Corporation"`
+	CreatedAt time.Time `gorm:"not null" json:"created_at" xml:"created_at" example:"2019-01-01T00:00:00Z"`
+	UpdatedAt time.Time `gorm:"not null" json:"updated_at" xml:"updated_at" example:"2019-01-01T00:00:00Z"`
+}
+
+// Membership of user in organization with the role.
+type Membership struct {
+	OrganizationID int       `gorm:"primary_key; auto_increment:false"`
+	UserID         int       `gorm:"primary_key; auto_increment:false; index"`
+	Role           string    `gorm:"type:varchar(16); not null"`
+	CreatedAt      time.Time `gorm:"not null"`
+	UpdatedAt      time.Time `gorm:"not null"`
+}
@@ -0,0 +1,14 @@
+package model
+
+import "time"
+
+// Phone verification model structure.
+// Keeps hash of the code sent to the phone of the user, there is only one pending verification per user.
+type PhoneVerification struct {
+	UserID    int       `gorm:"primary_key; auto_increment:false"`
+	Phone     string    `gorm:"type:varchar(16); not null"`
+	CodeHash  string    `gorm:"type:char(64); not null"`
+	Attempts  int       `gorm:"not null; default:0"`
+	ExpiresAt time.Time `gorm:"not null"`
+	CreatedAt time.Time `gorm:"not null"`
+}
@@ -1,15 +1,62 @@
 package model
 
-const UserEmailUniqueConstraintName = "uix_users_email"
+import (
+	"encoding/xml"
+	"time"
+)
+
+const (
+	UserEmailUniqueConstraintName    = "uix_users_active_normalized_email"
+	UserNicknameUniqueConstraintName = "uix_users_active_nickname"
+)
+
+// Makes nicknames unique in any case among users that are not deleted, the flag can be changed on startup.
+var UniqueNicknames = false
 
 // User model structure.
-// We don't use gorm.Model as it doesn't nicely translate JSON fields.
+// We don't use gorm.Model as it doesn't nicely translate JSON fields, timestamps are declared explicitly.
+// `ID` is internal, clients refer to users by opaque `PublicID` (UUIDv7) that doesn't reveal signup volume.
+// Users are soft deleted: GORM sets `DeletedAt` instead of deleting rows and skips them in queries.
 type User struct {
-	ID        int    `gorm:"primary_key" json:"id" example:"1"`
-	Email     string `gorm:"type:varchar(128); unique_index; not null" json:"email" example:"alex.lokhman@gmail.com"`
-	Password  string `gorm:"type:char(60); not null" json:"-" example:"MyPassword"`
-	FirstName string `gorm:"type:varchar(72); not null" json:"first_name" example:"Alex"`
-	LastName  string `gorm:"type:varchar(72); not null" json:"last_name" example:"Lokhman"`
-	Nickname  string `gorm:"type:varchar(32); not null" json:"nickname" example:"VisioN"`
-	Country   string `gorm:"type:char(2); not null" json:"country" example:"RU"`
+	XMLName   xml.Name   `gorm:"-" json:"-" xml:"user"`
+	ID        int        `gorm:"primary_key" json:"-" xml:"-"`
+	PublicID  string     `gorm:"type:uuid; unique_index" json:"public_id" xml:"public_id" example:"01890a5d-ac96-774b-bcce-b302099a8057"`
+	Email     string     `gorm:"type:varchar(128); not null" json:"email" xml:"email" example:"alex.lokhman@gmail.com"`
+	Password  string     `gorm:"type:char(60); not null" json:"-" xml:"-" example:"MyPassword"`
+	FirstName string     `gorm:"type:varchar(72); not null" json:"first_name" xml:"first_name" example:"Alex"`
+	LastName  string     `gorm:"type:varchar(72); not null" json:"last_name" xml:"last_name" example:"Lokhman"`
+	Nickname  string     `gorm:"type:varchar(32); not null" json:"nickname" xml:"nickname" example:"VisioN"`
+	Country   string     `gorm:"type:char(2); not null" json:"country" xml:"country" example:"RU"`
+	DeletedAt *time.Time `gorm:"index" json:"deleted_at,omitempty" xml:"deleted_at,omitempty"`
+
+	// phone in E.164 format (empty if not set) and the time it was verified by SMS code (see `api.VerifyUserPhone`)
+	Phone           string     `gorm:"type:varchar(16); not null; default:''" json:"phone,omitempty" xml:"phone,omitempty" example:"+79161234567"`
+	PhoneVerifiedAt *time.Time `json:"phone_verified_at,omitempty" xml:"phone_verified_at,omitempty" example:"2019-01-01T00:00:00Z"`
+
+	// status with the reason and the time it expires at (see `CanChangeUserStatus`)
+	Status          string     `gorm:"type:varchar(16); not null; default:'active'; index" json:"status" xml:"status" example:"active"`
+	StatusReason    string     `gorm:"type:varchar(255); not null; default:''" json:"status_reason,omitempty" xml:"status_reason,omitempty" example:"Spam"`
+	StatusExpiresAt *time.Time `gorm:"index" json:"status_expires_at,omitempty" xml:"status_expires_at,omitempty" example:"2019-01-01T00:00:00Z"`
+
+	// avatar is stored in the blob storage under `AvatarKey` prefix (see `api.SetUserAvatar`)
+	AvatarKey        string           `gorm:"type:varchar(255); not null; default:''" json:"-" xml:"-"`
+	AvatarURL        string           `gorm:"type:varchar(512); not null; default:''" json:"avatar_url,omitempty" xml:"avatar_url,omitempty" example:"/blobs/avatars/01890a5d-ac96-774b-bcce-b302099a8057/original.jpg"`
+	AvatarThumbnails AvatarThumbnails `gorm:"type:jsonb" json:"avatar_thumbnails,omitempty" xml:"avatar_thumbnails>thumbnail,omitempty"`
+
+	// custom attributes by namespace, values are validated by `AttributeSchema` of the namespace
+	Attributes UserAttributes `gorm:"type:jsonb; not null; default:'{}'" json:"attributes" xml:"attributes" swaggertype:"object"`
+
+	// time personal data of the user was erased (see `api.EraseUser`), erased users stay deleted and are not purged
+	// after retention period (see `api.PurgeDeletedUsers`), but administrators may still purge them explicitly
+	ErasedAt *time.Time `gorm:"index" json:"erased_at,omitempty" xml:"erased_at,omitempty" example:"2019-01-01T00:00:00Z"`
+
+	// email as it is compared for uniqueness (see `common.NormalizeEmail`) and version of the rules it was normalized with,
+	// it is empty only for users whose emails collide with others after normalization (see `api.FindEmailDuplicates`)
+	NormalizedEmail      *string `gorm:"type:varchar(255)" json:"-" xml:"-"`
+	NormalizedEmailRules int     `gorm:"not null; default:0" json:"-" xml:"-"`
+
+	// GORM sets `CreatedAt` and `UpdatedAt` on save, existing rows get the time of migration
+	CreatedAt   time.Time  `gorm:"not null; default:now(); index" json:"created_at" xml:"created_at" example:"2019-01-01T00:00:00Z"`
+	UpdatedAt   time.Time  `gorm:"not null; default:now(); index" json:"updated_at" xml:"updated_at" example:"2019-01-01T00:00:00Z"`
+	LastLoginAt *time.Time `gorm:"index" json:"last_login_at" xml:"last_login_at,omitempty" example:"2019-01-01T00:00:00Z"`
 }
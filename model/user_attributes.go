@@ -0,0 +1,130 @@
+package model
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// Namespaces of custom attributes are owned by product teams, e.g. "marketing" or "billing".
+var attributeNamespaceRegexp = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+
+// Checks if the name is valid namespace of custom attributes.
+func IsAttributeNamespace(name string) bool {
+	return attributeNamespaceRegexp.MatchString(name)
+}
+
+// JSON Schema of custom user attributes in the namespace.
+// Values are validated on write, so changing the schema doesn't affect values already stored.
+type AttributeSchema struct {
+	XMLName   xml.Name  `gorm:"-" json:"-" xml:"schema"`
+	Namespace string    `gorm:"type:varchar(64); primary_key" json:"namespace" xml:"namespace" example:"billing"`
+	Schema    RawJSON   `gorm:"type:jsonb; not null" json:"schema" xml:"definition" swaggertype:"object"`
+	CreatedAt time.Time `gorm:"not null" json:"created_at" xml:"created_at" example:"2019-01-01T00:00:00Z"`
+	UpdatedAt time.Time `gorm:"not null" json:"updated_at" xml:"updated_at" example:"2019-01-01T00:00:00Z"`
+}
+
+// Raw JSON document, stored as JSONB.
+type RawJSON json.RawMessage
+
+// Implements `json.Marshaler` interface.
+func (j RawJSON) MarshalJSON() ([]byte, error) {
+	if len(j) == 0 {
+		return []byte("null"), nil
+	}
+	return j, nil
+}
+
+// Implements `json.Unmarshaler` interface.
+func (j *RawJSON) UnmarshalJSON(data []byte) error {
+	*j = append((*j)[:0], data...)
+	return nil
+}
+
+// Implements `xml.Marshaler` interface, document is written as text.
+func (j RawJSON) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return e.EncodeElement(string(j), start)
+}
+
+// Implements `driver.Valuer` interface.
+func (j RawJSON) Value() (driver.Value, error) {
+	if len(j) == 0 {
+		return nil, nil
+	}
+	return string(j), nil
+}
+
+// Implements `sql.Scanner` interface.
+func (j *RawJSON) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case []byte:
+		*j = append((*j)[:0], v...)
+		return nil
+	case string:
+		*j = RawJSON(v)
+		return nil
+	}
+	return errors.New("model: cannot scan raw JSON")
+}
+
+// Custom attributes of user by namespace, stored as JSONB.
+type UserAttributes map[string]interface{}
+
+// Implements `json.Marshaler` interface, user without attributes has empty object.
+func (a UserAttributes) MarshalJSON() ([]byte, error) {
+	if a == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(map[string]interface{}(a))
+}
+
+// Implements `xml.Marshaler` interface, as maps cannot be marshalled to XML.
+// Values of namespaces are written as JSON text.
+func (a UserAttributes) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	namespaces := make([]string, 0, len(a))
+	for namespace := range a {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+
+	type attribute struct {
+		Namespace string `xml:"namespace,attr"`
+		Value     string `xml:",chardata"`
+	}
+	attributes := make([]attribute, len(namespaces))
+	for i, namespace := range namespaces {
+		data, err := json.Marshal(a[namespace])
+		if err != nil {
+			return err
+		}
+		attributes[i] = attribute{Namespace: namespace, Value: string(data)}
+	}
+	return e.EncodeElement(struct {
+		Attributes []attribute `xml:"attribute"`
+	}{attributes}, start)
+}
+
+// Implements `driver.Valuer` interface.
+func (a UserAttributes) Value() (driver.Value, error) {
+	data, err := a.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Implements `sql.Scanner` interface.
+func (a *UserAttributes) Scan(src interface{}) error {
+	*a = nil
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, a)
+	case string:
+		return json.Unmarshal([]byte(v), a)
+	}
+	return errors.New("model: cannot scan user attributes")
+}
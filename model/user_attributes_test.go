@@ -0,0 +1,52 @@
+// +build !integration
+
+// Run this simple unit test with the following command:
+// $ docker-compose run app go test -v ./...
+
+package model
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsAttributeNamespace(t *testing.T) {
+	assert.True(t, IsAttributeNamespace("billing"))
+	assert.True(t, IsAttributeNamespace("opt_in2"))
+	assert.False(t, IsAttributeNamespace("Billing"))
+	assert.False(t, IsAttributeNamespace("2fa"))
+	assert.False(t, IsAttributeNamespace(""))
+}
+
+func TestUserAttributes(t *testing.T) {
+	// user without attributes has empty object
+	data, err := json.Marshal(User{})
+	assert.Nil(t, err)
+	assert.Contains(t, string(data), `"attributes":{}`)
+
+	var attributes UserAttributes
+	assert.Nil(t, attributes.Scan([]byte(`{"billing": {"tier": "gold"}}`)))
+	assert.Equal(t, UserAttributes{"billing": map[string]interface{}{"tier": "gold"}}, attributes)
+
+	value, err := attributes.Value()
+	assert.Nil(t, err)
+	assert.Equal(t, `{"billing":{"tier":"gold"}}`, value)
+
+	data, err = xml.Marshal(User{Attributes: attributes})
+	assert.Nil(t, err)
+	assert.Contains(t, string(data), `<attributes><attribute namespace="billing">{&#34;tier&#34;:&#34;gold&#34;}</attribute></attributes>`)
+}
+
+func TestRawJSON(t *testing.T) {
+	schema := AttributeSchema{Namespace: "billing", Schema: RawJSON(`{"type":"object"}`)}
+	data, err := json.Marshal(schema)
+	assert.Nil(t, err)
+	assert.Contains(t, string(data), `"schema":{"type":"object"}`)
+
+	var out AttributeSchema
+	assert.Nil(t, json.Unmarshal(data, &out))
+	assert.Equal(t, schema.Schema, out.Schema)
+}
@@ -0,0 +1,165 @@
+package model
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// Actions recorded in the audit log of users.
+const (
+	UserAuditCreate        = "create"
+	UserAuditUpdate        = "update"
+	UserAuditDelete        = "delete"
+	UserAuditRestore       = "restore"
+	UserAuditPurge         = "purge"
+	UserAuditStatusChanged = "status_changed"
+	UserAuditErase         = "erase"
+)
+
+// Fields that are not worth recording: internal ID never changes and `updated_at` changes on every save.
+var userAuditIgnoredFields = map[string]bool{"id": true, "updated_at": true}
+
+// Fields with personal data, their values are scrubbed from the audit log when the user is erased.
+var userAuditPersonalFields = []string{
+	"email", "first_name", "last_name", "nickname", "phone", "status_reason", "avatar_url", "avatar_thumbnails", "attributes",
+}
+
+// Audit log entry of user changes.
+// Entries are only appended and outlive the user, so there is no foreign key.
+type UserAudit struct {
+	XMLName   xml.Name         `gorm:"-" json:"-" xml:"entry"`
+	ID        int              `gorm:"primary_key" json:"id" xml:"id" example:"1"`
+	UserID    int              `gorm:"not null; index" json:"-" xml:"-"`
+	Action    string           `gorm:"type:varchar(32); not null" json:"action" xml:"action" example:"update"`
+	Actor     string           `gorm:"type:varchar(128); not null" json:"actor" xml:"actor" example:"admin"`
+	ActorIP   string           `gorm:"type:varchar(45); not null; default:''" json:"actor_ip,omitempty" xml:"actor_ip,omitempty" example:"127.0.0.1"`
+	RequestID string           `gorm:"type:varchar(128); not null; default:''" json:"request_id,omitempty" xml:"request_id,omitempty" example:"01890a5d-ac96-774b-bcce-b302099a8057"`
+	Changes   UserAuditChanges `gorm:"type:jsonb; not null" json:"changes" xml:"changes"`
+	CreatedAt time.Time        `gorm:"not null; index" json:"created_at" xml:"created_at" example:"2019-01-01T00:00:00Z"`
+}
+
+// Names root element of XML list of entries.
+func (UserAudit) XMLListName() string {
+	return "history"
+}
+
+func (UserAudit) TableName() string {
+	return "user_audit"
+}
+
+// Change of a single user field (values are JSON representation of the field).
+type UserAuditChange struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// Changes of user fields by JSON name, stored as JSONB.
+type UserAuditChanges map[string]UserAuditChange
+
+// Compares two states of the user, either may be nil (e.g. before creation).
+// Password hash is never recorded, as it is excluded from JSON representation.
+func DiffUsers(before, after *User) (UserAuditChanges, error) {
+	a, err := userFields(before)
+	if err != nil {
+		return nil, err
+	}
+	b, err := userFields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make(UserAuditChanges)
+	for _, fields := range []map[string]interface{}{a, b} {
+		for name := range fields {
+			if userAuditIgnoredFields[name] || reflect.DeepEqual(a[name], b[name]) {
+				continue
+			}
+			changes[name] = UserAuditChange{Before: a[name], After: b[name]}
+		}
+	}
+	return changes, nil
+}
+
+// Removes values of fields with personal data, the fact that they were changed is kept.
+func (c UserAuditChanges) Scrub() {
+	for _, name := range userAuditPersonalFields {
+		if _, ok := c[name]; ok {
+			c[name] = UserAuditChange{}
+		}
+	}
+}
+
+// Returns fields of the user by JSON names.
+func userFields(user *User) (map[string]interface{}, error) {
+	fields := make(map[string]interface{})
+	if user == nil {
+		return fields, nil
+	}
+
+	data, err := json.Marshal(user)
+	if err != nil {
+		return nil, err
+	}
+
+	// numbers are kept as is, e.g. big IDs are not turned into floats
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return fields, dec.Decode(&fields)
+}
+
+// Implements `driver.Valuer` interface.
+func (c UserAuditChanges) Value() (driver.Value, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Implements `sql.Scanner` interface.
+func (c *UserAuditChanges) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, c)
+	case string:
+		return json.Unmarshal([]byte(v), c)
+	}
+	return errors.New("model: cannot scan user audit changes")
+}
+
+// Implements `xml.Marshaler` interface, as maps cannot be marshalled to XML.
+func (c UserAuditChanges) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	names := make([]string, 0, len(c))
+	for name := range c {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	type change struct {
+		Field  string `xml:"field,attr"`
+		Before string `xml:"before,omitempty"`
+		After  string `xml:"after,omitempty"`
+	}
+	changes := make([]change, len(names))
+	for i, name := range names {
+		changes[i] = change{Field: name, Before: xmlValue(c[name].Before), After: xmlValue(c[name].After)}
+	}
+	return e.EncodeElement(struct {
+		Changes []change `xml:"change"`
+	}{changes}, start)
+}
+
+// Formats JSON value for XML, null is empty.
+func xmlValue(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,74 @@
+// +build !integration
+
+// Run this simple unit test with the following command:
+// $ docker-compose run app go test -v ./...
+
+package model
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffUsers(t *testing.T) {
+	before := User{ID: 1, Email: "alex@example.com", Password: "hash", FirstName: "Alex", UpdatedAt: time.Now()}
+	after := before
+	after.Email = "alex.lokhman@example.com"
+	after.Password = "new hash"
+	after.UpdatedAt = before.UpdatedAt.Add(time.Second)
+
+	// password hash and updated time are not recorded
+	changes, err := DiffUsers(&before, &after)
+	assert.Nil(t, err)
+	assert.Equal(t, UserAuditChanges{
+		"email": {Before: "alex@example.com", After: "alex.lokhman@example.com"},
+	}, changes)
+
+	// everything is recorded on creation
+	changes, err = DiffUsers(nil, &after)
+	assert.Nil(t, err)
+	assert.Equal(t, "Alex", changes["first_name"].After)
+	assert.Nil(t, changes["first_name"].Before)
+	assert.NotContains(t, changes, "id")
+	assert.NotContains(t, changes, "password")
+}
+
+func TestUserAuditChanges(t *testing.T) {
+	changes := UserAuditChanges{"email": {Before: "a@example.com", After: "b@example.com"}}
+
+	// database value
+	value, err := changes.Value()
+	assert.Nil(t, err)
+
+	var scanned UserAuditChanges
+	err = scanned.Scan([]byte(value.(string)))
+	assert.Nil(t, err)
+	assert.Equal(t, changes, scanned)
+
+	// JSON and XML representation
+	data, err := json.Marshal(changes)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"email":{"before":"a@example.com","after":"b@example.com"}}`, string(data))
+
+	data, err = xml.Marshal(UserAudit{Changes: changes})
+	assert.Nil(t, err)
+	assert.Contains(t, string(data), `<changes><change field="email"><before>a@example.com</before><after>b@example.com</after></change></changes>`)
+}
+
+func TestUserAuditChangesScrub(t *testing.T) {
+	changes := UserAuditChanges{
+		"email":  {Before: "a@example.com", After: "b@example.com"},
+		"status": {Before: "active", After: "banned"},
+	}
+
+	// changed personal fields are kept without values
+	changes.Scrub()
+	assert.Equal(t, UserAuditChanges{
+		"email":  {},
+		"status": {Before: "active", After: "banned"},
+	}, changes)
+}
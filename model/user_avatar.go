@@ -0,0 +1,42 @@
+package model
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+)
+
+// Square thumbnail of user avatar.
+type AvatarThumbnail struct {
+	Size int    `json:"size" xml:"size,attr" example:"64"`
+	URL  string `json:"url" xml:",chardata" example:"/blobs/avatars/01890a5d-ac96-774b-bcce-b302099a8057/64.jpg"`
+}
+
+// Thumbnails of user avatar from the smallest, stored as JSONB.
+type AvatarThumbnails []AvatarThumbnail
+
+// Implements `driver.Valuer` interface, users without avatar have NULL.
+func (t AvatarThumbnails) Value() (driver.Value, error) {
+	if len(t) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(t)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Implements `sql.Scanner` interface.
+func (t *AvatarThumbnails) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*t = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, t)
+	case string:
+		return json.Unmarshal([]byte(v), t)
+	}
+	return errors.New("model: cannot scan avatar thumbnails")
+}
@@ -0,0 +1,93 @@
+package model
+
+import (
+	"encoding/xml"
+	"time"
+)
+
+// Themes of user interface.
+const (
+	ThemeLight  = "light"
+	ThemeDark   = "dark"
+	ThemeSystem = "system"
+)
+
+// Checks if the name is known theme.
+func IsTheme(name string) bool {
+	return name == ThemeLight || name == ThemeDark || name == ThemeSystem
+}
+
+// Preferences of users who never changed them, missing values of stored preferences fall back to these.
+var DefaultPreferences = Preferences{
+	Locale:   "en",
+	Timezone: "UTC",
+	Theme:    ThemeSystem,
+	Notifications: NotificationPreferences{
+		Email:     true,
+		Push:      true,
+		SMS:       false,
+		Marketing: false,
+	},
+}
+
+// Preferences of the user with defaults applied.
+type Preferences struct {
+	XMLName       xml.Name                `json:"-" xml:"preferences"`
+	Locale        string                  `json:"locale" xml:"locale" example:"en-GB"`
+	Timezone      string                  `json:"timezone" xml:"timezone" example:"Europe/London"`
+	Theme         string                  `json:"theme" xml:"theme" example:"dark"`
+	Notifications NotificationPreferences `json:"notifications" xml:"notifications"`
+}
+
+// Notification toggles of the user.
+type NotificationPreferences struct {
+	Email     bool `json:"email" xml:"email" example:"true"`
+	Push      bool `json:"push" xml:"push" example:"true"`
+	SMS       bool `json:"sms" xml:"sms" example:"false"`
+	Marketing bool `json:"marketing" xml:"marketing" example:"false"`
+}
+
+// Stored preferences of the user, NULL means the value was never set and the default is used,
+// so changing a default in code affects all users who didn't choose otherwise.
+type UserPreferences struct {
+	UserID          int     `gorm:"primary_key; auto_increment:false"`
+	Locale          *string `gorm:"type:varchar(35)"`
+	Timezone        *string `gorm:"type:varchar(64)"`
+	Theme           *string `gorm:"type:varchar(16)"`
+	NotifyEmail     *bool
+	NotifyPush      *bool
+	NotifySMS       *bool `gorm:"column:notify_sms"`
+	NotifyMarketing *bool
+	UpdatedAt       time.Time `gorm:"not null"`
+}
+
+func (UserPreferences) TableName() string {
+	return "user_preferences"
+}
+
+// Returns preferences with defaults applied to the values that were never set.
+func (p *UserPreferences) Resolve() Preferences {
+	out := DefaultPreferences
+	if p.Locale != nil {
+		out.Locale = *p.Locale
+	}
+	if p.Timezone != nil {
+		out.Timezone = *p.Timezone
+	}
+	if p.Theme != nil {
+		out.Theme = *p.Theme
+	}
+	if p.NotifyEmail != nil {
+		out.Notifications.Email = *p.NotifyEmail
+	}
+	if p.NotifyPush != nil {
+		out.Notifications.Push = *p.NotifyPush
+	}
+	if p.NotifySMS != nil {
+		out.Notifications.SMS = *p.NotifySMS
+	}
+	if p.NotifyMarketing != nil {
+		out.Notifications.Marketing = *p.NotifyMarketing
+	}
+	return out
+}
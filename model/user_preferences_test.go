@@ -0,0 +1,36 @@
+// +build !integration
+
+// Run this simple unit test with the following command:
+// $ docker-compose run app go test -v ./...
+
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserPreferencesResolve(t *testing.T) {
+	var p UserPreferences
+	assert.Equal(t, DefaultPreferences, p.Resolve())
+
+	theme, push := ThemeDark, false
+	p.Theme = &theme
+	p.NotifyPush = &push
+
+	out := p.Resolve()
+	assert.Equal(t, ThemeDark, out.Theme)
+	assert.False(t, out.Notifications.Push)
+	assert.Equal(t, DefaultPreferences.Locale, out.Locale)
+	assert.Equal(t, DefaultPreferences.Notifications.Email, out.Notifications.Email)
+
+	// defaults are not modified
+	assert.Equal(t, ThemeSystem, DefaultPreferences.Theme)
+	assert.True(t, DefaultPreferences.Notifications.Push)
+}
+
+func TestIsTheme(t *testing.T) {
+	assert.True(t, IsTheme(ThemeDark))
+	assert.False(t, IsTheme("pink"))
+}
@@ -0,0 +1,34 @@
+package model
+
+// User statuses.
+const (
+	UserStatusPending   = "pending"
+	UserStatusActive    = "active"
+	UserStatusSuspended = "suspended"
+	UserStatusBanned    = "banned"
+)
+
+// Statuses user may change to from every status.
+// Suspension may be changed to another suspension, e.g. to extend it or to change the reason.
+var userStatusTransitions = map[string][]string{
+	UserStatusPending:   {UserStatusActive, UserStatusBanned},
+	UserStatusActive:    {UserStatusSuspended, UserStatusBanned},
+	UserStatusSuspended: {UserStatusActive, UserStatusSuspended, UserStatusBanned},
+	UserStatusBanned:    {UserStatusActive},
+}
+
+// Checks if user status is known.
+func IsUserStatus(status string) bool {
+	_, ok := userStatusTransitions[status]
+	return ok
+}
+
+// Checks if user may change status from one to another.
+func CanChangeUserStatus(from, to string) bool {
+	for _, status := range userStatusTransitions[from] {
+		if status == to {
+			return true
+		}
+	}
+	return false
+}